@@ -0,0 +1,37 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// adminToken is the bearer token of the changes to the proxy, they are refused
+// while it is empty.
+var adminToken string
+
+// admin lets through the GET requests and the other requests that carry the
+// admin token, as the admin paths are served on the public listener.
+func admin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			next(w, r)
+			return
+		}
+
+		if adminToken == "" {
+			answer(w, http.StatusForbidden, fmt.Errorf("changes are disabled, start the proxy with -admin-token"))
+			return
+		}
+
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			answer(w, http.StatusUnauthorized, fmt.Errorf("send the admin token as Authorization: Bearer <token>"))
+			return
+		}
+
+		next(w, r)
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+const (
+	CACHE_PATH string = "/proxy/cache"
+	PURGE_PATH string = "/proxy/cache/purge"
+)
+
+// cacheStats answers the counters of the GET cache.
+func cacheStats(w http.ResponseWriter, r *http.Request) {
+	if responses == nil {
+		answer(w, http.StatusNotFound, fmt.Errorf("the cache is disabled, start the proxy with -cache"))
+		return
+	}
+
+	answer(w, http.StatusOK, responses.Stats())
+}
+
+// purgeCache drops the kept answers of the URLs starting with ?prefix=, all of
+// them without it.
+func purgeCache(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		answer(w, http.StatusMethodNotAllowed, fmt.Errorf("purge with POST"))
+		return
+	}
+	if responses == nil {
+		answer(w, http.StatusNotFound, fmt.Errorf("the cache is disabled, start the proxy with -cache"))
+		return
+	}
+
+	purged := responses.Purge(r.URL.Query().Get("prefix"))
+
+	answer(w, http.StatusOK, map[string]int{"purged": purged})
+}
@@ -1,30 +1,125 @@
 package main
 
 import (
-	"bytes"
+	"crypto/tls"
+	"flag"
 	"fmt"
 	"gin-server/internal/errors"
-	"gin-server/internal/provider"
-	"io/ioutil"
+	"gin-server/internal/h2"
+	"gin-server/internal/httpcache"
+	"gin-server/internal/routing"
+	"gin-server/internal/tlsconfig"
 	"log"
 	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
 	"sync"
 )
 
-var pr provider.Hosts
+var routes *routing.Table
+var responses *httpcache.Cache
 var httpErr errors.HTTPErrors
 
+// upstream carries the requests to the backends and the status probes.
+var upstream *h2.Transport
+
+// verbose logs where every request is sent.
+var verbose bool
+
 const (
 	addr string = "localhost:8080"
 )
 
 func main() {
-	pr = provider.NewProvider()
+	cacheSize := flag.Int("cache", 0, "number of GET answers the proxy keeps, 0 disables the cache")
+	cacheMaxBody := flag.Int("cache-max-body", httpcache.DEFAULT_MAX_BODY, "largest body of a kept answer in bytes")
+	backends := flag.String("backends", "http://localhost:8000,http://localhost:9000", "comma separated addresses of the default backend pool")
+	routesFile := flag.String("routes", "", "YAML file of backend pools and the rules choosing them")
+	tlsCert := flag.String("tls-cert", "", "certificate file, the proxy serves TLS when set")
+	tlsKey := flag.String("tls-key", "", "key file of -tls-cert")
+	tlsReload := flag.Duration("tls-reload", tlsconfig.RELOAD_INTERVAL, "how often the certificate files are checked for changes")
+	backendCA := flag.String("backend-ca", "", "CA file verifying https backends instead of the system roots")
+	backendCert := flag.String("backend-cert", "", "client certificate file presented to https backends")
+	backendKey := flag.String("backend-key", "", "key file of -backend-cert")
+	backendH2C := flag.Bool("backend-h2c", false, "speak cleartext HTTP/2 to http backends")
+	flag.BoolVar(&verbose, "verbose", false, "log the backend of every request")
+	flag.StringVar(&adminToken, "admin-token", "", "bearer token of cache purges and split weight changes, they are refused when empty")
+
+	flag.Parse()
+
+	var config routing.Config
+	var err error
+	if *routesFile != "" {
+		config, err = routing.Load(*routesFile)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	var defaults []string
+	for _, backend := range strings.Split(*backends, ",") {
+		if backend = strings.TrimSpace(backend); backend != "" {
+			defaults = append(defaults, backend)
+		}
+	}
+
+	routes, err = routing.New(config, defaults)
+	if err != nil {
+		log.Fatalln(err)
+	}
 
-	pr.Add("http://localhost:8000")
-	pr.Add("http://localhost:9000")
+	var backendTLS *tls.Config
+	if *backendCA != "" || *backendCert != "" {
+		backendTLS, err = tlsconfig.Client(*backendCA, *backendCert, *backendKey)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	upstream, err = h2.NewTransport(backendTLS, *backendH2C)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	probes = &http.Client{Timeout: STATUS_TIMEOUT, Transport: upstream}
+
+	server := &http.Server{Addr: addr, Handler: h2.Handler(http.DefaultServeMux)}
+	scheme := "http"
+	if *tlsCert != "" {
+		reloader, err := tlsconfig.NewReloader(*tlsCert, *tlsKey)
+		if err != nil {
+			log.Fatalln(err)
+		}
+
+		server.TLSConfig, err = tlsconfig.Server(reloader, "")
+		if err != nil {
+			log.Fatalln(err)
+		}
+
+		stop := make(chan struct{})
+		defer close(stop)
+
+		go reloader.Run(*tlsReload, stop)
+		scheme = "https"
+
+		if err := h2.ConfigureServer(server); err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	// the cache is behind the routing, answers of different pools are kept apart
+	var handler http.Handler = newProxy()
+	if *cacheSize > 0 {
+		responses = httpcache.New(handler, httpcache.Config{Size: *cacheSize, MaxBody: *cacheMaxBody, Variant: variant})
+		handler = responses
+	}
 
-	http.HandleFunc("/", handler)
+	http.Handle("/", route(handler))
+	http.HandleFunc(STATUS_PATH, backendStatus)
+	http.HandleFunc(CACHE_PATH, cacheStats)
+	http.HandleFunc(PURGE_PATH, admin(purgeCache))
+	http.HandleFunc(SPLITS_PATH, admin(splits))
+	http.HandleFunc(SPLITS_PATH+"/", admin(splits))
 
 	var httpServerError = make(chan error)
 	var wg sync.WaitGroup
@@ -34,73 +129,94 @@ func main() {
 	go func() {
 		defer wg.Done()
 
-		httpServerError <- http.ListenAndServe(addr, nil)
+		if server.TLSConfig != nil {
+			httpServerError <- server.ListenAndServeTLS("", "")
+		} else {
+			httpServerError <- server.ListenAndServe()
+		}
 	}()
 
 	select {
 	case err := <-httpServerError:
 		log.Fatalln(err)
 	default:
-		fmt.Printf("served on: http://%s\n", addr)
+		fmt.Printf("served on: %s://%s\n", scheme, addr)
 	}
 
 	wg.Wait()
 }
 
-func handler(w http.ResponseWriter, r *http.Request) {
-	reqBytes, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		log.Fatalln(err)
-		return
+// newProxy forwards every request to the next host. Responses are flushed as
+// they arrive, so event streams pass through, and upgraded connections such
+// as WebSockets are tunnelled to the host that accepted the upgrade.
+func newProxy() *httputil.ReverseProxy {
+	return &httputil.ReverseProxy{
+		Director:       director,
+		Transport:      upstream,
+		FlushInterval:  -1,
+		ModifyResponse: countAnswer,
+		ErrorHandler:   proxyError,
 	}
+}
 
-	defer r.Body.Close()
-
-	host := pr.GetHost() + r.URL.Path
+// route matches the request against the routing table before it is
+// forwarded, so the answer can be counted for the pool that gave it.
+func route(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(routing.NewContext(r.Context(), routes.Match(r))))
+	})
+}
 
-	fmt.Println(host)
+// variant keys the kept answers by the pool of the route and the forwarded
+// path, two rules may send the same URL to different backends.
+func variant(r *http.Request) string {
+	route := routing.FromContext(r.Context())
+	if route == nil {
+		return ""
+	}
 
-	resp, err := proxyRedirect(w, r.Method, host, reqBytes)
+	return route.Pool + " " + route.Path(r.URL.Path)
+}
 
+// director sends the request to the next host of the pool its route chooses,
+// with the path the route forwards.
+func director(r *http.Request) {
+	route := routing.FromContext(r.Context())
+	host, err := url.Parse(route.Host())
 	if err != nil {
-		httpErr.InternalError(w, err)
-		log.Fatalln(err)
+		log.Println(err)
 		return
 	}
 
-	respBody, err := ioutil.ReadAll(resp.Body)
-
-	if err != nil {
-		httpErr.InternalError(w, err)
-		log.Fatalln(err)
+	if path := route.Path(r.URL.Path); path != r.URL.Path {
+		r.URL.Path = path
+		r.URL.RawPath = ""
+	}
 
-		return
+	if verbose {
+		log.Printf("Redirect to %s%s (%s)", host, r.URL.Path, route.Pool)
 	}
-	defer resp.Body.Close()
 
-	w.WriteHeader(resp.StatusCode)
-	w.Write(respBody)
+	// the backend checks the Origin of WebSocket streams against it
+	r.Header.Set("X-Forwarded-Host", r.Host)
+	r.URL.Scheme = host.Scheme
+	r.URL.Host = host.Host
+	r.Host = host.Host
 }
 
-func proxyRedirect(w http.ResponseWriter, method, host string, body []byte) (*http.Response, error) {
-	fmt.Printf("Redirect to %s\n", host)
-
-	var resp *http.Response
-	var err error
-
-	client := &http.Client{}
-
-	req, err := http.NewRequest(method, host, bytes.NewBuffer(body))
-	if err != nil {
-		return nil, err
+func countAnswer(resp *http.Response) error {
+	if route := routing.FromContext(resp.Request.Context()); route != nil {
+		route.Done(resp.StatusCode)
 	}
 
-	req.Header.Set("Content-Type", "application/json")
+	return nil
+}
 
-	resp, err = client.Do(req)
-	if err != nil {
-		return nil, err
+func proxyError(w http.ResponseWriter, r *http.Request, err error) {
+	log.Println(err)
+	// a client that went away says nothing about the backend
+	if route := routing.FromContext(r.Context()); route != nil && r.Context().Err() == nil {
+		route.Done(http.StatusBadGateway)
 	}
-
-	return resp, nil
+	httpErr.InternalError(w, err)
 }
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gin-server/internal/routing"
+	"net/http"
+	"strings"
+)
+
+const SPLITS_PATH string = "/proxy/splits"
+
+// splits answers the weights and rollback state of the splits on GET, and
+// changes the weights of /proxy/splits/<name> on PUT with a body such as
+// {"weights": {"canary": 20, "default": 80}}.
+func splits(w http.ResponseWriter, r *http.Request) {
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, SPLITS_PATH), "/")
+
+	switch {
+	case r.Method == http.MethodGet && name == "":
+		answer(w, http.StatusOK, routes.Splits())
+	case r.Method == http.MethodPut && name != "":
+		var body struct {
+			Weights map[string]int `json:"weights"`
+		}
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			answer(w, http.StatusBadRequest, err)
+			return
+		}
+
+		status, err := routes.SetWeights(name, body.Weights)
+		if errors.Is(err, routing.ErrUnknownSplit) {
+			answer(w, http.StatusNotFound, err)
+			return
+		}
+		if err != nil {
+			answer(w, http.StatusBadRequest, err)
+			return
+		}
+
+		answer(w, http.StatusOK, status)
+	default:
+		answer(w, http.StatusMethodNotAllowed, fmt.Errorf("list the splits with GET %s, change weights with PUT %s/<name>", SPLITS_PATH, SPLITS_PATH))
+	}
+}
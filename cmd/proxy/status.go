@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	STATUS_PATH    string        = "/proxy/status"
+	STATUS_TIMEOUT time.Duration = 2 * time.Second
+)
+
+// probes shares the upstream connections with the forwarded requests.
+var probes *http.Client
+
+// BackendStatus is the answer of one backend to a probe of its method list.
+type BackendStatus struct {
+	Pool      string `json:"pool"`
+	Host      string `json:"host"`
+	Up        bool   `json:"up"`
+	Status    int    `json:"status,omitempty"`
+	LatencyMs int64  `json:"latency_ms"`
+	Error     string `json:"error,omitempty"`
+}
+
+// backendStatus probes every backend at once and answers with their states,
+// the request is not forwarded.
+func backendStatus(w http.ResponseWriter, r *http.Request) {
+	pools := routes.Pools()
+
+	var statuses []BackendStatus
+	for _, pool := range routes.PoolNames() {
+		for _, host := range pools[pool] {
+			statuses = append(statuses, BackendStatus{Pool: pool, Host: host})
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := range statuses {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			statuses[i] = probe(statuses[i].Pool, statuses[i].Host)
+		}(i)
+	}
+	wg.Wait()
+
+	answer(w, http.StatusOK, statuses)
+}
+
+// answer writes the {"ok": ..., "response": ...} envelope of the API.
+func answer(w http.ResponseWriter, status int, response interface{}) {
+	if err, ok := response.(error); ok {
+		response = err.Error()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"ok":       status < http.StatusBadRequest,
+		"response": response,
+	})
+}
+
+func probe(pool, host string) BackendStatus {
+	status := BackendStatus{Pool: pool, Host: host}
+
+	start := time.Now()
+	resp, err := probes.Get(host + "/v1/")
+	status.LatencyMs = time.Since(start).Milliseconds()
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	resp.Body.Close()
+
+	status.Status = resp.StatusCode
+	status.Up = resp.StatusCode < http.StatusInternalServerError
+
+	return status
+}
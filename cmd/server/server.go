@@ -1,27 +1,148 @@
 package main
 
 import (
+	"crypto/tls"
 	"flag"
-	"fmt"
 	"gin-server/internal/api"
+	"gin-server/internal/cache"
+	"gin-server/internal/events"
+	"gin-server/internal/h2"
+	"gin-server/internal/mongogo"
+	"gin-server/internal/rpc"
+	"gin-server/internal/storage"
+	"gin-server/internal/tlsconfig"
+	"gin-server/internal/webhooks"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
 func main() {
 	port := flag.String("p", "8080", "server port")
+	grpcPort := flag.String("grpc", "", "gRPC server port, not served when empty")
+	mongoAddr := flag.String("mongo", api.MONGODB, "mongodb address")
+	retention := flag.Duration("retention", 30*24*time.Hour, "how long deleted users can be restored")
+	purgeInterval := flag.Duration("purge-interval", time.Hour, "how often deleted users are purged")
+	eventsInterval := flag.Duration("events-interval", time.Second, "how often user events are dispatched")
+	logEvents := flag.Bool("log-events", false, "log user events")
+	cacheSize := flag.Int("cache-size", cache.DEFAULT_SIZE, "users kept in the read cache, 0 disables it")
+	cacheTTL := flag.Duration("cache-ttl", cache.DEFAULT_TTL, "how long a user stays in the read cache")
+	tlsCert := flag.String("tls-cert", "", "certificate file, the server and gRPC serve TLS when set")
+	tlsKey := flag.String("tls-key", "", "key file of -tls-cert")
+	tlsClientCA := flag.String("tls-client-ca", "", "CA file, clients must present a certificate signed by it when set")
+	tlsReload := flag.Duration("tls-reload", tlsconfig.RELOAD_INTERVAL, "how often the certificate files are checked for changes")
+	adminToken := flag.String("admin-token", "", "bearer token of the /v1/admin routes, they are refused when empty")
+	webhookAllow := flag.String("webhook-allow", "", "comma separated addresses and networks that are not public but webhooks may post to, such as 10.1.0.0/16")
+	wsOrigins := flag.String("ws-origins", "", "comma separated web origins, besides the api's own, allowed to open WebSocket streams")
 
 	flag.Parse()
 
+	mgg, err := mongogo.Init(*mongoAddr)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer mgg.Disconnect()
+
+	applied, err := mgg.Migrate()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if len(applied) > 0 {
+		log.Printf("migrations applied: %v\n", applied)
+	}
+
+	var store storage.Store = &mgg
+	if *cacheSize > 0 {
+		store = cache.New(&mgg, cache.Config{Size: *cacheSize, TTL: *cacheTTL})
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	go storage.PurgeLoop(store, *retention, *purgeInterval, stop)
+
+	webhookNetworks, err := webhooks.ParseNetworks(strings.Split(*webhookAllow, ","))
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	sender := webhooks.NewSender(&mgg)
+	sender.Allowed = webhookNetworks
+	go sender.Run(*eventsInterval, stop)
+
+	dispatcher := events.NewDispatcher(&mgg)
+	dispatcher.Subscribe("webhooks", sender.Enqueue)
+	if *logEvents {
+		dispatcher.Subscribe("log", func(event mongogo.Event) error {
+			log.Printf("event %d: %s user %d\n", event.Id, event.Type, event.UserId)
+			return nil
+		})
+	}
+	go dispatcher.Run(*eventsInterval, stop)
+
+	hub := events.NewHub(&mgg)
+	go hub.Run(*eventsInterval, stop)
+
+	var tlsConfig *tls.Config
+	if *tlsCert != "" {
+		reloader, err := tlsconfig.NewReloader(*tlsCert, *tlsKey)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		go reloader.Run(*tlsReload, stop)
+
+		tlsConfig, err = tlsconfig.Server(reloader, *tlsClientCA)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	if *grpcPort != "" {
+		listener, err := net.Listen("tcp", ":"+*grpcPort)
+		if err != nil {
+			log.Fatalln(err)
+		}
+
+		options := []grpc.ServerOption{grpc.UnaryInterceptor(rpc.Audit(store))}
+		if tlsConfig != nil {
+			options = append(options, grpc.Creds(credentials.NewTLS(tlsConfig)))
+		}
+
+		server := grpc.NewServer(options...)
+		rpc.Register(server, store)
+		defer server.GracefulStop()
+
+		go func() {
+			log.Fatalln(server.Serve(listener))
+		}()
+	}
+
+	var origins []string
+	for _, origin := range strings.Split(*wsOrigins, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+
 	router := gin.Default()
 
-	router.GET("/", api.MethodsList)
-	router.POST("/create", api.CreateUser)
-	router.POST("/make_friends", api.MakeFriends)
-	router.DELETE("/user", api.DeleteUser)
-	router.GET("/friends/:user_id", api.GetFriends)
-	router.PUT("/:user_id", api.EditAge)
+	api.RegisterRoutes(router, api.Deps{Store: store, Hub: hub, Origins: origins, AdminToken: *adminToken, WebhookNetworks: webhookNetworks})
 
-	router.Run(":" + *port)
-	fmt.Println(*port)
+	// HTTP/2 is negotiated over TLS and accepted as h2c otherwise
+	server := &http.Server{Addr: ":" + *port, Handler: h2.Handler(router), TLSConfig: tlsConfig}
+	if tlsConfig != nil {
+		if err := h2.ConfigureServer(server); err != nil {
+			log.Fatalln(err)
+		}
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		err = server.ListenAndServe()
+	}
+	log.Println(err)
 }
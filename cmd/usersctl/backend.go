@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"gin-server/internal/api"
+	"gin-server/internal/mongogo"
+	"gin-server/internal/structs"
+	"gin-server/pkg/client"
+	"strings"
+)
+
+// backend is what the commands need from the service, implemented by
+// client.Client over the API and by mongoBackend directly on the database.
+type backend interface {
+	CreateUser(ctx context.Context, user client.CreateUserRequest) (int, error)
+	SearchUsers(ctx context.Context, query client.SearchQuery) (client.SearchResult, error)
+	GetUser(ctx context.Context, userId int) (client.User, error)
+	UpdateProfile(ctx context.Context, userId, version int, update client.ProfileUpdate) (client.User, error)
+	DeleteUser(ctx context.Context, userId int) error
+	GetFriends(ctx context.Context, userId int) ([]client.User, error)
+	MakeFriends(ctx context.Context, sourceId, targetId int) error
+	ImportUsers(ctx context.Context, users []client.User, remap bool) (client.ImportResponse, error)
+	ExportUsers(ctx context.Context, remap bool, fn func(client.User) error) error
+}
+
+// friendRemover is implemented by the backends that can remove a friend, the
+// HTTP API has no route for it.
+type friendRemover interface {
+	RemoveFriend(ctx context.Context, sourceId, targetId int) error
+}
+
+// mongoBackend works on the database without the server, so its changes skip
+// the audit log. It checks the input like the handlers do.
+type mongoBackend struct {
+	mgg *mongogo.Connector
+}
+
+func (mb *mongoBackend) CreateUser(ctx context.Context, user client.CreateUserRequest) (int, error) {
+	err := api.ValidateProfile(user.Email, user.AvatarURL)
+	if err != nil {
+		return 0, err
+	}
+
+	return mb.mgg.CreateUser(mongogo.User{
+		Name:        user.Name,
+		Age:         user.Age,
+		Email:       user.Email,
+		DisplayName: user.DisplayName,
+		Bio:         user.Bio,
+		AvatarURL:   user.AvatarURL,
+	})
+}
+
+func (mb *mongoBackend) SearchUsers(ctx context.Context, query client.SearchQuery) (client.SearchResult, error) {
+	result := client.SearchResult{Page: query.Page, PerPage: query.PerPage}
+	if result.Page == 0 {
+		result.Page = 1
+	}
+	if result.PerPage == 0 {
+		result.PerPage = api.SEARCH_PER_PAGE
+	}
+
+	search := mongogo.SearchQuery{
+		Text:   query.Text,
+		MinAge: query.MinAge,
+		MaxAge: query.MaxAge,
+		Skip:   (result.Page - 1) * result.PerPage,
+		Limit:  result.PerPage,
+	}
+
+	sort := query.Sort
+	if sort == "" {
+		sort = "name"
+	}
+	if strings.HasPrefix(sort, "-") {
+		search.Descending = true
+		sort = sort[1:]
+	}
+	for _, field := range mongogo.SEARCH_SORT {
+		if field == sort {
+			search.SortField = sort
+		}
+	}
+	if search.SortField == "" {
+		return result, fmt.Errorf("unknown sort field %q", sort)
+	}
+
+	users, total, err := mb.mgg.SearchUsers(search)
+	if err != nil {
+		return result, err
+	}
+
+	result.Users = toClientUsers(users)
+	result.Total = total
+
+	return result, nil
+}
+
+func (mb *mongoBackend) GetUser(ctx context.Context, userId int) (client.User, error) {
+	user, err := mb.mgg.GetUser(userId)
+
+	return toClientUser(user), err
+}
+
+func (mb *mongoBackend) UpdateProfile(ctx context.Context, userId, version int, update client.ProfileUpdate) (client.User, error) {
+	err := api.ValidateUpdate(mongogo.ProfileUpdate(update))
+	if err != nil {
+		return client.User{}, err
+	}
+
+	user, err := mb.mgg.UpdateProfile(userId, version, mongogo.ProfileUpdate(update))
+
+	return toClientUser(user), err
+}
+
+func (mb *mongoBackend) DeleteUser(ctx context.Context, userId int) error {
+	_, err := mb.mgg.DelUser(userId)
+
+	return err
+}
+
+func (mb *mongoBackend) GetFriends(ctx context.Context, userId int) ([]client.User, error) {
+	friends, err := mb.mgg.GetFriends(userId)
+
+	return toClientUsers(friends), err
+}
+
+func (mb *mongoBackend) MakeFriends(ctx context.Context, sourceId, targetId int) error {
+	return mb.mgg.AddFriend(sourceId, targetId)
+}
+
+func (mb *mongoBackend) RemoveFriend(ctx context.Context, sourceId, targetId int) error {
+	err := mb.mgg.CheckIds([]int{sourceId, targetId})
+	if err != nil {
+		return err
+	}
+
+	return mb.mgg.DelFriend(sourceId, targetId)
+}
+
+// ImportUsers keeps the ids of the users, remapping them is done by the API.
+// The users are checked like the API checks them and only their profile and
+// friends are imported.
+func (mb *mongoBackend) ImportUsers(ctx context.Context, users []client.User, remap bool) (client.ImportResponse, error) {
+	var response client.ImportResponse
+	if remap {
+		return response, fmt.Errorf("remapping ids needs the API, import with -remap=false")
+	}
+
+	insert := make([]mongogo.User, len(users))
+	for i, user := range users {
+		insert[i] = fromClientUser(user)
+	}
+
+	errs := make([]error, len(insert))
+	api.ValidateBulk(insert, errs, false)
+	err := api.CheckFriends(mb.mgg, insert, errs)
+	if err != nil {
+		return response, err
+	}
+
+	var valid []int
+	for i, err := range errs {
+		if err == nil {
+			valid = append(valid, i)
+		}
+	}
+
+	batch := make([]mongogo.User, len(valid))
+	for j, i := range valid {
+		batch[j] = insert[i]
+	}
+	for j, err := range mb.mgg.InsertUsers(batch) {
+		errs[valid[j]] = err
+	}
+
+	for i, err := range errs {
+		result := client.ImportResult{Index: i, Id: users[i].Id}
+		if err != nil {
+			result.Error = err.Error()
+			response.Failed++
+		} else {
+			result.UserId = users[i].Id
+			response.Inserted++
+		}
+
+		response.Results = append(response.Results, result)
+	}
+
+	return response, nil
+}
+
+func (mb *mongoBackend) ExportUsers(ctx context.Context, remap bool, fn func(client.User) error) error {
+	if remap {
+		return fmt.Errorf("remapping ids needs the API, export with -remap=false")
+	}
+
+	return mb.mgg.ExportUsers(func(user mongogo.User) error {
+		return fn(toClientUser(user))
+	})
+}
+
+func toClientUser(user mongogo.User) client.User {
+	return client.User{
+		Id:          user.Id,
+		Name:        user.Name,
+		Age:         user.Age,
+		Friends:     user.Friends,
+		Email:       user.Email,
+		DisplayName: user.DisplayName,
+		Bio:         user.Bio,
+		AvatarURL:   user.AvatarURL,
+		Version:     user.Version,
+		CreatedAt:   user.CreatedAt,
+		UpdatedAt:   user.UpdatedAt,
+		DeletedAt:   user.DeletedAt,
+	}
+}
+
+func toClientUsers(users []mongogo.User) []client.User {
+	result := make([]client.User, len(users))
+	for i, user := range users {
+		result[i] = toClientUser(user)
+	}
+
+	return result
+}
+
+// fromClientUser keeps the profile and the friends of an imported user, the
+// store sets the timestamps and the version.
+func fromClientUser(user client.User) mongogo.User {
+	return structs.BulkUser{
+		Id:          user.Id,
+		Name:        user.Name,
+		Age:         user.Age,
+		Friends:     user.Friends,
+		Email:       user.Email,
+		DisplayName: user.DisplayName,
+		Bio:         user.Bio,
+		AvatarURL:   user.AvatarURL,
+	}.User()
+}
@@ -0,0 +1,472 @@
+// Command usersctl manages the users of the service through its API, or
+// directly in mongodb with -mongo.
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"gin-server/internal/mongogo"
+	"gin-server/pkg/client"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const usage = `usage: usersctl [flags] <command> [command flags] [args]
+
+commands:
+  create -name NAME -age AGE [-email -display-name -bio -avatar-url]
+  list [-q PREFIX -min-age -max-age -sort FIELD -page -per-page]
+  show ID
+  edit ID [-name -age -email -display-name -bio -avatar-url -version]
+  delete ID
+  friends ID
+  add-friend SOURCE_ID TARGET_ID      add SOURCE_ID to the friends of TARGET_ID
+  remove-friend SOURCE_ID TARGET_ID   only with -mongo
+  import [-remap=false] FILE          JSON array or NDJSON, - reads stdin
+  export [-remap]                     NDJSON on stdout
+  backends                            state of the backends behind the proxy
+
+flags:
+`
+
+// command runs with the arguments after its name.
+type command func(ctx context.Context, args []string) error
+
+type cli struct {
+	backend  backend
+	printer  *printer
+	apiURL   string
+	commands map[string]command
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+
+	apiURL := flag.String("api", "http://localhost:8080", "address of the API or of the proxy in front of it")
+	mongoAddr := flag.String("mongo", "", "mongodb address, when set the users are changed directly in the database")
+	output := flag.String("o", OUTPUT_TABLE, "output format: table, json or yaml")
+	actor := flag.String("actor", os.Getenv("USER"), "actor claimed in the audit log")
+	token := flag.String("token", "", "bearer token sent to the API")
+	timeout := flag.Duration("timeout", 30*time.Second, "timeout of a command")
+
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	p, err := newPrinter(os.Stdout, *output)
+	if err != nil {
+		fail(err)
+	}
+
+	cmd := &cli{printer: p, apiURL: *apiURL}
+
+	if *mongoAddr != "" {
+		mgg, err := mongogo.Init(*mongoAddr)
+		if err != nil {
+			fail(err)
+		}
+		defer mgg.Disconnect()
+
+		cmd.backend = &mongoBackend{mgg: &mgg}
+	} else {
+		auths := []client.Auth{client.Actor(*actor)}
+		if *token != "" {
+			auths = append(auths, client.BearerToken(*token))
+		}
+
+		cmd.backend = client.New(*apiURL, client.WithAuth(client.Chain(auths...)))
+	}
+
+	cmd.commands = map[string]command{
+		"create":        cmd.create,
+		"list":          cmd.list,
+		"show":          cmd.show,
+		"edit":          cmd.edit,
+		"delete":        cmd.delete,
+		"friends":       cmd.friends,
+		"add-friend":    cmd.addFriend,
+		"remove-friend": cmd.removeFriend,
+		"import":        cmd.importUsers,
+		"export":        cmd.exportUsers,
+		"backends":      cmd.backends,
+	}
+
+	run, ok := cmd.commands[flag.Arg(0)]
+	if !ok {
+		fail(fmt.Errorf("unknown command %q, see usersctl -h", flag.Arg(0)))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	err = run(ctx, flag.Args()[1:])
+	if err != nil {
+		cancel()
+		fail(err)
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "usersctl:", err)
+	os.Exit(1)
+}
+
+func (cmd *cli) create(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("create", flag.ContinueOnError)
+	var user client.CreateUserRequest
+	fs.StringVar(&user.Name, "name", "", "name")
+	fs.IntVar(&user.Age, "age", 0, "age")
+	fs.StringVar(&user.Email, "email", "", "email")
+	fs.StringVar(&user.DisplayName, "display-name", "", "display name")
+	fs.StringVar(&user.Bio, "bio", "", "bio")
+	fs.StringVar(&user.AvatarURL, "avatar-url", "", "avatar url")
+
+	err := parse(fs, args, 0)
+	if err != nil {
+		return err
+	}
+	if user.Name == "" {
+		return fmt.Errorf("create needs -name")
+	}
+
+	userId, err := cmd.backend.CreateUser(ctx, user)
+	if err != nil {
+		return err
+	}
+
+	created, err := cmd.backend.GetUser(ctx, userId)
+	if err != nil {
+		return err
+	}
+
+	return cmd.printer.user(created)
+}
+
+func (cmd *cli) list(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	var query client.SearchQuery
+	fs.StringVar(&query.Text, "q", "", "name prefix")
+	fs.IntVar(&query.MinAge, "min-age", 0, "minimal age")
+	fs.IntVar(&query.MaxAge, "max-age", 0, "maximal age, 0 for none")
+	fs.StringVar(&query.Sort, "sort", "name", "name, age, id or created_at, a leading - sorts descending")
+	fs.IntVar(&query.Page, "page", 1, "page")
+	fs.IntVar(&query.PerPage, "per-page", 20, "users per page")
+
+	err := parse(fs, args, 0)
+	if err != nil {
+		return err
+	}
+
+	result, err := cmd.backend.SearchUsers(ctx, query)
+	if err != nil {
+		return err
+	}
+
+	return cmd.printer.search(result)
+}
+
+func (cmd *cli) show(ctx context.Context, args []string) error {
+	ids, err := parseIds(flag.NewFlagSet("show", flag.ContinueOnError), args, 1)
+	if err != nil {
+		return err
+	}
+
+	user, err := cmd.backend.GetUser(ctx, ids[0])
+	if err != nil {
+		return err
+	}
+
+	return cmd.printer.user(user)
+}
+
+// edit changes only the fields given as flags.
+func (cmd *cli) edit(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("edit", flag.ContinueOnError)
+	name := fs.String("name", "", "name")
+	age := fs.Int("age", 0, "age")
+	email := fs.String("email", "", "email, empty clears it")
+	displayName := fs.String("display-name", "", "display name, empty clears it")
+	bio := fs.String("bio", "", "bio, empty clears it")
+	avatarURL := fs.String("avatar-url", "", "avatar url, empty clears it")
+	version := fs.Int("version", 0, "change the user only if it still has this version")
+
+	ids, err := parseIds(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	var update client.ProfileUpdate
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "name":
+			update.Name = name
+		case "age":
+			update.Age = age
+		case "email":
+			update.Email = email
+		case "display-name":
+			update.DisplayName = displayName
+		case "bio":
+			update.Bio = bio
+		case "avatar-url":
+			update.AvatarURL = avatarURL
+		}
+	})
+
+	user, err := cmd.backend.UpdateProfile(ctx, ids[0], *version, update)
+	if err != nil {
+		return err
+	}
+
+	return cmd.printer.user(user)
+}
+
+func (cmd *cli) delete(ctx context.Context, args []string) error {
+	ids, err := parseIds(flag.NewFlagSet("delete", flag.ContinueOnError), args, 1)
+	if err != nil {
+		return err
+	}
+
+	err = cmd.backend.DeleteUser(ctx, ids[0])
+	if err != nil {
+		return err
+	}
+
+	return cmd.printer.message("User %d deleted", ids[0])
+}
+
+func (cmd *cli) friends(ctx context.Context, args []string) error {
+	ids, err := parseIds(flag.NewFlagSet("friends", flag.ContinueOnError), args, 1)
+	if err != nil {
+		return err
+	}
+
+	friends, err := cmd.backend.GetFriends(ctx, ids[0])
+	if err != nil {
+		return err
+	}
+
+	return cmd.printer.users(friends)
+}
+
+func (cmd *cli) addFriend(ctx context.Context, args []string) error {
+	ids, err := parseIds(flag.NewFlagSet("add-friend", flag.ContinueOnError), args, 2)
+	if err != nil {
+		return err
+	}
+
+	err = cmd.backend.MakeFriends(ctx, ids[0], ids[1])
+	if err != nil {
+		return err
+	}
+
+	return cmd.printer.message("User %d added as friend to %d", ids[0], ids[1])
+}
+
+func (cmd *cli) removeFriend(ctx context.Context, args []string) error {
+	ids, err := parseIds(flag.NewFlagSet("remove-friend", flag.ContinueOnError), args, 2)
+	if err != nil {
+		return err
+	}
+
+	remover, ok := cmd.backend.(friendRemover)
+	if !ok {
+		return fmt.Errorf("the API can not remove friends, use -mongo")
+	}
+
+	err = remover.RemoveFriend(ctx, ids[0], ids[1])
+	if err != nil {
+		return err
+	}
+
+	return cmd.printer.message("User %d removed from friends of %d", ids[0], ids[1])
+}
+
+func (cmd *cli) importUsers(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	remap := fs.Bool("remap", true, "give the users fresh ids and rewrite the friend ids")
+
+	err := parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	in := os.Stdin
+	if fs.Arg(0) != "-" {
+		in, err = os.Open(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+	}
+
+	users, err := readUsers(in)
+	if err != nil {
+		return err
+	}
+
+	response, err := cmd.backend.ImportUsers(ctx, users, *remap)
+	if err != nil {
+		return err
+	}
+
+	return cmd.printer.imported(response)
+}
+
+func (cmd *cli) exportUsers(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	remap := fs.Bool("remap", false, "renumber the ids from 1")
+
+	err := parse(fs, args, 0)
+	if err != nil {
+		return err
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	encoder := json.NewEncoder(out)
+
+	err = cmd.backend.ExportUsers(ctx, *remap, func(user client.User) error {
+		return encoder.Encode(user)
+	})
+	if err != nil {
+		return err
+	}
+
+	return out.Flush()
+}
+
+// backendStatus is an entry of the /proxy/status answer of cmd/proxy.
+type backendStatus struct {
+	Pool      string `json:"pool"`
+	Host      string `json:"host"`
+	Up        bool   `json:"up"`
+	Status    int    `json:"status,omitempty"`
+	LatencyMs int64  `json:"latency_ms"`
+	Error     string `json:"error,omitempty"`
+}
+
+func (cmd *cli) backends(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("backends", flag.ContinueOnError)
+	proxy := fs.String("proxy", cmd.apiURL, "address of the proxy")
+
+	err := parse(fs, args, 0)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(*proxy, "/")+"/proxy/status", nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s answered %s, is it the proxy?", *proxy, resp.Status)
+	}
+
+	var answer struct {
+		Response []backendStatus `json:"response"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&answer)
+	if err != nil {
+		return err
+	}
+
+	return cmd.printer.backends(answer.Response)
+}
+
+// parse parses the flags of a command, which must be followed by n arguments.
+func parse(fs *flag.FlagSet, args []string, n int) error {
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if fs.NArg() != n {
+		return fmt.Errorf("%s takes %d arguments, got %d", fs.Name(), n, fs.NArg())
+	}
+
+	return nil
+}
+
+// parseIds parses the flags of a command followed by n user ids.
+func parseIds(fs *flag.FlagSet, args []string, n int) ([]int, error) {
+	// the flags may follow the ids, as in "edit 5 -age 30"
+	leading := 0
+	for leading < len(args) && !strings.HasPrefix(args[leading], "-") {
+		leading++
+	}
+	args = append(append([]string{}, args[leading:]...), args[:leading]...)
+
+	err := parse(fs, args, n)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]int, n)
+	for i := range ids {
+		ids[i], err = strconv.Atoi(fs.Arg(i))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %q is not a user id", fs.Name(), fs.Arg(i))
+		}
+	}
+
+	return ids, nil
+}
+
+// readUsers reads a JSON array or NDJSON, like POST /users/bulk does.
+func readUsers(in io.Reader) ([]client.User, error) {
+	reader := bufio.NewReader(in)
+
+	var users []client.User
+	for {
+		b, err := reader.ReadByte()
+		if err == io.EOF {
+			return users, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		if strings.ContainsRune(" \t\r\n", rune(b)) {
+			continue
+		}
+
+		reader.UnreadByte()
+		if b == '[' {
+			err = json.NewDecoder(reader).Decode(&users)
+			return users, err
+		}
+
+		break
+	}
+
+	decoder := json.NewDecoder(reader)
+	for line := 1; ; line++ {
+		var user client.User
+		err := decoder.Decode(&user)
+		if err == io.EOF {
+			return users, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("user %d: %w", line, err)
+		}
+
+		users = append(users, user)
+	}
+}
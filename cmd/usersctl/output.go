@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"gin-server/pkg/client"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	OUTPUT_TABLE string = "table"
+	OUTPUT_JSON  string = "json"
+	OUTPUT_YAML  string = "yaml"
+)
+
+// printer writes the results of the commands in the chosen format. JSON and
+// YAML hold the values as the API answers them, table is meant for reading.
+type printer struct {
+	out    io.Writer
+	format string
+}
+
+func newPrinter(out io.Writer, format string) (*printer, error) {
+	switch format {
+	case OUTPUT_TABLE, OUTPUT_JSON, OUTPUT_YAML:
+		return &printer{out: out, format: format}, nil
+	}
+
+	return nil, fmt.Errorf("unknown output format %q, use table, json or yaml", format)
+}
+
+// print writes value as JSON or YAML, or calls table with the rows writer.
+func (p *printer) print(value interface{}, table func(w io.Writer)) error {
+	switch p.format {
+	case OUTPUT_JSON:
+		encoder := json.NewEncoder(p.out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(value)
+	case OUTPUT_YAML:
+		// through JSON, so the keys are the field names of the API
+		data, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+
+		var generic interface{}
+		err = yaml.Unmarshal(data, &generic)
+		if err != nil {
+			return err
+		}
+
+		data, err = yaml.Marshal(generic)
+		if err != nil {
+			return err
+		}
+
+		_, err = p.out.Write(data)
+		return err
+	}
+
+	w := tabwriter.NewWriter(p.out, 0, 4, 2, ' ', 0)
+	table(w)
+
+	return w.Flush()
+}
+
+func (p *printer) users(users []client.User) error {
+	return p.print(users, func(w io.Writer) {
+		userRows(w, users)
+	})
+}
+
+func (p *printer) user(user client.User) error {
+	return p.print(user, func(w io.Writer) {
+		fmt.Fprintf(w, "ID\t%d\n", user.Id)
+		fmt.Fprintf(w, "NAME\t%s\n", user.Name)
+		fmt.Fprintf(w, "AGE\t%d\n", user.Age)
+		fmt.Fprintf(w, "EMAIL\t%s\n", user.Email)
+		fmt.Fprintf(w, "DISPLAY NAME\t%s\n", user.DisplayName)
+		fmt.Fprintf(w, "BIO\t%s\n", user.Bio)
+		fmt.Fprintf(w, "AVATAR URL\t%s\n", user.AvatarURL)
+		fmt.Fprintf(w, "FRIENDS\t%s\n", joinIds(user.Friends))
+		fmt.Fprintf(w, "VERSION\t%d\n", user.Version)
+		fmt.Fprintf(w, "CREATED\t%s\n", user.CreatedAt.Format("2006-01-02 15:04:05"))
+		fmt.Fprintf(w, "UPDATED\t%s\n", user.UpdatedAt.Format("2006-01-02 15:04:05"))
+	})
+}
+
+// message writes a line of text, as {"message": ...} in JSON and YAML.
+func (p *printer) message(format string, args ...interface{}) error {
+	text := fmt.Sprintf(format, args...)
+
+	return p.print(map[string]string{"message": text}, func(w io.Writer) {
+		fmt.Fprintln(w, text)
+	})
+}
+
+func (p *printer) search(result client.SearchResult) error {
+	return p.print(result, func(w io.Writer) {
+		userRows(w, result.Users)
+		fmt.Fprintf(w, "\npage %d, %d per page, %d users in total\n", result.Page, result.PerPage, result.Total)
+	})
+}
+
+func (p *printer) imported(response client.ImportResponse) error {
+	return p.print(response, func(w io.Writer) {
+		fmt.Fprintf(w, "inserted %d, failed %d\n", response.Inserted, response.Failed)
+		if response.Failed == 0 {
+			return
+		}
+
+		fmt.Fprintln(w, "\nINDEX\tID\tERROR")
+		for _, result := range response.Results {
+			if result.Error != "" {
+				fmt.Fprintf(w, "%d\t%d\t%s\n", result.Index, result.Id, result.Error)
+			}
+		}
+	})
+}
+
+func (p *printer) backends(statuses []backendStatus) error {
+	return p.print(statuses, func(w io.Writer) {
+		fmt.Fprintln(w, "POOL\tHOST\tUP\tSTATUS\tLATENCY\tERROR")
+		for _, status := range statuses {
+			fmt.Fprintf(w, "%s\t%s\t%t\t%d\t%dms\t%s\n",
+				status.Pool, status.Host, status.Up, status.Status, status.LatencyMs, status.Error)
+		}
+	})
+}
+
+func userRows(w io.Writer, users []client.User) {
+	fmt.Fprintln(w, "ID\tNAME\tAGE\tEMAIL\tFRIENDS\tVERSION")
+	for _, user := range users {
+		fmt.Fprintf(w, "%d\t%s\t%d\t%s\t%s\t%d\n",
+			user.Id, user.Name, user.Age, user.Email, joinIds(user.Friends), user.Version)
+	}
+}
+
+func joinIds(ids []int) string {
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = strconv.Itoa(id)
+	}
+
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,122 @@
+package api
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"gin-server/internal/cache"
+	"gin-server/internal/errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Admin lets through the requests that carry the admin token as
+// "Authorization: Bearer <token>", all of them are refused while the server
+// has no token.
+func (h *Handler) Admin(c *gin.Context) {
+	if h.adminToken == "" {
+		c.AbortWithStatusJSON(http.StatusForbidden, HTTPerr.ErrorJSON(fmt.Errorf("the admin routes are disabled, start the server with -admin-token")))
+		return
+	}
+
+	if !h.isAdmin(c.Request) {
+		c.Header("WWW-Authenticate", "Bearer")
+		c.AbortWithStatusJSON(http.StatusUnauthorized, HTTPerr.ErrorJSON(fmt.Errorf("send the admin token as Authorization: Bearer <token>")))
+		return
+	}
+
+	c.Next()
+}
+
+// isAdmin reports whether r carries the admin token.
+func (h *Handler) isAdmin(r *http.Request) bool {
+	header := r.Header.Get("Authorization")
+	if h.adminToken == "" || !strings.HasPrefix(header, "Bearer ") {
+		return false
+	}
+
+	token := strings.TrimPrefix(header, "Bearer ")
+
+	return subtle.ConstantTimeCompare([]byte(token), []byte(h.adminToken)) == 1
+}
+
+// RestoreUser brings back a soft deleted user with its friendships.
+func (h *Handler) RestoreUser(c *gin.Context) {
+	userId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, HTTPerr.ErrorJSON(err))
+		return
+	}
+
+	h.audit(c, userId)
+
+	user, err := h.store.RestoreUser(userId)
+	if _, ok := err.(*errors.UndefinedIndexes); ok {
+		c.JSON(http.StatusNotFound, HTTPerr.ErrorJSON(err))
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, HTTPerr.ErrorJSON(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"ok":       true,
+		"response": user,
+	})
+}
+
+const (
+	EVENTS_LIMIT     int = 100
+	EVENTS_MAX_LIMIT int = 1000
+)
+
+// Events reads the outbox of user events after the id in ?after=, the id of
+// the last returned event is the cursor for the next call.
+func (h *Handler) Events(c *gin.Context) {
+	after, err := intQuery(c, "after", 0)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, HTTPerr.ErrorJSON(err))
+		return
+	}
+
+	limit, err := intQuery(c, "limit", EVENTS_LIMIT)
+	if err == nil && (limit < 1 || limit > EVENTS_MAX_LIMIT) {
+		err = fmt.Errorf("limit must be in range 1..%d", EVENTS_MAX_LIMIT)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, HTTPerr.ErrorJSON(err))
+		return
+	}
+
+	events, err := h.store.Events(after, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, HTTPerr.ErrorJSON(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"ok":       true,
+		"response": events,
+	})
+}
+
+// statsStore is a store with a cache in front, such as cache.Store.
+type statsStore interface {
+	Stats() cache.Stats
+}
+
+// CacheStats answers the hit and miss counters of the user cache.
+func (h *Handler) CacheStats(c *gin.Context) {
+	store, ok := h.store.(statsStore)
+	if !ok {
+		c.JSON(http.StatusNotFound, HTTPerr.ErrorJSON(fmt.Errorf("the user cache is disabled")))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"ok":       true,
+		"response": store.Stats(),
+	})
+}
@@ -4,10 +4,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"gin-server/internal/errors"
+	"gin-server/internal/events"
 	"gin-server/internal/mongogo"
+	"gin-server/internal/storage"
 	"gin-server/internal/structs"
+	"net"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
@@ -18,57 +22,86 @@ const (
 
 var HTTPerr errors.HTTPErrors
 
+// Handler serves the api routes on top of the storage passed in Deps.
+type Handler struct {
+	store           storage.Store
+	hub             *events.Hub
+	origins         map[string]bool
+	adminToken      string
+	webhookNetworks []*net.IPNet
+}
+
+func NewHandler(deps Deps) *Handler {
+	h := &Handler{store: deps.Store, hub: deps.Hub, origins: map[string]bool{}, adminToken: deps.AdminToken, webhookNetworks: deps.WebhookNetworks}
+	for _, origin := range deps.Origins {
+		h.origins[strings.ToLower(origin)] = true
+	}
+
+	return h
+}
+
 func MethodsList(c *gin.Context) {
-	answer := "GET    /                  - methods list\n"
-	answer += "POST   /create            - create new user           # {name: <username> string, age: <age> int}\n"
-	answer += "POST   /make_friends      - add friend to target user # {source_id: <user_id> int, target_id: <user_id> int}\n"
-	answer += "GET    /friends/{user_id} - get friend list\n"
-	answer += "DELETE /{user_id}         - delete user               # {target_id: <user_id> int}\n"
+	answer := ""
+
+	for _, doc := range Docs() {
+		if doc.Request != nil {
+			answer += fmt.Sprintf("%-6s %-40s - %-26s # %s\n", doc.Method, Version+doc.Path, doc.Summary, requestHint(doc.Request))
+		} else {
+			answer += fmt.Sprintf("%-6s %-40s - %s\n", doc.Method, Version+doc.Path, doc.Summary)
+		}
+	}
 
 	c.String(http.StatusOK, answer)
 }
 
-func CreateUser(c *gin.Context) {
+func (h *Handler) CreateUser(c *gin.Context) {
 	rawData, err := c.GetRawData()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, HTTPerr.ErrorJSON(err))
 		return
 	}
 
-	var user mongogo.User
+	var user structs.CreateUserRequest
 	err = json.Unmarshal(rawData, &user)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, HTTPerr.ErrorJSON(err))
 		return
 	}
 
-	mgg, err := mongogo.Init(MONGODB)
+	err = ValidateProfile(user.Email, user.AvatarURL)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, HTTPerr.ErrorJSON(err))
+		c.JSON(http.StatusBadRequest, HTTPerr.ErrorJSON(err))
 		return
 	}
 
-	userId, err := mgg.NewUser(user.Name, user.Age)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, HTTPerr.ErrorJSON(err))
+	userId, err := h.store.CreateUser(mongogo.User{
+		Name:        user.Name,
+		Age:         user.Age,
+		Email:       user.Email,
+		DisplayName: user.DisplayName,
+		Bio:         user.Bio,
+		AvatarURL:   user.AvatarURL,
+	})
+	if _, ok := err.(*errors.EmailExists); ok {
+		c.JSON(http.StatusConflict, HTTPerr.ErrorJSON(err))
 		return
-	}
-
-	if mgg.Disconnect() != nil {
+	} else if err != nil {
 		c.JSON(http.StatusInternalServerError, HTTPerr.ErrorJSON(err))
 		return
 	}
 
+	auditCreated(c, userId)
+
 	c.JSON(http.StatusCreated, gin.H{
 		"ok": true,
-		"response": gin.H{
-			"message": fmt.Sprintf("User %s created with id: %d", user.Name, userId),
-			"user_id": userId,
+		"response": structs.CreateUserResponse{
+			Message: fmt.Sprintf("User %s created with id: %d", user.Name, userId),
+			UserId:  userId,
 		},
 	})
 }
 
-func MakeFriends(c *gin.Context) {
+func (h *Handler) MakeFriends(c *gin.Context) {
 	rawData, err := c.GetRawData()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, HTTPerr.ErrorJSON(err))
@@ -82,25 +115,19 @@ func MakeFriends(c *gin.Context) {
 		return
 	}
 
-	mgg, err := mongogo.Init(MONGODB)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, HTTPerr.ErrorJSON(err))
-		return
-	}
+	h.audit(c, request.TargetId, request.SourceId)
 
-	err = mgg.AddFriend(request.SourceId, request.TargetId)
+	err = h.store.AddFriend(request.SourceId, request.TargetId)
 	if _, ok := err.(*errors.UndefinedIndexes); ok {
 		c.JSON(http.StatusBadRequest, HTTPerr.ErrorJSON(err))
 		return
 	} else if _, ok := err.(*errors.FriendsExists); ok {
 		c.JSON(http.StatusBadRequest, HTTPerr.ErrorJSON(err))
 		return
-	} else if err != nil {
-		c.JSON(http.StatusInternalServerError, HTTPerr.ErrorJSON(err))
+	} else if _, ok := err.(*errors.UserBlocked); ok {
+		c.JSON(http.StatusForbidden, HTTPerr.ErrorJSON(err))
 		return
-	}
-
-	if mgg.Disconnect() != nil {
+	} else if err != nil {
 		c.JSON(http.StatusInternalServerError, HTTPerr.ErrorJSON(err))
 		return
 	}
@@ -111,7 +138,7 @@ func MakeFriends(c *gin.Context) {
 	})
 }
 
-func DeleteUser(c *gin.Context) {
+func (h *Handler) DeleteUser(c *gin.Context) {
 	rawData, err := c.GetRawData()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, HTTPerr.ErrorJSON(err))
@@ -125,13 +152,9 @@ func DeleteUser(c *gin.Context) {
 		return
 	}
 
-	mgg, err := mongogo.Init(MONGODB)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, HTTPerr.ErrorJSON(err))
-		return
-	}
+	h.audit(c, request.TargetId)
 
-	userName, err := mgg.DelUser(request.TargetId)
+	userName, err := h.store.DelUser(request.TargetId)
 	if _, ok := err.(*errors.UndefinedIndexes); ok {
 		c.String(http.StatusBadRequest, "Error: %v", err)
 		return
@@ -140,31 +163,20 @@ func DeleteUser(c *gin.Context) {
 		return
 	}
 
-	if mgg.Disconnect() != nil {
-		c.JSON(http.StatusInternalServerError, HTTPerr.ErrorJSON(err))
-		return
-	}
-
 	c.JSON(http.StatusOK, gin.H{
 		"ok":       true,
 		"response": fmt.Sprintf("User %s deleted", userName),
 	})
 }
 
-func GetFriends(c *gin.Context) {
+func (h *Handler) GetFriends(c *gin.Context) {
 	userId, err := strconv.Atoi(c.Param("user_id"))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, HTTPerr.ErrorJSON(err))
 		return
 	}
 
-	mgg, err := mongogo.Init(MONGODB)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, HTTPerr.ErrorJSON(err))
-		return
-	}
-
-	friends, err := mgg.GetFriends(userId)
+	friends, err := h.store.GetFriends(userId)
 	if _, ok := err.(*errors.UndefinedIndexes); ok {
 		c.String(http.StatusBadRequest, "Error: %v", err)
 		return
@@ -173,56 +185,53 @@ func GetFriends(c *gin.Context) {
 		return
 	}
 
-	if mgg.Disconnect() != nil {
-		c.JSON(http.StatusInternalServerError, HTTPerr.ErrorJSON(err))
-		return
-	}
-
 	c.JSON(http.StatusOK, gin.H{
 		"ok":       true,
 		"response": friends,
 	})
 }
 
-func EditAge(c *gin.Context) {
+func (h *Handler) EditAge(c *gin.Context) {
 	userId, err := strconv.Atoi(c.Param("user_id"))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, HTTPerr.ErrorJSON(err))
 		return
 	}
 
-	rawData, err := c.GetRawData()
+	version, err := ifMatch(c)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, HTTPerr.ErrorJSON(err))
+		c.JSON(http.StatusPreconditionFailed, HTTPerr.ErrorJSON(err))
 		return
 	}
 
-	var request structs.EditAgeRequest
-	err = json.Unmarshal(rawData, &request)
+	rawData, err := c.GetRawData()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, HTTPerr.ErrorJSON(err))
 		return
 	}
 
-	mgg, err := mongogo.Init(MONGODB)
+	var request structs.EditAgeRequest
+	err = json.Unmarshal(rawData, &request)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, HTTPerr.ErrorJSON(err))
 		return
 	}
 
-	err = mgg.UpdateAge(userId, request.NewAge)
+	h.audit(c, userId)
+
+	user, err := h.store.UpdateAge(userId, request.NewAge, version)
 	if _, ok := err.(*errors.UndefinedIndexes); ok {
 		c.String(http.StatusBadRequest, "Error: %v", err)
 		return
+	} else if _, ok := err.(*errors.VersionMismatch); ok {
+		c.JSON(http.StatusPreconditionFailed, HTTPerr.ErrorJSON(err))
+		return
 	} else if err != nil {
 		c.JSON(http.StatusInternalServerError, HTTPerr.ErrorJSON(err))
 		return
 	}
 
-	if mgg.Disconnect() != nil {
-		c.JSON(http.StatusInternalServerError, HTTPerr.ErrorJSON(err))
-		return
-	}
+	c.Header("ETag", ETag(user.Version))
 
 	c.JSON(http.StatusOK, gin.H{
 		"ok":       true,
@@ -0,0 +1,247 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"gin-server/internal/mongogo"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	AUDIT_LIMIT     int = 100
+	AUDIT_MAX_LIMIT int = 1000
+	// AUDIT_MAX_CHANGES bounds the users whose changes are kept in one record,
+	// bulk requests only list their targets.
+	AUDIT_MAX_CHANGES int    = 20
+	AUDIT_ANONYMOUS   string = "anonymous"
+	AUDIT_ADMIN       string = "admin"
+	auditKey          string = "audit"
+)
+
+// auditEntry collects the users touched by a request while it runs.
+type auditEntry struct {
+	targets  []int
+	before   map[int]*mongogo.User
+	readOnly bool
+}
+
+// Audit appends a record of every mutating request to the audit log, whether
+// it succeeded or not. The actor is the one the request authenticates as, see
+// actor, the X-Actor header is only kept as the claimed actor. The request id
+// is taken from X-Request-ID, a missing one is generated and sent back.
+// Handlers name the users they change with audit or auditCreated.
+func (h *Handler) Audit(c *gin.Context) {
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		c.Next()
+		return
+	}
+
+	requestId := c.GetHeader("X-Request-ID")
+	if requestId == "" {
+		requestId = NewRequestId()
+	}
+	c.Header("X-Request-ID", requestId)
+
+	entry := &auditEntry{before: map[int]*mongogo.User{}}
+	c.Set(auditKey, entry)
+
+	c.Next()
+
+	if entry.readOnly {
+		return
+	}
+
+	path, _ := versionPath(c.FullPath())
+	record := mongogo.AuditRecord{
+		Actor:        h.actor(c.Request),
+		ClaimedActor: c.GetHeader("X-Actor"),
+		Action:       actionName(c.HandlerName()),
+		Method:       c.Request.Method,
+		Path:         path,
+		Targets:      append([]int{}, entry.targets...),
+		Status:       c.Writer.Status(),
+		RequestId:    requestId,
+		IP:           c.ClientIP(),
+	}
+
+	if len(c.Params) > 0 {
+		record.Params = make(map[string]string, len(c.Params))
+		for _, param := range c.Params {
+			record.Params[param.Key] = param.Value
+		}
+	}
+
+	if len(entry.targets) <= AUDIT_MAX_CHANGES {
+		for _, userId := range entry.targets {
+			if change, ok := mongogo.DiffUser(userId, entry.before[userId], h.snapshot(userId)); ok {
+				record.Changes = append(record.Changes, change)
+			}
+		}
+	}
+
+	err := h.store.AppendAudit(record)
+	if err != nil {
+		log.Printf("audit %s %s %s: %v", requestId, record.Method, record.Path, err)
+	}
+}
+
+// actor is the caller r authenticates as: the subject of its client
+// certificate, AUDIT_ADMIN with the admin token, AUDIT_ANONYMOUS otherwise.
+func (h *Handler) actor(r *http.Request) string {
+	if actor := CertActor(r.TLS); actor != "" {
+		return actor
+	}
+	if h.isAdmin(r) {
+		return AUDIT_ADMIN
+	}
+
+	return AUDIT_ANONYMOUS
+}
+
+// CertActor names the caller of a connection by the subject of its verified
+// client certificate as "cert:<common name>", it is empty without one.
+func CertActor(state *tls.ConnectionState) string {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return ""
+	}
+
+	return "cert:" + state.VerifiedChains[0][0].Subject.CommonName
+}
+
+// audit names users the request is about to change and keeps their state
+// before the change. It has to be called before the store is updated.
+func (h *Handler) audit(c *gin.Context, user_ids ...int) {
+	entry, ok := c.Value(auditKey).(*auditEntry)
+	if !ok {
+		return
+	}
+
+	for _, userId := range user_ids {
+		if _, ok := entry.before[userId]; ok {
+			continue
+		}
+
+		entry.targets = append(entry.targets, userId)
+		entry.before[userId] = h.snapshot(userId)
+	}
+}
+
+// auditCreated names users created by the request.
+func auditCreated(c *gin.Context, user_ids ...int) {
+	entry, ok := c.Value(auditKey).(*auditEntry)
+	if !ok {
+		return
+	}
+
+	for _, userId := range user_ids {
+		if _, ok := entry.before[userId]; !ok {
+			entry.targets = append(entry.targets, userId)
+			entry.before[userId] = nil
+		}
+	}
+}
+
+// auditReadOnly leaves out a request that turned out not to change anything,
+// such as a GraphQL query.
+func auditReadOnly(c *gin.Context) {
+	if entry, ok := c.Value(auditKey).(*auditEntry); ok {
+		entry.readOnly = true
+	}
+}
+
+// snapshot returns the user or nil when there is no such user.
+func (h *Handler) snapshot(user_id int) *mongogo.User {
+	user, err := h.store.GetUser(user_id)
+	if err != nil {
+		return nil
+	}
+
+	return &user
+}
+
+// actionName turns "gin-server/internal/api.(*Handler).CreateUser-fm" into "CreateUser".
+func actionName(handler string) string {
+	handler = strings.TrimSuffix(handler, "-fm")
+
+	return handler[strings.LastIndex(handler, ".")+1:]
+}
+
+// NewRequestId returns a random id for a request that came without one.
+func NewRequestId() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+
+	return hex.EncodeToString(id)
+}
+
+// AuditLog reads the audit log newest first, filtered by ?actor=,
+// ?claimed_actor=, ?target_id= and the period ?from= (inclusive) to ?to=
+// (exclusive) in RFC 3339.
+func (h *Handler) AuditLog(c *gin.Context) {
+	targetId, err := intQuery(c, "target_id", 0)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, HTTPerr.ErrorJSON(err))
+		return
+	}
+
+	limit, err := intQuery(c, "limit", AUDIT_LIMIT)
+	if err == nil && (limit < 1 || limit > AUDIT_MAX_LIMIT) {
+		err = fmt.Errorf("limit must be in range 1..%d", AUDIT_MAX_LIMIT)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, HTTPerr.ErrorJSON(err))
+		return
+	}
+
+	from, err := timeQuery(c, "from")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, HTTPerr.ErrorJSON(err))
+		return
+	}
+
+	to, err := timeQuery(c, "to")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, HTTPerr.ErrorJSON(err))
+		return
+	}
+
+	records, err := h.store.AuditLog(mongogo.AuditQuery{
+		Actor:        c.Query("actor"),
+		ClaimedActor: c.Query("claimed_actor"),
+		TargetId:     targetId,
+		From:         from,
+		To:           to,
+		Limit:        limit,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, HTTPerr.ErrorJSON(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"ok":       true,
+		"response": records,
+	})
+}
+
+func timeQuery(c *gin.Context, name string) (time.Time, error) {
+	raw, ok := c.GetQuery(name)
+	if !ok {
+		return time.Time{}, nil
+	}
+
+	value, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s must be a time in RFC 3339", name)
+	}
+
+	return value.UTC(), nil
+}
@@ -0,0 +1,457 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"gin-server/internal/errors"
+	"gin-server/internal/mongogo"
+	"gin-server/internal/storage"
+	"gin-server/internal/structs"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	BULK_BATCH     int = 500
+	BULK_MAX_BATCH int = 1000
+	EXPORT_FLUSH   int = 100
+)
+
+// BulkUsers imports a JSON array or an NDJSON stream of users. With remap (the
+// default) every user gets a fresh id and friend ids, which refer to ids inside
+// the payload, are rewritten accordingly; with remap=false ids are kept as given.
+func (h *Handler) BulkUsers(c *gin.Context) {
+	remap, err := strconv.ParseBool(c.DefaultQuery("remap", "true"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, HTTPerr.ErrorJSON(err))
+		return
+	}
+
+	batch, err := strconv.Atoi(c.DefaultQuery("batch", strconv.Itoa(BULK_BATCH)))
+	if err != nil || batch < 1 || batch > BULK_MAX_BATCH {
+		c.JSON(http.StatusBadRequest, HTTPerr.ErrorJSON(fmt.Errorf("batch must be in range 1..%d", BULK_MAX_BATCH)))
+		return
+	}
+
+	users, errs, err := readBulk(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, HTTPerr.ErrorJSON(err))
+		return
+	}
+
+	ValidateBulk(users, errs, remap)
+
+	results := make([]structs.BulkResult, len(users))
+	for i, user := range users {
+		results[i] = structs.BulkResult{Index: i, Id: user.Id}
+	}
+
+	if remap {
+		err = h.remapBulk(users, errs)
+	} else {
+		err = CheckFriends(h.store, users, errs)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, HTTPerr.ErrorJSON(err))
+		return
+	}
+
+	var pending []int
+	flush := func() {
+		insert := make([]mongogo.User, 0, len(pending))
+		for _, i := range pending {
+			insert = append(insert, users[i])
+		}
+
+		for j, err := range h.store.InsertUsers(insert) {
+			errs[pending[j]] = err
+		}
+
+		pending = pending[:0]
+	}
+
+	for i := range users {
+		if errs[i] != nil {
+			continue
+		}
+
+		pending = append(pending, i)
+		if len(pending) == batch {
+			flush()
+		}
+	}
+	if len(pending) > 0 {
+		flush()
+	}
+
+	response := structs.BulkResponse{Results: results}
+	for i, err := range errs {
+		if err != nil {
+			results[i].Error = err.Error()
+			response.Failed++
+			continue
+		}
+
+		results[i].UserId = users[i].Id
+		response.Inserted++
+		auditCreated(c, users[i].Id)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"ok":       true,
+		"response": response,
+	})
+}
+
+// ExportUsers streams all users with their friend lists as NDJSON (default) or
+// CSV. With remap=true ids are renumbered from 1 in id order, users created
+// while the export runs are left out then.
+func (h *Handler) ExportUsers(c *gin.Context) {
+	format := c.DefaultQuery("format", "ndjson")
+	if format != "ndjson" && format != "csv" {
+		c.JSON(http.StatusBadRequest, HTTPerr.ErrorJSON(fmt.Errorf("unknown format %q", format)))
+		return
+	}
+
+	remap, err := strconv.ParseBool(c.DefaultQuery("remap", "false"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, HTTPerr.ErrorJSON(err))
+		return
+	}
+
+	var ids map[int]int
+	if remap {
+		ids = make(map[int]int)
+
+		err = h.store.ExportUsers(func(user mongogo.User) error {
+			ids[user.Id] = len(ids) + 1
+			return nil
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, HTTPerr.ErrorJSON(err))
+			return
+		}
+	}
+
+	var csvWriter *csv.Writer
+	encoder := json.NewEncoder(c.Writer)
+	started := false
+	count := 0
+
+	start := func() {
+		started = true
+
+		if format == "csv" {
+			c.Header("Content-Type", "text/csv")
+			c.Header("Content-Disposition", "attachment; filename=users.csv")
+			c.Status(http.StatusOK)
+
+			csvWriter = csv.NewWriter(c.Writer)
+			csvWriter.Write([]string{"id", "name", "age", "friends"})
+		} else {
+			c.Header("Content-Type", "application/x-ndjson")
+			c.Header("Content-Disposition", "attachment; filename=users.ndjson")
+			c.Status(http.StatusOK)
+		}
+	}
+
+	err = h.store.ExportUsers(func(user mongogo.User) error {
+		// users created after the ids were numbered are left out, like their
+		// ids in the friend lists
+		if _, ok := ids[user.Id]; remap && !ok {
+			return nil
+		}
+
+		if !started {
+			start()
+		}
+
+		if remap {
+			user = remapUser(user, ids)
+		}
+		if user.Friends == nil {
+			user.Friends = []int{}
+		}
+
+		var err error
+		if csvWriter != nil {
+			friends := make([]string, 0, len(user.Friends))
+			for _, id := range user.Friends {
+				friends = append(friends, strconv.Itoa(id))
+			}
+
+			err = csvWriter.Write([]string{
+				strconv.Itoa(user.Id), user.Name, strconv.Itoa(user.Age), strings.Join(friends, ";"),
+			})
+		} else {
+			err = encoder.Encode(user)
+		}
+		if err != nil {
+			return err
+		}
+
+		count++
+		if count%EXPORT_FLUSH == 0 {
+			if csvWriter != nil {
+				csvWriter.Flush()
+			}
+			c.Writer.Flush()
+		}
+
+		return nil
+	})
+
+	if err != nil && !started {
+		c.JSON(http.StatusInternalServerError, HTTPerr.ErrorJSON(err))
+		return
+	} else if err != nil {
+		c.Error(err)
+	}
+
+	if !started {
+		start()
+	}
+	if csvWriter != nil {
+		csvWriter.Flush()
+	}
+	c.Writer.Flush()
+}
+
+// readBulk decodes a JSON array or NDJSON stream of structs.BulkUser, other
+// fields of a user are ignored. A malformed NDJSON line is
+// reported in errs and does not stop the import, a malformed array does.
+func readBulk(body io.Reader) ([]mongogo.User, []error, error) {
+	reader := bufio.NewReader(body)
+
+	var users []mongogo.User
+	var errs []error
+
+	first, err := firstByte(reader)
+	if err == io.EOF {
+		return users, errs, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	if first == '[' {
+		decoder := json.NewDecoder(reader)
+
+		_, err := decoder.Token()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for decoder.More() {
+			var user structs.BulkUser
+
+			err := decoder.Decode(&user)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			users = append(users, user.User())
+			errs = append(errs, nil)
+		}
+
+		_, err = decoder.Token()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return users, errs, nil
+	}
+
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, nil, err
+		}
+
+		if len(bytes.TrimSpace(line)) > 0 {
+			var user structs.BulkUser
+
+			errs = append(errs, json.Unmarshal(line, &user))
+			users = append(users, user.User())
+		}
+
+		if err == io.EOF {
+			return users, errs, nil
+		}
+	}
+}
+
+func firstByte(reader *bufio.Reader) (byte, error) {
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+
+		if b != ' ' && b != '\t' && b != '\r' && b != '\n' {
+			return b, reader.UnreadByte()
+		}
+	}
+}
+
+// ValidateBulk rejects the users of an import without a name, with a negative
+// age or with an id given twice; without remap every user needs an id. Only
+// users without an error in errs are checked.
+func ValidateBulk(users []mongogo.User, errs []error, remap bool) {
+	seen := make(map[int]bool)
+
+	for i, user := range users {
+		if errs[i] != nil {
+			continue
+		}
+
+		if user.Name == "" {
+			errs[i] = fmt.Errorf("name is required")
+		} else if user.Age < 0 {
+			errs[i] = fmt.Errorf("age must not be negative")
+		} else if !remap && user.Id < 1 {
+			errs[i] = fmt.Errorf("id is required without remap")
+		} else if user.Id != 0 && seen[user.Id] {
+			errs[i] = &errors.UserExists{Id: user.Id}
+		}
+
+		if user.Id != 0 {
+			seen[user.Id] = true
+		}
+	}
+}
+
+// rejectMissingFriends rejects the users with friends that are neither in the
+// valid part of the payload nor known, until no such users are left.
+func rejectMissingFriends(users []mongogo.User, errs []error, known map[int]bool) {
+	for changed := true; changed; {
+		changed = false
+
+		valid := make(map[int]bool)
+		for i, user := range users {
+			if errs[i] == nil && user.Id != 0 {
+				valid[user.Id] = true
+			}
+		}
+
+		for i, user := range users {
+			if errs[i] != nil {
+				continue
+			}
+
+			var missing []int
+			for _, id := range user.Friends {
+				if !valid[id] && !known[id] {
+					missing = append(missing, id)
+				}
+			}
+
+			if len(missing) > 0 {
+				errs[i] = &errors.UndefinedIndexes{Indexes: missing}
+				changed = true
+			}
+		}
+	}
+}
+
+// CheckFriends is for imports that keep the ids of the payload, friends may
+// also be users that are stored already.
+func CheckFriends(store storage.Store, users []mongogo.User, errs []error) error {
+	inPayload := make(map[int]bool, len(users))
+	for _, user := range users {
+		inPayload[user.Id] = true
+	}
+
+	var outside []int
+	seen := make(map[int]bool)
+	for i, user := range users {
+		if errs[i] != nil {
+			continue
+		}
+
+		for _, id := range user.Friends {
+			if !inPayload[id] && !seen[id] {
+				seen[id] = true
+				outside = append(outside, id)
+			}
+		}
+	}
+
+	stored := make(map[int]bool, len(outside))
+	if len(outside) > 0 {
+		found, err := store.GetUsers(outside)
+		if err != nil {
+			return err
+		}
+
+		for _, user := range found {
+			stored[user.Id] = true
+		}
+	}
+
+	rejectMissingFriends(users, errs, stored)
+
+	return nil
+}
+
+// remapBulk gives every valid user a fresh id and rewrites friend ids. Friends
+// must be part of the payload.
+func (h *Handler) remapBulk(users []mongogo.User, errs []error) error {
+	rejectMissingFriends(users, errs, nil)
+
+	count := 0
+	for _, err := range errs {
+		if err == nil {
+			count++
+		}
+	}
+	if count == 0 {
+		return nil
+	}
+
+	next, err := h.store.ReserveIds(count)
+	if err != nil {
+		return err
+	}
+
+	ids := make(map[int]int)
+	for i := range users {
+		if errs[i] != nil {
+			continue
+		}
+
+		if users[i].Id != 0 {
+			ids[users[i].Id] = next
+		}
+		users[i].Id = next
+		next++
+	}
+
+	for i := range users {
+		if errs[i] == nil {
+			users[i].Friends = remapUser(users[i], ids).Friends
+		}
+	}
+
+	return nil
+}
+
+func remapUser(user mongogo.User, ids map[int]int) mongogo.User {
+	friends := make([]int, 0, len(user.Friends))
+	for _, id := range user.Friends {
+		if newId, ok := ids[id]; ok {
+			friends = append(friends, newId)
+		}
+	}
+
+	user.Id = ids[user.Id]
+	user.Friends = friends
+
+	return user
+}
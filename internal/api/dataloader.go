@@ -0,0 +1,106 @@
+package api
+
+import (
+	"gin-server/internal/mongogo"
+	"gin-server/internal/storage"
+	"sort"
+	"sync"
+)
+
+// userLoader batches the user lookups of one GraphQL request. Load only
+// queues the id, the first thunk that runs fetches every queued id with one
+// query, so the friends of all users on one level of the query cost a single
+// round trip. Loaded users are cached for the rest of the request.
+type userLoader struct {
+	store storage.Store
+
+	mu      sync.Mutex
+	pending []int
+	queued  map[int]bool
+	users   map[int]*mongogo.User
+	err     error
+}
+
+func newUserLoader(store storage.Store) *userLoader {
+	return &userLoader{store: store, queued: map[int]bool{}, users: map[int]*mongogo.User{}}
+}
+
+// Load returns a thunk of the user, nil when there is no such user.
+func (l *userLoader) Load(user_id int) func() (*mongogo.User, error) {
+	many := l.LoadMany([]int{user_id})
+
+	return func() (*mongogo.User, error) {
+		users, err := many()
+		if err != nil || len(users) == 0 {
+			return nil, err
+		}
+
+		return users[0], nil
+	}
+}
+
+// LoadMany returns a thunk of the users in the order of the ids, users that
+// do not exist are left out.
+func (l *userLoader) LoadMany(user_ids []int) func() ([]*mongogo.User, error) {
+	l.mu.Lock()
+	for _, userId := range user_ids {
+		if _, ok := l.users[userId]; !ok && !l.queued[userId] {
+			l.pending = append(l.pending, userId)
+			l.queued[userId] = true
+		}
+	}
+	l.mu.Unlock()
+
+	return func() ([]*mongogo.User, error) {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		if len(l.pending) > 0 {
+			l.fetch()
+		}
+		if l.err != nil {
+			return nil, l.err
+		}
+
+		result := []*mongogo.User{}
+		for _, userId := range user_ids {
+			if user := l.users[userId]; user != nil {
+				result = append(result, user)
+			}
+		}
+
+		return result, nil
+	}
+}
+
+// fetch loads the pending ids in ascending order, as fields queue them in no
+// fixed order. Ids without a user are cached as nil.
+func (l *userLoader) fetch() {
+	ids := l.pending
+	l.pending = nil
+	l.queued = map[int]bool{}
+	sort.Ints(ids)
+
+	users, err := l.store.GetUsers(ids)
+	if err != nil {
+		l.err = err
+		return
+	}
+
+	for _, userId := range ids {
+		l.users[userId] = nil
+	}
+	for i := range users {
+		l.users[users[i].Id] = &users[i]
+	}
+}
+
+// forget drops users changed by a mutation, so that later fields read them again.
+func (l *userLoader) forget(user_ids ...int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, userId := range user_ids {
+		delete(l.users, userId)
+	}
+}
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"fmt"
+	"gin-server/internal/errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// USER_CACHE_CONTROL lets caches keep a user but revalidate it with
+	// If-None-Match on every request.
+	USER_CACHE_CONTROL string = "no-cache"
+	// DOCS_CACHE_CONTROL lets caches serve the documentation, which changes
+	// only with a deployment, for a while.
+	DOCS_CACHE_CONTROL string = "public, max-age=300"
+)
+
+// ETag renders a user version as a strong entity tag.
+func ETag(version int) string {
+	return strconv.Quote(strconv.Itoa(version))
+}
+
+// ifMatch returns the user version required by the If-Match header, zero if the
+// header is missing or "*".
+func ifMatch(c *gin.Context) (int, error) {
+	header := strings.TrimSpace(c.GetHeader("If-Match"))
+	if header == "" || header == "*" {
+		return 0, nil
+	}
+
+	tag, err := strconv.Unquote(header)
+	if err == nil {
+		version, err := strconv.Atoi(tag)
+		if err == nil && version > 0 {
+			return version, nil
+		}
+	}
+
+	return 0, fmt.Errorf("If-Match must be a single entity tag of a user, got %s", header)
+}
+
+// notModified reports whether the If-None-Match header matches the current
+// version of the user.
+func notModified(c *gin.Context, version int) bool {
+	for _, tag := range strings.Split(c.GetHeader("If-None-Match"), ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == ETag(version) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// updateStatus maps errors of a versioned user update to a status code.
+func updateStatus(err error) int {
+	switch err.(type) {
+	case *errors.UndefinedIndexes:
+		return http.StatusNotFound
+	case *errors.EmailExists:
+		return http.StatusConflict
+	case *errors.VersionMismatch:
+		return http.StatusPreconditionFailed
+	default:
+		return http.StatusInternalServerError
+	}
+}
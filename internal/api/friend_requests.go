@@ -0,0 +1,213 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"gin-server/internal/errors"
+	"gin-server/internal/mongogo"
+	"gin-server/internal/structs"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (h *Handler) SendFriendRequest(c *gin.Context) {
+	rawData, err := c.GetRawData()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, HTTPerr.ErrorJSON(err))
+		return
+	}
+
+	var request structs.FriendsRequest
+	err = json.Unmarshal(rawData, &request)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, HTTPerr.ErrorJSON(err))
+		return
+	}
+
+	h.audit(c, request.SourceId, request.TargetId)
+
+	friendRequest, err := h.store.SendFriendRequest(request.SourceId, request.TargetId)
+	if err != nil {
+		c.JSON(friendRequestStatus(err), HTTPerr.ErrorJSON(err))
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"ok":       true,
+		"response": friendRequest,
+	})
+}
+
+func (h *Handler) IncomingFriendRequests(c *gin.Context) {
+	h.listFriendRequests(c, true)
+}
+
+func (h *Handler) OutgoingFriendRequests(c *gin.Context) {
+	h.listFriendRequests(c, false)
+}
+
+func (h *Handler) listFriendRequests(c *gin.Context, incoming bool) {
+	userId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, HTTPerr.ErrorJSON(err))
+		return
+	}
+
+	state := c.Query("state")
+	switch state {
+	case "", mongogo.PENDING, mongogo.ACCEPTED, mongogo.REJECTED, mongogo.CANCELLED:
+	default:
+		c.JSON(http.StatusBadRequest, HTTPerr.ErrorJSON(fmt.Errorf("unknown state %q", state)))
+		return
+	}
+
+	requests, err := h.store.FriendRequests(userId, incoming, state)
+	if err != nil {
+		c.JSON(friendRequestStatus(err), HTTPerr.ErrorJSON(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"ok":       true,
+		"response": requests,
+	})
+}
+
+func (h *Handler) AcceptFriendRequest(c *gin.Context) {
+	h.answerFriendRequest(c, mongogo.ACCEPTED)
+}
+
+func (h *Handler) RejectFriendRequest(c *gin.Context) {
+	h.answerFriendRequest(c, mongogo.REJECTED)
+}
+
+func (h *Handler) CancelFriendRequest(c *gin.Context) {
+	h.answerFriendRequest(c, mongogo.CANCELLED)
+}
+
+// answerFriendRequest moves the request to state on behalf of the user in the body:
+// the target accepts or rejects, the source cancels.
+func (h *Handler) answerFriendRequest(c *gin.Context, state string) {
+	requestId, err := strconv.Atoi(c.Param("request_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, HTTPerr.ErrorJSON(err))
+		return
+	}
+
+	rawData, err := c.GetRawData()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, HTTPerr.ErrorJSON(err))
+		return
+	}
+
+	var request structs.AnswerFriendRequest
+	err = json.Unmarshal(rawData, &request)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, HTTPerr.ErrorJSON(err))
+		return
+	}
+
+	h.audit(c, request.UserId)
+
+	friendRequest, err := h.store.AnswerFriendRequest(requestId, request.UserId, state)
+	if err != nil {
+		c.JSON(friendRequestStatus(err), HTTPerr.ErrorJSON(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"ok":       true,
+		"response": friendRequest,
+	})
+}
+
+func (h *Handler) BlockUser(c *gin.Context) {
+	userId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, HTTPerr.ErrorJSON(err))
+		return
+	}
+
+	rawData, err := c.GetRawData()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, HTTPerr.ErrorJSON(err))
+		return
+	}
+
+	var request structs.BlockRequest
+	err = json.Unmarshal(rawData, &request)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, HTTPerr.ErrorJSON(err))
+		return
+	}
+
+	h.audit(c, userId, request.TargetId)
+
+	err = h.store.BlockUser(userId, request.TargetId)
+	if err != nil {
+		c.JSON(friendRequestStatus(err), HTTPerr.ErrorJSON(err))
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"ok":       true,
+		"response": fmt.Sprintf("User %d blocked by %d", request.TargetId, userId),
+	})
+}
+
+func (h *Handler) UnblockUser(c *gin.Context) {
+	userId, otherId, err := userPair(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, HTTPerr.ErrorJSON(err))
+		return
+	}
+
+	h.audit(c, userId, otherId)
+
+	err = h.store.UnblockUser(userId, otherId)
+	if err != nil {
+		c.JSON(friendRequestStatus(err), HTTPerr.ErrorJSON(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"ok":       true,
+		"response": fmt.Sprintf("User %d unblocked by %d", otherId, userId),
+	})
+}
+
+func (h *Handler) BlockedUsers(c *gin.Context) {
+	userId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, HTTPerr.ErrorJSON(err))
+		return
+	}
+
+	blocked, err := h.store.BlockedUsers(userId)
+	if err != nil {
+		c.JSON(friendRequestStatus(err), HTTPerr.ErrorJSON(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"ok":       true,
+		"response": blocked,
+	})
+}
+
+func friendRequestStatus(err error) int {
+	switch err.(type) {
+	case *errors.UndefinedIndexes, *errors.FriendsExists:
+		return http.StatusBadRequest
+	case *errors.UserBlocked, *errors.Forbidden:
+		return http.StatusForbidden
+	case *errors.UndefinedRequest:
+		return http.StatusNotFound
+	case *errors.RequestExists, *errors.RequestState:
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,149 @@
+package api
+
+import (
+	"fmt"
+	"gin-server/internal/errors"
+	"gin-server/internal/mongogo"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	SUGGESTIONS_DEPTH int = 2
+	SUGGESTIONS_LIMIT int = 20
+	PATH_DEPTH        int = 4
+	PATH_MAX_DEPTH    int = 6
+)
+
+// Suggestions returns friend-of-friend suggestions for the user, ?depth=2|3,
+// ?min_age=, ?max_age= and ?limit= narrow them down.
+func (h *Handler) Suggestions(c *gin.Context) {
+	userId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, HTTPerr.ErrorJSON(err))
+		return
+	}
+
+	query := mongogo.SuggestionsQuery{}
+
+	query.Depth, err = intQuery(c, "depth", SUGGESTIONS_DEPTH)
+	if err == nil && (query.Depth < 2 || query.Depth > 3) {
+		err = fmt.Errorf("depth must be 2 or 3")
+	}
+	if err == nil {
+		query.MinAge, err = intQuery(c, "min_age", 0)
+	}
+	if err == nil {
+		query.MaxAge, err = intQuery(c, "max_age", 0)
+	}
+	if err == nil {
+		query.Limit, err = intQuery(c, "limit", SUGGESTIONS_LIMIT)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, HTTPerr.ErrorJSON(err))
+		return
+	}
+
+	suggestions, err := h.store.Suggestions(userId, query)
+	if _, ok := err.(*errors.UndefinedIndexes); ok {
+		c.JSON(http.StatusBadRequest, HTTPerr.ErrorJSON(err))
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, HTTPerr.ErrorJSON(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"ok":       true,
+		"response": suggestions,
+	})
+}
+
+func (h *Handler) MutualFriends(c *gin.Context) {
+	userId, otherId, err := userPair(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, HTTPerr.ErrorJSON(err))
+		return
+	}
+
+	friends, err := h.store.MutualFriends(userId, otherId)
+	if _, ok := err.(*errors.UndefinedIndexes); ok {
+		c.JSON(http.StatusBadRequest, HTTPerr.ErrorJSON(err))
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, HTTPerr.ErrorJSON(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"ok":       true,
+		"response": friends,
+	})
+}
+
+// FriendPath returns the shortest friendship chain between two users, searching
+// at most ?max_depth= hops away.
+func (h *Handler) FriendPath(c *gin.Context) {
+	userId, otherId, err := userPair(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, HTTPerr.ErrorJSON(err))
+		return
+	}
+
+	maxDepth, err := intQuery(c, "max_depth", PATH_DEPTH)
+	if err == nil && (maxDepth < 1 || maxDepth > PATH_MAX_DEPTH) {
+		err = fmt.Errorf("max_depth must be in range 1..%d", PATH_MAX_DEPTH)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, HTTPerr.ErrorJSON(err))
+		return
+	}
+
+	path, err := h.store.FriendPath(userId, otherId, maxDepth)
+	if _, ok := err.(*errors.UndefinedIndexes); ok {
+		c.JSON(http.StatusBadRequest, HTTPerr.ErrorJSON(err))
+		return
+	} else if _, ok := err.(*errors.PathNotFound); ok {
+		c.JSON(http.StatusNotFound, HTTPerr.ErrorJSON(err))
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, HTTPerr.ErrorJSON(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"ok":       true,
+		"response": path,
+	})
+}
+
+func userPair(c *gin.Context) (int, int, error) {
+	userId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	otherId, err := strconv.Atoi(c.Param("other_id"))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return userId, otherId, nil
+}
+
+// intQuery reads a non-negative integer query parameter.
+func intQuery(c *gin.Context, name string, def int) (int, error) {
+	raw, ok := c.GetQuery(name)
+	if !ok {
+		return def, nil
+	}
+
+	value, err := strconv.Atoi(raw)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative integer", name)
+	}
+
+	return value, nil
+}
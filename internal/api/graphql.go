@@ -0,0 +1,651 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"gin-server/internal/errors"
+	"gin-server/internal/mongogo"
+	"gin-server/internal/structs"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+)
+
+const (
+	GRAPHQL_MAX_DEPTH      int = 5
+	GRAPHQL_MAX_COMPLEXITY int = 1000
+	// GRAPHQL_LIST_SIZE is the assumed length of lists without a first argument.
+	GRAPHQL_LIST_SIZE int = 10
+	FRIENDS_FIRST     int = 10
+	FRIENDS_MAX_FIRST int = 100
+)
+
+type graphqlKey struct{}
+
+// graphqlRequest is what the resolvers of one request share.
+type graphqlRequest struct {
+	h      *Handler
+	c      *gin.Context
+	loader *userLoader
+}
+
+func requestOf(p graphql.ResolveParams) *graphqlRequest {
+	return p.Context.Value(graphqlKey{}).(*graphqlRequest)
+}
+
+// graphqlError carries the code of a storage error to the errors of the answer.
+type graphqlError struct {
+	err  error
+	code string
+}
+
+func (ge *graphqlError) Error() string {
+	return ge.err.Error()
+}
+
+func (ge *graphqlError) Extensions() map[string]interface{} {
+	return map[string]interface{}{"code": ge.code}
+}
+
+func resolveError(err error) error {
+	code := "INTERNAL"
+
+	switch err.(type) {
+	case *errors.UndefinedIndexes:
+		code = "NOT_FOUND"
+	case *errors.FriendsExists, *errors.EmailExists:
+		code = "CONFLICT"
+	case *errors.VersionMismatch:
+		code = "VERSION_MISMATCH"
+	case *errors.UserBlocked:
+		code = "FORBIDDEN"
+	case *badRequest:
+		code = "BAD_REQUEST"
+	}
+
+	return &graphqlError{err: err, code: code}
+}
+
+type badRequest struct {
+	err error
+}
+
+func (br *badRequest) Error() string {
+	return br.err.Error()
+}
+
+var userType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "User",
+	Fields: graphql.Fields{
+		"id":          &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+		"name":        &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		"age":         &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+		"email":       &graphql.Field{Type: graphql.String},
+		"displayName": &graphql.Field{Type: graphql.String},
+		"bio":         &graphql.Field{Type: graphql.String},
+		"avatarUrl":   &graphql.Field{Type: graphql.String},
+		"version":     &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+	},
+})
+
+var userList = graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(userType)))
+
+// the fields that refer back to User are added once the type exists, then the
+// schema is built
+func init() {
+	userType.AddFieldConfig("friends", &graphql.Field{
+		Type:        userList,
+		Description: "Friends in the order they were added, after is the id of the last friend of the previous page.",
+		Args:        pageArgs,
+		Resolve:     resolveFriends,
+	})
+	userType.AddFieldConfig("mutualFriends", &graphql.Field{
+		Type:        userList,
+		Description: "Friends shared with another user, paged like friends.",
+		Args: withArgs(pageArgs, graphql.FieldConfigArgument{
+			"with": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+		}),
+		Resolve: resolveMutualFriends,
+	})
+
+	graphqlSchema = mustSchema(graphqlConfig)
+}
+
+var pageArgs = graphql.FieldConfigArgument{
+	"first": &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: FRIENDS_FIRST},
+	"after": &graphql.ArgumentConfig{Type: graphql.Int},
+}
+
+var profileArgs = graphql.FieldConfigArgument{
+	"name":        &graphql.ArgumentConfig{Type: graphql.String},
+	"age":         &graphql.ArgumentConfig{Type: graphql.Int},
+	"email":       &graphql.ArgumentConfig{Type: graphql.String},
+	"displayName": &graphql.ArgumentConfig{Type: graphql.String},
+	"bio":         &graphql.ArgumentConfig{Type: graphql.String},
+	"avatarUrl":   &graphql.ArgumentConfig{Type: graphql.String},
+}
+
+func withArgs(args graphql.FieldConfigArgument, more graphql.FieldConfigArgument) graphql.FieldConfigArgument {
+	result := graphql.FieldConfigArgument{}
+	for name, arg := range args {
+		result[name] = arg
+	}
+	for name, arg := range more {
+		result[name] = arg
+	}
+
+	return result
+}
+
+var graphqlSchema graphql.Schema
+
+var graphqlConfig = graphql.SchemaConfig{
+	Query: graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"user": &graphql.Field{
+				Type:    userType,
+				Args:    graphql.FieldConfigArgument{"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)}},
+				Resolve: resolveUser,
+			},
+			"users": &graphql.Field{
+				Type: userList,
+				Args: graphql.FieldConfigArgument{
+					"ids": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.Int)))},
+				},
+				Resolve: resolveUsers,
+			},
+		},
+	}),
+	Mutation: graphql.NewObject(graphql.ObjectConfig{
+		Name: "Mutation",
+		Fields: graphql.Fields{
+			"createUser": &graphql.Field{
+				Type: graphql.NewNonNull(userType),
+				Args: withArgs(profileArgs, graphql.FieldConfigArgument{
+					"name": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				}),
+				Resolve: resolveCreateUser,
+			},
+			"updateUser": &graphql.Field{
+				Type:        graphql.NewNonNull(userType),
+				Description: "Changes the given fields, only if the user still has the version when it is set.",
+				Args: withArgs(profileArgs, graphql.FieldConfigArgument{
+					"id":      &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+					"version": &graphql.ArgumentConfig{Type: graphql.Int},
+				}),
+				Resolve: resolveUpdateUser,
+			},
+			"deleteUser": &graphql.Field{
+				Type:    graphql.NewNonNull(graphql.Boolean),
+				Args:    graphql.FieldConfigArgument{"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)}},
+				Resolve: resolveDeleteUser,
+			},
+			"addFriend": &graphql.Field{
+				Type:        graphql.NewNonNull(userType),
+				Description: "Adds sourceId to the friends of targetId and returns the target.",
+				Args: graphql.FieldConfigArgument{
+					"sourceId": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+					"targetId": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+				},
+				Resolve: resolveAddFriend,
+			},
+		},
+	}),
+}
+
+func mustSchema(config graphql.SchemaConfig) graphql.Schema {
+	schema, err := graphql.NewSchema(config)
+	if err != nil {
+		panic(err)
+	}
+
+	return schema
+}
+
+func resolveUser(p graphql.ResolveParams) (interface{}, error) {
+	load := requestOf(p).loader.Load(p.Args["id"].(int))
+
+	return func() (interface{}, error) {
+		user, err := load()
+		if err != nil {
+			return nil, resolveError(err)
+		} else if user == nil {
+			return nil, nil
+		}
+
+		return user, nil
+	}, nil
+}
+
+func resolveUsers(p graphql.ResolveParams) (interface{}, error) {
+	ids := []int{}
+	for _, id := range p.Args["ids"].([]interface{}) {
+		ids = append(ids, id.(int))
+	}
+	if len(ids) > FRIENDS_MAX_FIRST {
+		return nil, resolveError(&badRequest{fmt.Errorf("ids must hold at most %d ids", FRIENDS_MAX_FIRST)})
+	}
+
+	return loadUsers(requestOf(p).loader.LoadMany(ids)), nil
+}
+
+func loadUsers(load func() ([]*mongogo.User, error)) func() (interface{}, error) {
+	return func() (interface{}, error) {
+		users, err := load()
+		if err != nil {
+			return nil, resolveError(err)
+		}
+
+		return users, nil
+	}
+}
+
+func resolveFriends(p graphql.ResolveParams) (interface{}, error) {
+	user := p.Source.(*mongogo.User)
+
+	ids, err := page(p, user.Friends)
+	if err != nil {
+		return nil, resolveError(err)
+	}
+
+	return loadUsers(requestOf(p).loader.LoadMany(ids)), nil
+}
+
+// resolveMutualFriends reads the other user and the mutual friends through
+// the loader, like the friends of the user.
+func resolveMutualFriends(p graphql.ResolveParams) (interface{}, error) {
+	user := p.Source.(*mongogo.User)
+	otherId := p.Args["with"].(int)
+	loader := requestOf(p).loader
+	load := loader.Load(otherId)
+
+	return func() (interface{}, error) {
+		other, err := load()
+		if err != nil {
+			return nil, resolveError(err)
+		} else if other == nil {
+			return nil, resolveError(&errors.UndefinedIndexes{Indexes: []int{otherId}})
+		}
+
+		ids, err := page(p, mongogo.Intersect(user.Friends, other.Friends))
+		if err != nil {
+			return nil, resolveError(err)
+		}
+
+		return loadUsers(loader.LoadMany(ids))()
+	}, nil
+}
+
+// page returns the first ids after the id in the after argument.
+func page(p graphql.ResolveParams, ids []int) ([]int, error) {
+	first := p.Args["first"].(int)
+	if first < 1 || first > FRIENDS_MAX_FIRST {
+		return nil, &badRequest{fmt.Errorf("first must be in range 1..%d", FRIENDS_MAX_FIRST)}
+	}
+
+	if after, ok := p.Args["after"].(int); ok {
+		rest := []int{}
+		for i, id := range ids {
+			if id == after {
+				rest = ids[i+1:]
+				break
+			}
+		}
+		ids = rest
+	}
+	if len(ids) > first {
+		ids = ids[:first]
+	}
+
+	return ids, nil
+}
+
+func profileUpdate(args map[string]interface{}) (mongogo.ProfileUpdate, error) {
+	var update mongogo.ProfileUpdate
+
+	strs := map[string]**string{
+		"name":        &update.Name,
+		"email":       &update.Email,
+		"displayName": &update.DisplayName,
+		"bio":         &update.Bio,
+		"avatarUrl":   &update.AvatarURL,
+	}
+	for name, field := range strs {
+		if value, ok := args[name].(string); ok {
+			*field = &value
+		}
+	}
+	if age, ok := args["age"].(int); ok {
+		update.Age = &age
+	}
+
+	err := ValidateUpdate(update)
+	if err != nil {
+		return update, &badRequest{err}
+	}
+
+	return update, nil
+}
+
+func resolveCreateUser(p graphql.ResolveParams) (interface{}, error) {
+	request := requestOf(p)
+
+	update, err := profileUpdate(p.Args)
+	if err != nil {
+		return nil, resolveError(err)
+	}
+
+	user := mongogo.User{Name: *update.Name}
+	if update.Age != nil {
+		user.Age = *update.Age
+	}
+	if update.Email != nil {
+		user.Email = *update.Email
+	}
+	if update.DisplayName != nil {
+		user.DisplayName = *update.DisplayName
+	}
+	if update.Bio != nil {
+		user.Bio = *update.Bio
+	}
+	if update.AvatarURL != nil {
+		user.AvatarURL = *update.AvatarURL
+	}
+
+	userId, err := request.h.store.CreateUser(user)
+	if err != nil {
+		return nil, resolveError(err)
+	}
+	auditCreated(request.c, userId)
+
+	user, err = request.h.store.GetUser(userId)
+	if err != nil {
+		return nil, resolveError(err)
+	}
+
+	return &user, nil
+}
+
+func resolveUpdateUser(p graphql.ResolveParams) (interface{}, error) {
+	request := requestOf(p)
+	userId := p.Args["id"].(int)
+	version, _ := p.Args["version"].(int)
+
+	update, err := profileUpdate(p.Args)
+	if err != nil {
+		return nil, resolveError(err)
+	}
+
+	request.h.audit(request.c, userId)
+
+	user, err := request.h.store.UpdateProfile(userId, version, update)
+	if err != nil {
+		return nil, resolveError(err)
+	}
+	request.loader.forget(userId)
+
+	return &user, nil
+}
+
+func resolveDeleteUser(p graphql.ResolveParams) (interface{}, error) {
+	request := requestOf(p)
+	userId := p.Args["id"].(int)
+
+	request.h.audit(request.c, userId)
+
+	_, err := request.h.store.DelUser(userId)
+	if err != nil {
+		return nil, resolveError(err)
+	}
+	request.loader.forget(userId)
+
+	return true, nil
+}
+
+func resolveAddFriend(p graphql.ResolveParams) (interface{}, error) {
+	request := requestOf(p)
+	sourceId, targetId := p.Args["sourceId"].(int), p.Args["targetId"].(int)
+
+	request.h.audit(request.c, targetId, sourceId)
+
+	err := request.h.store.AddFriend(sourceId, targetId)
+	if err != nil {
+		return nil, resolveError(err)
+	}
+	request.loader.forget(targetId)
+
+	user, err := request.h.store.GetUser(targetId)
+	if err != nil {
+		return nil, resolveError(err)
+	}
+
+	return &user, nil
+}
+
+// GraphQL executes a query or mutation in the body. Queries deeper than
+// GRAPHQL_MAX_DEPTH or costlier than GRAPHQL_MAX_COMPLEXITY are refused before
+// they run, see queryCost.
+func (h *Handler) GraphQL(c *gin.Context) {
+	// only mutations are audited
+	readOnly := true
+	defer func() {
+		if readOnly {
+			auditReadOnly(c)
+		}
+	}()
+
+	var request structs.GraphQLRequest
+	err := c.ShouldBindJSON(&request)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, graphqlErrors(err))
+		return
+	}
+
+	document, err := parser.Parse(parser.ParseParams{Source: source.NewSource(&source.Source{
+		Body: []byte(request.Query),
+		Name: "GraphQL request",
+	})})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, graphqlErrors(err))
+		return
+	}
+
+	validation := graphql.ValidateDocument(&graphqlSchema, document, nil)
+	if !validation.IsValid {
+		c.JSON(http.StatusBadRequest, &graphql.Result{Errors: validation.Errors})
+		return
+	}
+
+	operations := operationsOf(document, request.OperationName)
+
+	err = checkCost(document, operations, request.Variables)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, graphqlErrors(err))
+		return
+	}
+
+	if len(operations) == 1 && operations[0].Operation == ast.OperationTypeMutation {
+		readOnly = false
+	}
+
+	ctx := context.WithValue(c.Request.Context(), graphqlKey{}, &graphqlRequest{
+		h:      h,
+		c:      c,
+		loader: newUserLoader(h.store),
+	})
+
+	result := graphql.Execute(graphql.ExecuteParams{
+		Schema:        graphqlSchema,
+		AST:           document,
+		OperationName: request.OperationName,
+		Args:          request.Variables,
+		Context:       ctx,
+	})
+
+	c.JSON(http.StatusOK, result)
+}
+
+func graphqlErrors(err error) *graphql.Result {
+	return &graphql.Result{Errors: []gqlerrors.FormattedError{gqlerrors.NewFormattedError(err.Error())}}
+}
+
+// queryCost measures a query before it runs: depth counts nested fields and
+// complexity counts every field once per item of the lists around it, taking
+// first or the number of ids as the length of a list. Every item costs at
+// least 1, it is loaded whatever is selected of it. A first out of range or
+// too many ids refuse the query, they would make the lists cheaper than they
+// are. Introspection fields are free.
+type queryCost struct {
+	fragments map[string]*ast.FragmentDefinition
+	variables map[string]interface{}
+}
+
+// operationsOf returns the operations of the document that may run: the named
+// one, or all of them when no name is given.
+func operationsOf(document *ast.Document, operationName string) []*ast.OperationDefinition {
+	var operations []*ast.OperationDefinition
+	for _, definition := range document.Definitions {
+		operation, ok := definition.(*ast.OperationDefinition)
+		if ok && (operationName == "" || (operation.Name != nil && operation.Name.Value == operationName)) {
+			operations = append(operations, operation)
+		}
+	}
+
+	return operations
+}
+
+func checkCost(document *ast.Document, operations []*ast.OperationDefinition, variables map[string]interface{}) error {
+	cost := queryCost{fragments: map[string]*ast.FragmentDefinition{}, variables: variables}
+	for _, definition := range document.Definitions {
+		if fragment, ok := definition.(*ast.FragmentDefinition); ok {
+			cost.fragments[fragment.Name.Value] = fragment
+		}
+	}
+
+	for _, operation := range operations {
+		depth, complexity, err := cost.measure(operation.SelectionSet)
+		if err != nil {
+			return err
+		}
+		if depth > GRAPHQL_MAX_DEPTH {
+			return fmt.Errorf("query depth %d exceeds the limit of %d", depth, GRAPHQL_MAX_DEPTH)
+		}
+		if complexity > GRAPHQL_MAX_COMPLEXITY {
+			return fmt.Errorf("query complexity %d exceeds the limit of %d", complexity, GRAPHQL_MAX_COMPLEXITY)
+		}
+	}
+
+	return nil
+}
+
+func (qc queryCost) measure(selectionSet *ast.SelectionSet) (int, int, error) {
+	if selectionSet == nil {
+		return 0, 0, nil
+	}
+
+	depth, complexity := 0, 0
+	for _, selection := range selectionSet.Selections {
+		var d, c, size int
+		var err error
+
+		switch selection := selection.(type) {
+		case *ast.Field:
+			if strings.HasPrefix(selection.Name.Value, "__") {
+				continue
+			}
+
+			size, err = qc.listSize(selection)
+			if err == nil {
+				d, c, err = qc.measure(selection.SelectionSet)
+				if listFields[selection.Name.Value] && c < 1 {
+					c = 1
+				}
+				d, c = d+1, 1+size*c
+			}
+		case *ast.InlineFragment:
+			d, c, err = qc.measure(selection.SelectionSet)
+		case *ast.FragmentSpread:
+			if fragment, ok := qc.fragments[selection.Name.Value]; ok {
+				d, c, err = qc.measure(fragment.SelectionSet)
+			}
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+
+		if d > depth {
+			depth = d
+		}
+		complexity += c
+	}
+
+	return depth, complexity, nil
+}
+
+// listFields are the fields that return lists of users.
+var listFields = map[string]bool{"friends": true, "mutualFriends": true, "users": true}
+
+func (qc queryCost) listSize(field *ast.Field) (int, error) {
+	if !listFields[field.Name.Value] {
+		return 1, nil
+	}
+
+	for _, argument := range field.Arguments {
+		switch argument.Name.Value {
+		case "first":
+			if size, ok := qc.intValue(argument.Value); ok {
+				if size < 1 || size > FRIENDS_MAX_FIRST {
+					return 0, fmt.Errorf("first must be in range 1..%d", FRIENDS_MAX_FIRST)
+				}
+				return size, nil
+			}
+		case "ids":
+			size := -1
+			switch ids := argument.Value.(type) {
+			case *ast.ListValue:
+				size = len(ids.Values)
+			case *ast.Variable:
+				if list, ok := qc.variables[ids.Name.Value].([]interface{}); ok {
+					size = len(list)
+				}
+			}
+
+			if size > FRIENDS_MAX_FIRST {
+				return 0, fmt.Errorf("ids must hold at most %d ids", FRIENDS_MAX_FIRST)
+			} else if size >= 0 {
+				return size, nil
+			}
+		}
+	}
+
+	if field.Name.Value != "users" {
+		return FRIENDS_FIRST, nil
+	}
+
+	return GRAPHQL_LIST_SIZE, nil
+}
+
+func (qc queryCost) intValue(value ast.Value) (int, bool) {
+	switch value := value.(type) {
+	case *ast.IntValue:
+		var result int
+		_, err := fmt.Sscan(value.Value, &result)
+		return result, err == nil
+	case *ast.Variable:
+		switch result := qc.variables[value.Name.Value].(type) {
+		case float64:
+			return int(result), true
+		case int:
+			return result, true
+		}
+	}
+
+	return 0, false
+}
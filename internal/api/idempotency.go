@@ -0,0 +1,109 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"gin-server/internal/errors"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+const IDEMPOTENCY_KEY_MAX int = 255
+
+// recorder keeps a copy of the response body, so that it can be stored for
+// the idempotency key of the request.
+type recorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *recorder) Write(data []byte) (int, error) {
+	r.body.Write(data)
+	return r.ResponseWriter.Write(data)
+}
+
+func (r *recorder) WriteString(s string) (int, error) {
+	r.body.WriteString(s)
+	return r.ResponseWriter.WriteString(s)
+}
+
+// Idempotent handles the request once per Idempotency-Key header and replays
+// the stored response for repeated keys. A key reused with another request is
+// answered with 422, a key whose request is still running with 409. Requests
+// that fail with a server error or panic are not stored and can be retried.
+func (h *Handler) Idempotent(c *gin.Context) {
+	key := c.GetHeader("Idempotency-Key")
+	if key == "" {
+		c.Next()
+		return
+	}
+	if len(key) > IDEMPOTENCY_KEY_MAX {
+		c.AbortWithStatusJSON(http.StatusBadRequest, HTTPerr.ErrorJSON(
+			fmt.Errorf("Idempotency-Key must not be longer than %d bytes", IDEMPOTENCY_KEY_MAX)))
+		return
+	}
+
+	body, err := c.GetRawData()
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, HTTPerr.ErrorJSON(err))
+		return
+	}
+	c.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	// the versioned route and its deprecated alias are the same request
+	path, _ := versionPath(c.FullPath())
+
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s %s\n", c.Request.Method, path)
+	hash.Write(body)
+	sum := hex.EncodeToString(hash.Sum(nil))
+
+	record, reserved, err := h.store.ReserveKey(key, sum)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, HTTPerr.ErrorJSON(err))
+		return
+	}
+
+	if !reserved {
+		if record.Hash != sum {
+			c.AbortWithStatusJSON(http.StatusUnprocessableEntity, HTTPerr.ErrorJSON(&errors.KeyConflict{Key: key}))
+			return
+		}
+		if record.Status == 0 {
+			c.AbortWithStatusJSON(http.StatusConflict, HTTPerr.ErrorJSON(&errors.KeyInProgress{Key: key}))
+			return
+		}
+
+		c.Header("Idempotent-Replayed", "true")
+		c.Data(record.Status, record.ContentType, record.Body)
+		c.Abort()
+		return
+	}
+
+	writer := &recorder{ResponseWriter: c.Writer}
+	c.Writer = writer
+
+	// a panic passes on to the recovery, without leaving the key taken
+	completed := false
+	defer func() {
+		if !completed {
+			h.store.ReleaseKey(key)
+		}
+	}()
+
+	c.Next()
+	completed = true
+
+	if writer.Status() >= http.StatusInternalServerError {
+		err = h.store.ReleaseKey(key)
+	} else {
+		err = h.store.CompleteKey(key, writer.Status(), writer.Header().Get("Content-Type"), writer.body.Bytes())
+	}
+	if err != nil {
+		c.Error(err)
+	}
+}
@@ -0,0 +1,294 @@
+package api
+
+import (
+	_ "embed"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+//go:embed swagger.html
+var swaggerPage []byte
+
+// Doc describes a single route for the OpenAPI document and the methods list,
+// path parameters are taken from Path. Request is the JSON body type (nil if
+// the route takes no body), Response is the type of the "response" field of a
+// successful answer.
+type Doc struct {
+	Method   string
+	Path     string
+	Summary  string
+	Status   int
+	Request  interface{}
+	Response interface{}
+}
+
+// FindDoc looks up the documentation of a registered route, versioned or not.
+func FindDoc(method, path string) (Doc, bool) {
+	path, _ = versionPath(path)
+
+	for _, doc := range Docs() {
+		if doc.Method == method && doc.Path == path {
+			return doc, true
+		}
+	}
+
+	return Doc{}, false
+}
+
+// Undocumented returns the registered routes that are not documented in Docs.
+func Undocumented(routes gin.RoutesInfo) []string {
+	var result []string
+
+	for _, route := range routes {
+		if _, ok := FindDoc(route.Method, route.Path); !ok {
+			result = append(result, route.Method+" "+route.Path)
+		}
+	}
+
+	return result
+}
+
+// OpenAPI builds an OpenAPI 3 document from the routes registered on the router.
+func OpenAPI(routes gin.RoutesInfo) gin.H {
+	schemas := gin.H{}
+	paths := gin.H{}
+
+	for _, route := range routes {
+		doc, ok := FindDoc(route.Method, route.Path)
+		if !ok {
+			continue
+		}
+
+		path, params := openAPIPath(route.Path)
+
+		operation := gin.H{
+			"summary":     doc.Summary,
+			"operationId": operationId(route.Method, route.Path),
+			"responses":   openAPIResponses(doc, schemas),
+		}
+		if len(params) > 0 {
+			operation["parameters"] = params
+		}
+		if _, deprecated := versionPath(route.Path); deprecated {
+			operation["deprecated"] = true
+		}
+		if doc.Request != nil {
+			operation["requestBody"] = gin.H{
+				"required": true,
+				"content": gin.H{
+					"application/json": gin.H{"schema": schemaOf(reflect.TypeOf(doc.Request), schemas)},
+				},
+			}
+		}
+
+		item, ok := paths[path].(gin.H)
+		if !ok {
+			item = gin.H{}
+			paths[path] = item
+		}
+		item[strings.ToLower(route.Method)] = operation
+	}
+
+	return gin.H{
+		"openapi": "3.0.3",
+		"info": gin.H{
+			"title":   "gin-server users API",
+			"version": strings.TrimPrefix(Version, "/") + ".0.0",
+		},
+		"paths":      paths,
+		"components": gin.H{"schemas": schemas},
+	}
+}
+
+// OpenAPIHandler serves the OpenAPI document of the given router.
+func OpenAPIHandler(router *gin.Engine) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Cache-Control", DOCS_CACHE_CONTROL)
+		c.JSON(http.StatusOK, OpenAPI(router.Routes()))
+	}
+}
+
+func SwaggerUI(c *gin.Context) {
+	c.Header("Cache-Control", DOCS_CACHE_CONTROL)
+	c.Data(http.StatusOK, "text/html; charset=utf-8", swaggerPage)
+}
+
+func openAPIResponses(doc Doc, schemas gin.H) gin.H {
+	status := doc.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	responses := gin.H{}
+
+	if doc.Response == nil {
+		responses[strconv.Itoa(status)] = gin.H{"description": http.StatusText(status)}
+		return responses
+	}
+
+	responses[strconv.Itoa(status)] = gin.H{
+		"description": http.StatusText(status),
+		"content": gin.H{
+			"application/json": gin.H{"schema": answerSchema(true, schemaOf(reflect.TypeOf(doc.Response), schemas))},
+		},
+	}
+
+	errorContent := gin.H{
+		"application/json": gin.H{"schema": answerSchema(false, gin.H{"type": "string"})},
+	}
+	responses["400"] = gin.H{"description": http.StatusText(http.StatusBadRequest), "content": errorContent}
+	responses["500"] = gin.H{"description": http.StatusText(http.StatusInternalServerError), "content": errorContent}
+
+	return responses
+}
+
+func answerSchema(ok bool, response gin.H) gin.H {
+	return gin.H{
+		"type":     "object",
+		"required": []string{"ok", "response"},
+		"properties": gin.H{
+			"ok":       gin.H{"type": "boolean", "enum": []bool{ok}},
+			"response": response,
+		},
+	}
+}
+
+// schemaOf converts a Go type to a JSON schema; named structs are stored in
+// schemas and referenced by name.
+func schemaOf(t reflect.Type, schemas gin.H) gin.H {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return gin.H{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return gin.H{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return gin.H{"type": "number"}
+	case reflect.String:
+		return gin.H{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return gin.H{"type": "array", "items": schemaOf(t.Elem(), schemas)}
+	case reflect.Map:
+		return gin.H{"type": "object", "additionalProperties": schemaOf(t.Elem(), schemas)}
+	case reflect.Struct:
+		if t.PkgPath() == "time" && t.Name() == "Time" {
+			return gin.H{"type": "string", "format": "date-time"}
+		}
+	default:
+		return gin.H{}
+	}
+
+	ref := gin.H{"$ref": "#/components/schemas/" + t.Name()}
+	if _, ok := schemas[t.Name()]; ok {
+		return ref
+	}
+
+	properties := gin.H{}
+	schema := gin.H{"type": "object", "properties": properties}
+	schemas[t.Name()] = schema
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		properties[name] = schemaOf(field.Type, schemas)
+	}
+
+	return ref
+}
+
+// openAPIPath converts gin path parameters (:user_id) to OpenAPI ones ({user_id}).
+func openAPIPath(path string) (string, []gin.H) {
+	var params []gin.H
+
+	parts := strings.Split(path, "/")
+	for i, part := range parts {
+		if !strings.HasPrefix(part, ":") && !strings.HasPrefix(part, "*") {
+			continue
+		}
+
+		name := part[1:]
+		parts[i] = "{" + name + "}"
+
+		schema := gin.H{"type": "string"}
+		if strings.HasSuffix(name, "id") {
+			schema = gin.H{"type": "integer"}
+		}
+
+		params = append(params, gin.H{
+			"name":     name,
+			"in":       "path",
+			"required": true,
+			"schema":   schema,
+		})
+	}
+
+	return strings.Join(parts, "/"), params
+}
+
+func operationId(method, path string) string {
+	name := strings.ToLower(method)
+
+	for _, part := range strings.Split(path, "/") {
+		part = strings.Trim(part, ":*{}")
+		if part == "" {
+			continue
+		}
+
+		name += "_" + strings.ReplaceAll(strings.ReplaceAll(part, ".", "_"), "-", "_")
+	}
+
+	return name
+}
+
+// requestHint renders a request body type as {field: type, ...} for the methods list.
+func requestHint(request interface{}) string {
+	return typeHint(reflect.TypeOf(request))
+}
+
+func typeHint(t reflect.Type) string {
+	if t.Kind() == reflect.Slice {
+		return "[" + typeHint(t.Elem()) + "]"
+	}
+	if t.Kind() != reflect.Struct {
+		return t.String()
+	}
+
+	var fields []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		fields = append(fields, name+": "+field.Type.String())
+	}
+
+	return "{" + strings.Join(fields, ", ") + "}"
+}
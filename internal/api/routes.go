@@ -0,0 +1,229 @@
+package api
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"gin-server/internal/cache"
+	"gin-server/internal/events"
+	"gin-server/internal/mongogo"
+	"gin-server/internal/storage"
+	"gin-server/internal/structs"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Version is the prefix of the current API version. Routes registered outside of
+// it are the old unversioned paths, kept as deprecated aliases.
+const Version = "/v1"
+
+// Deps are the dependencies of the handlers. Hub is optional, without it the
+// event streams answer 503.
+type Deps struct {
+	Store storage.Store
+	Hub   *events.Hub
+	// Origins are the web origins, besides the one of the api itself, whose
+	// pages may open WebSocket streams.
+	Origins []string
+	// AdminToken is the bearer token of the /admin routes, they are refused
+	// while it is empty.
+	AdminToken string
+	// WebhookNetworks are the networks of addresses that are not public but
+	// webhooks may be registered for.
+	WebhookNetworks []*net.IPNet
+}
+
+// ADMIN_PATH is the prefix of the routes that need the admin token.
+const ADMIN_PATH string = "/admin"
+
+// route is a registration together with its documentation, Doc.Path is the
+// path relative to Version.
+type route struct {
+	Doc
+	handlers []gin.HandlerFunc
+}
+
+func handlers(list ...gin.HandlerFunc) []gin.HandlerFunc {
+	return list
+}
+
+// RegisterRoutes mounts the API under Version and the deprecated unversioned aliases.
+func RegisterRoutes(router *gin.Engine, deps Deps) {
+	h := NewHandler(deps)
+
+	v1 := router.Group(Version, h.Audit)
+	register(v1, legacyRoutes(router, h))
+	register(v1, v1Routes(h))
+	register(v1.Group(ADMIN_PATH, h.Admin), adminRoutes(h))
+
+	register(router.Group("/", Deprecated(Version), h.Audit), legacyRoutes(router, h))
+}
+
+// Docs documents the routes in the order they are registered under Version.
+// The routes are built without a Handler, their handlers are never called.
+func Docs() []Doc {
+	var docs []Doc
+	for _, routes := range [][]route{legacyRoutes(nil, nil), v1Routes(nil), adminRoutes(nil)} {
+		for _, route := range routes {
+			docs = append(docs, route.Doc)
+		}
+	}
+
+	return docs
+}
+
+// register mounts routes on group, whose path relative to Version is cut off
+// the documented paths.
+func register(group *gin.RouterGroup, routes []route) {
+	prefix := strings.TrimPrefix(group.BasePath(), Version)
+	for _, route := range routes {
+		group.Handle(route.Method, strings.TrimPrefix(route.Path, prefix), route.handlers...)
+	}
+}
+
+// legacyRoutes are the routes that existed before versioning, they are
+// mounted both under Version and as deprecated aliases.
+func legacyRoutes(router *gin.Engine, h *Handler) []route {
+	return []route{
+		{Doc{Method: http.MethodGet, Path: "/", Summary: "methods list", Status: http.StatusOK, Response: ""},
+			handlers(MethodsList)},
+		{Doc{Method: http.MethodPost, Path: "/create", Summary: "create new user", Status: http.StatusCreated,
+			Request: structs.CreateUserRequest{}, Response: structs.CreateUserResponse{}},
+			handlers(h.Idempotent, h.CreateUser)},
+		{Doc{Method: http.MethodPost, Path: "/make_friends", Summary: "add friend to target user", Status: http.StatusCreated,
+			Request: structs.FriendsRequest{}, Response: ""},
+			handlers(h.Idempotent, h.MakeFriends)},
+		{Doc{Method: http.MethodGet, Path: "/friends/:user_id", Summary: "get friend list", Status: http.StatusOK,
+			Response: []mongogo.User{}},
+			handlers(h.GetFriends)},
+		{Doc{Method: http.MethodDelete, Path: "/user", Summary: "delete user", Status: http.StatusOK,
+			Request: structs.DeleteRequest{}, Response: ""},
+			handlers(h.DeleteUser)},
+		{Doc{Method: http.MethodPut, Path: "/:user_id", Summary: "edit user age", Status: http.StatusOK,
+			Request: structs.EditAgeRequest{}, Response: ""},
+			handlers(h.EditAge)},
+		{Doc{Method: http.MethodGet, Path: "/openapi.json", Summary: "OpenAPI document", Status: http.StatusOK},
+			handlers(OpenAPIHandler(router))},
+		{Doc{Method: http.MethodGet, Path: "/docs", Summary: "Swagger UI", Status: http.StatusOK},
+			handlers(SwaggerUI)},
+	}
+}
+
+// v1Routes are the routes that exist only under Version.
+func v1Routes(h *Handler) []route {
+	return []route{
+		{Doc{Method: http.MethodGet, Path: "/users/search", Summary: "search users by name and age", Status: http.StatusOK,
+			Response: structs.SearchResponse{}},
+			handlers(h.SearchUsers)},
+		{Doc{Method: http.MethodGet, Path: "/users/:id", Summary: "get user profile", Status: http.StatusOK,
+			Response: mongogo.User{}},
+			handlers(h.GetUser)},
+		{Doc{Method: http.MethodPatch, Path: "/users/:id", Summary: "update user profile", Status: http.StatusOK,
+			Request: mongogo.ProfileUpdate{}, Response: mongogo.User{}},
+			handlers(h.UpdateProfile)},
+		{Doc{Method: http.MethodPost, Path: "/users/bulk", Summary: "import users", Status: http.StatusOK,
+			Request: []structs.BulkUser{}, Response: structs.BulkResponse{}},
+			handlers(h.BulkUsers)},
+		{Doc{Method: http.MethodGet, Path: "/users/export", Summary: "export users", Status: http.StatusOK},
+			handlers(h.ExportUsers)},
+		{Doc{Method: http.MethodGet, Path: "/users/:id/suggestions", Summary: "friend-of-friend suggestions", Status: http.StatusOK,
+			Response: []mongogo.Suggestion{}},
+			handlers(h.Suggestions)},
+		{Doc{Method: http.MethodGet, Path: "/users/:id/mutual/:other_id", Summary: "mutual friends of two users", Status: http.StatusOK,
+			Response: []mongogo.User{}},
+			handlers(h.MutualFriends)},
+		{Doc{Method: http.MethodGet, Path: "/users/:id/path/:other_id", Summary: "shortest friendship chain", Status: http.StatusOK,
+			Response: []mongogo.User{}},
+			handlers(h.FriendPath)},
+		{Doc{Method: http.MethodGet, Path: "/users/:id/events", Summary: "stream of user changes (SSE)", Status: http.StatusOK},
+			handlers(h.UserEvents)},
+		{Doc{Method: http.MethodGet, Path: "/users/:id/events/ws", Summary: "stream of user changes (WebSocket)", Status: http.StatusSwitchingProtocols},
+			handlers(h.UserEventsSocket)},
+
+		{Doc{Method: http.MethodPost, Path: "/graphql", Summary: "GraphQL queries and mutations", Status: http.StatusOK,
+			Request: structs.GraphQLRequest{}},
+			handlers(h.GraphQL)},
+
+		{Doc{Method: http.MethodPost, Path: "/friend_requests", Summary: "send friend request", Status: http.StatusCreated,
+			Request: structs.FriendsRequest{}, Response: mongogo.FriendRequest{}},
+			handlers(h.SendFriendRequest)},
+		{Doc{Method: http.MethodPost, Path: "/friend_requests/:request_id/accept", Summary: "accept friend request", Status: http.StatusOK,
+			Request: structs.AnswerFriendRequest{}, Response: mongogo.FriendRequest{}},
+			handlers(h.AcceptFriendRequest)},
+		{Doc{Method: http.MethodPost, Path: "/friend_requests/:request_id/reject", Summary: "reject friend request", Status: http.StatusOK,
+			Request: structs.AnswerFriendRequest{}, Response: mongogo.FriendRequest{}},
+			handlers(h.RejectFriendRequest)},
+		{Doc{Method: http.MethodPost, Path: "/friend_requests/:request_id/cancel", Summary: "cancel friend request", Status: http.StatusOK,
+			Request: structs.AnswerFriendRequest{}, Response: mongogo.FriendRequest{}},
+			handlers(h.CancelFriendRequest)},
+		{Doc{Method: http.MethodGet, Path: "/users/:id/friend_requests/incoming", Summary: "incoming friend requests", Status: http.StatusOK,
+			Response: []mongogo.FriendRequest{}},
+			handlers(h.IncomingFriendRequests)},
+		{Doc{Method: http.MethodGet, Path: "/users/:id/friend_requests/outgoing", Summary: "outgoing friend requests", Status: http.StatusOK,
+			Response: []mongogo.FriendRequest{}},
+			handlers(h.OutgoingFriendRequests)},
+		{Doc{Method: http.MethodGet, Path: "/users/:id/blocks", Summary: "blocked users", Status: http.StatusOK,
+			Response: []int{}},
+			handlers(h.BlockedUsers)},
+		{Doc{Method: http.MethodPost, Path: "/users/:id/blocks", Summary: "block user", Status: http.StatusCreated,
+			Request: structs.BlockRequest{}, Response: ""},
+			handlers(h.BlockUser)},
+		{Doc{Method: http.MethodDelete, Path: "/users/:id/blocks/:other_id", Summary: "unblock user", Status: http.StatusOK,
+			Response: ""},
+			handlers(h.UnblockUser)},
+	}
+}
+
+// adminRoutes are the routes under ADMIN_PATH, they need the admin token.
+func adminRoutes(h *Handler) []route {
+	return []route{
+		{Doc{Method: http.MethodPost, Path: ADMIN_PATH + "/users/:id/restore", Summary: "restore deleted user", Status: http.StatusOK,
+			Response: mongogo.User{}},
+			handlers(h.RestoreUser)},
+		{Doc{Method: http.MethodGet, Path: ADMIN_PATH + "/events", Summary: "user events outbox", Status: http.StatusOK,
+			Response: []mongogo.Event{}},
+			handlers(h.Events)},
+		{Doc{Method: http.MethodPost, Path: ADMIN_PATH + "/webhooks", Summary: "register webhook", Status: http.StatusCreated,
+			Request: structs.WebhookRequest{}, Response: mongogo.Webhook{}},
+			handlers(h.CreateWebhook)},
+		{Doc{Method: http.MethodGet, Path: ADMIN_PATH + "/webhooks", Summary: "list webhooks", Status: http.StatusOK,
+			Response: []mongogo.Webhook{}},
+			handlers(h.Webhooks)},
+		{Doc{Method: http.MethodDelete, Path: ADMIN_PATH + "/webhooks/:id", Summary: "delete webhook", Status: http.StatusOK,
+			Response: ""},
+			handlers(h.DeleteWebhook)},
+		{Doc{Method: http.MethodGet, Path: ADMIN_PATH + "/webhooks/:id/deliveries", Summary: "webhook delivery log", Status: http.StatusOK,
+			Response: []mongogo.Delivery{}},
+			handlers(h.WebhookDeliveries)},
+		{Doc{Method: http.MethodGet, Path: ADMIN_PATH + "/audit", Summary: "audit log of changes", Status: http.StatusOK,
+			Response: []mongogo.AuditRecord{}},
+			handlers(h.AuditLog)},
+		{Doc{Method: http.MethodGet, Path: ADMIN_PATH + "/cache", Summary: "user cache counters", Status: http.StatusOK,
+			Response: cache.Stats{}},
+			handlers(h.CacheStats)},
+	}
+}
+
+// Deprecated marks responses of an unversioned alias with the Deprecation header
+// and links the same route under version.
+func Deprecated(version string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Deprecation", "true")
+		c.Header("Link", "<"+version+c.Request.URL.Path+">; rel=\"successor-version\"")
+		c.Next()
+	}
+}
+
+// versionPath splits a registered path into the version-relative path used in
+// Docs and reports whether it is a deprecated unversioned alias.
+func versionPath(path string) (string, bool) {
+	if path == Version {
+		return "/", false
+	}
+	if strings.HasPrefix(path, Version+"/") {
+		return strings.TrimPrefix(path, Version), false
+	}
+
+	return path, true
+}
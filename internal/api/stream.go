@@ -0,0 +1,213 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"gin-server/internal/errors"
+	"gin-server/internal/events"
+	"gin-server/internal/mongogo"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// STREAM_KEEPALIVE keeps idle streams open through proxies with read timeouts.
+const STREAM_KEEPALIVE time.Duration = 15 * time.Second
+
+// UserEvents streams the friend and profile changes of a user as Server-Sent
+// Events. A client that reconnects with Last-Event-ID (or ?after=) first gets
+// the events it has missed.
+func (h *Handler) UserEvents(c *gin.Context) {
+	userId, after, ok := h.streamRequest(c, c.GetHeader("Last-Event-ID"))
+	if !ok {
+		return
+	}
+
+	// subscribe before answering, so that no change made after the client got
+	// the answer is missed
+	stream, cancel := h.hub.Subscribe(userId)
+	defer cancel()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	h.streamEvents(c, userId, after, stream, c.Request.Context().Done(), func(event mongogo.Event) error {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+
+		_, err = fmt.Fprintf(c.Writer, "id: %d\nevent: %s\ndata: %s\n\n", event.Id, event.Type, data)
+		c.Writer.Flush()
+		return err
+	}, func() error {
+		_, err := fmt.Fprint(c.Writer, ": keepalive\n\n")
+		c.Writer.Flush()
+		return err
+	})
+}
+
+// UserEventsSocket is the WebSocket variant of UserEvents, every event is sent
+// as a JSON text message.
+func (h *Handler) UserEventsSocket(c *gin.Context) {
+	userId, after, ok := h.streamRequest(c, "")
+	if !ok {
+		return
+	}
+
+	stream, cancel := h.hub.Subscribe(userId)
+	defer cancel()
+
+	upgrader := websocket.Upgrader{CheckOrigin: h.checkOrigin}
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	// the client sends nothing, reading only notices that it has gone
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	h.streamEvents(c, userId, after, stream, closed, func(event mongogo.Event) error {
+		return conn.WriteJSON(event)
+	}, func() error {
+		return conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(STREAM_KEEPALIVE))
+	})
+}
+
+// checkOrigin lets a page open a WebSocket only from the origin of the api,
+// as the client or the proxy (X-Forwarded-Host) addressed it, or from one of
+// the allowed origins. Clients other than browsers send no Origin.
+func (h *Handler) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	if forwarded := r.Header.Get("X-Forwarded-Host"); forwarded != "" &&
+		strings.EqualFold(u.Host, strings.TrimSpace(strings.Split(forwarded, ",")[0])) {
+		return true
+	}
+
+	return h.origins[strings.ToLower(origin)]
+}
+
+// streamRequest checks the user of a stream and the id of the last event the
+// client has seen, from lastEventId or ?after=. It answers the request itself
+// when the stream can not be opened.
+func (h *Handler) streamRequest(c *gin.Context, lastEventId string) (int, int, bool) {
+	if h.hub == nil {
+		c.JSON(http.StatusServiceUnavailable, HTTPerr.ErrorJSON(fmt.Errorf("event streams are not enabled")))
+		return 0, 0, false
+	}
+
+	userId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, HTTPerr.ErrorJSON(err))
+		return 0, 0, false
+	}
+
+	after, err := intQuery(c, "after", 0)
+	if err == nil && lastEventId != "" {
+		after, err = strconv.Atoi(lastEventId)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, HTTPerr.ErrorJSON(err))
+		return 0, 0, false
+	}
+
+	_, err = h.store.GetUser(userId)
+	if _, ok := err.(*errors.UndefinedIndexes); ok {
+		c.JSON(http.StatusNotFound, HTTPerr.ErrorJSON(err))
+		return 0, 0, false
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, HTTPerr.ErrorJSON(err))
+		return 0, 0, false
+	}
+
+	return userId, after, true
+}
+
+// streamEvents sends the missed events after the given id and then the ones
+// from stream, until done is closed or the client falls too far behind. The
+// missed events are read up to the cursor of the hub, which passes the later
+// ones to the stream in order.
+func (h *Handler) streamEvents(c *gin.Context, userId, after int, stream <-chan mongogo.Event, done <-chan struct{},
+	send func(mongogo.Event) error, keepalive func() error) {
+	last := after
+	if after > 0 {
+		cursor := h.hub.Cursor()
+
+	replay:
+		for {
+			missed, err := h.store.UserEvents(userId, last, events.BATCH)
+			if err != nil {
+				c.Error(err)
+				return
+			}
+
+			for _, event := range missed {
+				if event.Id > cursor {
+					break replay
+				}
+
+				last = event.Id
+				if send(event) != nil {
+					return
+				}
+			}
+
+			if len(missed) < events.BATCH {
+				break
+			}
+		}
+	}
+
+	ticker := time.NewTicker(STREAM_KEEPALIVE)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if keepalive() != nil {
+				return
+			}
+		case event, ok := <-stream:
+			if !ok {
+				return
+			}
+			if event.Id <= last {
+				continue
+			}
+			if send(event) != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"gin-server/internal/errors"
+	"gin-server/internal/mongogo"
+	"gin-server/internal/structs"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (h *Handler) GetUser(c *gin.Context) {
+	userId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, HTTPerr.ErrorJSON(err))
+		return
+	}
+
+	user, err := h.store.GetUser(userId)
+	if _, ok := err.(*errors.UndefinedIndexes); ok {
+		c.JSON(http.StatusNotFound, HTTPerr.ErrorJSON(err))
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, HTTPerr.ErrorJSON(err))
+		return
+	}
+
+	c.Header("ETag", ETag(user.Version))
+	c.Header("Cache-Control", USER_CACHE_CONTROL)
+	if notModified(c, user.Version) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"ok":       true,
+		"response": user,
+	})
+}
+
+// UpdateProfile changes the profile fields present in the body, an empty
+// string clears an optional field. With If-Match the user is changed only if
+// it still has the given version.
+func (h *Handler) UpdateProfile(c *gin.Context) {
+	userId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, HTTPerr.ErrorJSON(err))
+		return
+	}
+
+	version, err := ifMatch(c)
+	if err != nil {
+		c.JSON(http.StatusPreconditionFailed, HTTPerr.ErrorJSON(err))
+		return
+	}
+
+	rawData, err := c.GetRawData()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, HTTPerr.ErrorJSON(err))
+		return
+	}
+
+	var update mongogo.ProfileUpdate
+	err = json.Unmarshal(rawData, &update)
+	if err == nil {
+		err = ValidateUpdate(update)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, HTTPerr.ErrorJSON(err))
+		return
+	}
+
+	h.audit(c, userId)
+
+	user, err := h.store.UpdateProfile(userId, version, update)
+	if err != nil {
+		c.JSON(updateStatus(err), HTTPerr.ErrorJSON(err))
+		return
+	}
+
+	c.Header("ETag", ETag(user.Version))
+	c.JSON(http.StatusOK, gin.H{
+		"ok":       true,
+		"response": user,
+	})
+}
+
+const (
+	SEARCH_PER_PAGE     int = 20
+	SEARCH_MAX_PER_PAGE int = 100
+)
+
+// SearchUsers finds users by a case-insensitive name prefix (?q=) and an age range
+// (?min_age=, ?max_age=), paginated with ?page= and ?per_page= and sorted with
+// ?sort=name|age|id|created_at, a leading "-" sorts in descending order.
+func (h *Handler) SearchUsers(c *gin.Context) {
+	query := mongogo.SearchQuery{Text: c.Query("q")}
+
+	sort := c.DefaultQuery("sort", "name")
+	if strings.HasPrefix(sort, "-") {
+		query.Descending = true
+		sort = sort[1:]
+	}
+	for _, field := range mongogo.SEARCH_SORT {
+		if field == sort {
+			query.SortField = sort
+		}
+	}
+
+	var err error
+	if query.SortField == "" {
+		err = fmt.Errorf("unknown sort field %q", sort)
+	}
+	if err == nil {
+		query.MinAge, err = intQuery(c, "min_age", 0)
+	}
+	if err == nil {
+		query.MaxAge, err = intQuery(c, "max_age", 0)
+	}
+
+	page, perPage := 1, SEARCH_PER_PAGE
+	if err == nil {
+		page, err = intQuery(c, "page", 1)
+	}
+	if err == nil {
+		perPage, err = intQuery(c, "per_page", SEARCH_PER_PAGE)
+	}
+	if err == nil && (page < 1 || perPage < 1 || perPage > SEARCH_MAX_PER_PAGE) {
+		err = fmt.Errorf("page must be positive and per_page in range 1..%d", SEARCH_MAX_PER_PAGE)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, HTTPerr.ErrorJSON(err))
+		return
+	}
+
+	query.Skip = (page - 1) * perPage
+	query.Limit = perPage
+
+	users, total, err := h.store.SearchUsers(query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, HTTPerr.ErrorJSON(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"ok": true,
+		"response": structs.SearchResponse{
+			Users:   users,
+			Total:   total,
+			Page:    page,
+			PerPage: perPage,
+		},
+	})
+}
+
+// ValidateUpdate checks the fields a profile update sets.
+func ValidateUpdate(update mongogo.ProfileUpdate) error {
+	if update.Name != nil && *update.Name == "" {
+		return fmt.Errorf("name must not be empty")
+	}
+	if update.Age != nil && *update.Age < 0 {
+		return fmt.Errorf("age must not be negative")
+	}
+	if update.Email != nil {
+		if err := ValidateProfile(*update.Email, ""); err != nil {
+			return err
+		}
+	}
+	if update.AvatarURL != nil {
+		return ValidateProfile("", *update.AvatarURL)
+	}
+
+	return nil
+}
+
+// ValidateProfile checks the optional email and avatar url, empty values pass.
+func ValidateProfile(email, avatarURL string) error {
+	if email != "" {
+		address, err := mail.ParseAddress(email)
+		if err != nil || address.Address != email {
+			return fmt.Errorf("invalid email %q", email)
+		}
+	}
+
+	if avatarURL != "" {
+		u, err := url.ParseRequestURI(avatarURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid avatar url %q", avatarURL)
+		}
+	}
+
+	return nil
+}
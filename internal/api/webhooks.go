@@ -0,0 +1,160 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"gin-server/internal/errors"
+	"gin-server/internal/mongogo"
+	"gin-server/internal/structs"
+	"gin-server/internal/webhooks"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+var EVENT_TYPES = []string{
+	mongogo.USER_CREATED,
+	mongogo.USER_UPDATED,
+	mongogo.USER_DELETED,
+	mongogo.FRIEND_ADDED,
+	mongogo.FRIEND_REMOVED,
+}
+
+// CreateWebhook registers a URL for the events in the body, all events if the
+// list is empty. Payloads are signed with the secret. URLs of hosts that are
+// not public are refused unless Deps.WebhookNetworks allows them.
+func (h *Handler) CreateWebhook(c *gin.Context) {
+	rawData, err := c.GetRawData()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, HTTPerr.ErrorJSON(err))
+		return
+	}
+
+	var request structs.WebhookRequest
+	err = json.Unmarshal(rawData, &request)
+	if err == nil {
+		err = validateWebhook(request)
+	}
+	if err == nil {
+		err = webhooks.CheckURL(request.URL, h.webhookNetworks)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, HTTPerr.ErrorJSON(err))
+		return
+	}
+
+	hook, err := h.store.CreateWebhook(mongogo.Webhook{
+		URL:    request.URL,
+		Secret: request.Secret,
+		Events: request.Events,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, HTTPerr.ErrorJSON(err))
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"ok":       true,
+		"response": hook,
+	})
+}
+
+func (h *Handler) Webhooks(c *gin.Context) {
+	hooks, err := h.store.Webhooks()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, HTTPerr.ErrorJSON(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"ok":       true,
+		"response": hooks,
+	})
+}
+
+func (h *Handler) DeleteWebhook(c *gin.Context) {
+	webhookId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, HTTPerr.ErrorJSON(err))
+		return
+	}
+
+	err = h.store.DeleteWebhook(webhookId)
+	if _, ok := err.(*errors.UndefinedWebhook); ok {
+		c.JSON(http.StatusNotFound, HTTPerr.ErrorJSON(err))
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, HTTPerr.ErrorJSON(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"ok":       true,
+		"response": fmt.Sprintf("Webhook %d deleted", webhookId),
+	})
+}
+
+// WebhookDeliveries returns the delivery log of a webhook, newest first,
+// optionally only the deliveries in ?state=pending|delivered|dead.
+func (h *Handler) WebhookDeliveries(c *gin.Context) {
+	webhookId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, HTTPerr.ErrorJSON(err))
+		return
+	}
+
+	query := mongogo.DeliveryQuery{WebhookId: webhookId, State: c.Query("state")}
+
+	switch query.State {
+	case "", mongogo.DELIVERY_PENDING, mongogo.DELIVERY_DELIVERED, mongogo.DELIVERY_DEAD:
+	default:
+		err = fmt.Errorf("unknown delivery state %q", query.State)
+	}
+	if err == nil {
+		query.Limit, err = intQuery(c, "limit", EVENTS_LIMIT)
+	}
+	if err == nil && (query.Limit < 1 || query.Limit > EVENTS_MAX_LIMIT) {
+		err = fmt.Errorf("limit must be in range 1..%d", EVENTS_MAX_LIMIT)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, HTTPerr.ErrorJSON(err))
+		return
+	}
+
+	deliveries, err := h.store.Deliveries(query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, HTTPerr.ErrorJSON(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"ok":       true,
+		"response": deliveries,
+	})
+}
+
+func validateWebhook(request structs.WebhookRequest) error {
+	u, err := url.ParseRequestURI(request.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid webhook url %q", request.URL)
+	}
+
+	if request.Secret == "" {
+		return fmt.Errorf("secret is required")
+	}
+
+	for _, event := range request.Events {
+		known := false
+		for _, eventType := range EVENT_TYPES {
+			known = known || event == eventType
+		}
+
+		if !known {
+			return fmt.Errorf("unknown event %q", event)
+		}
+	}
+
+	return nil
+}
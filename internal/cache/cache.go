@@ -0,0 +1,282 @@
+// Package cache puts a read-through cache of users in front of a storage.Store.
+package cache
+
+import (
+	"encoding/json"
+	"gin-server/internal/errors"
+	"gin-server/internal/mongogo"
+	"gin-server/internal/storage"
+	"log"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DEFAULT_SIZE int           = 10000
+	DEFAULT_TTL  time.Duration = time.Minute
+)
+
+// Shared is a cache several instances of the server can share, such as redis
+// or memcached. Errors of it are counted and the store is asked instead.
+type Shared interface {
+	Get(key string) ([]byte, bool, error)
+	Set(key string, value []byte, ttl time.Duration) error
+	Delete(keys ...string) error
+}
+
+type Config struct {
+	// Size is the number of users kept in process, DEFAULT_SIZE when zero.
+	Size int
+	// TTL bounds how long a change made by another instance can go unseen,
+	// DEFAULT_TTL when zero.
+	TTL time.Duration
+	// Shared is asked when a user is not in the process cache, it is optional.
+	Shared Shared
+}
+
+// Stats are the counters of a Store since it was created.
+type Stats struct {
+	Hits          int64 `json:"hits"`
+	Misses        int64 `json:"misses"`
+	SharedHits    int64 `json:"shared_hits"`
+	SharedErrors  int64 `json:"shared_errors"`
+	Invalidations int64 `json:"invalidations"`
+	Evictions     int   `json:"evictions"`
+	Size          int   `json:"size"`
+}
+
+// Store caches users by id, including the ids that do not exist, and answers
+// GetUser, GetUsers and GetFriends from them. Friend lists are built from the
+// cached users, so a change of a user only has to drop that user: the methods
+// that change users drop the users they touch once the change is written, and
+// reads on this instance never see an older state afterwards.
+type Store struct {
+	storage.Store
+
+	local  *LRU
+	shared Shared
+	ttl    time.Duration
+
+	hits          int64
+	misses        int64
+	sharedHits    int64
+	sharedErrors  int64
+	invalidations int64
+}
+
+// cached is a user or the absence of it.
+type cached struct {
+	User  mongogo.User `json:"user"`
+	Found bool         `json:"found"`
+}
+
+func New(store storage.Store, config Config) *Store {
+	if config.Size <= 0 {
+		config.Size = DEFAULT_SIZE
+	}
+	if config.TTL <= 0 {
+		config.TTL = DEFAULT_TTL
+	}
+
+	return &Store{
+		Store:  store,
+		local:  NewLRU(config.Size, config.TTL),
+		shared: config.Shared,
+		ttl:    config.TTL,
+	}
+}
+
+func (s *Store) Stats() Stats {
+	return Stats{
+		Hits:          atomic.LoadInt64(&s.hits),
+		Misses:        atomic.LoadInt64(&s.misses),
+		SharedHits:    atomic.LoadInt64(&s.sharedHits),
+		SharedErrors:  atomic.LoadInt64(&s.sharedErrors),
+		Invalidations: atomic.LoadInt64(&s.invalidations),
+		Evictions:     s.local.Evictions(),
+		Size:          s.local.Len(),
+	}
+}
+
+func (s *Store) GetUser(user_id int) (mongogo.User, error) {
+	if entry, ok := s.lookup(user_id); ok {
+		if !entry.Found {
+			return mongogo.User{}, &errors.UndefinedIndexes{Indexes: []int{user_id}}
+		}
+		return copyUser(entry.User), nil
+	}
+
+	epoch := s.local.Epoch()
+	user, err := s.Store.GetUser(user_id)
+	if _, ok := err.(*errors.UndefinedIndexes); ok {
+		s.fill(user_id, cached{}, epoch)
+		return user, err
+	} else if err != nil {
+		return user, err
+	}
+
+	s.fill(user_id, cached{User: user, Found: true}, epoch)
+
+	return copyUser(user), nil
+}
+
+// GetUsers returns the existing users in the order of user_ids, the missing
+// ones are read with a single GetUsers of the store.
+func (s *Store) GetUsers(user_ids []int) ([]mongogo.User, error) {
+	users, err := s.users(user_ids)
+	if err != nil {
+		return []mongogo.User{}, err
+	}
+
+	result := []mongogo.User{}
+	seen := make(map[int]bool, len(user_ids))
+	for _, id := range user_ids {
+		if user, ok := users[id]; ok && !seen[id] {
+			seen[id] = true
+			result = append(result, copyUser(user))
+		}
+	}
+
+	return result, nil
+}
+
+// GetFriends returns the friends that exist sorted by id.
+func (s *Store) GetFriends(user_id int) ([]mongogo.User, error) {
+	user, err := s.GetUser(user_id)
+	if err != nil {
+		return []mongogo.User{}, err
+	}
+
+	friends, err := s.users(user.Friends)
+	if err != nil {
+		return []mongogo.User{}, err
+	}
+
+	var result []mongogo.User
+	for _, friend := range friends {
+		result = append(result, copyUser(friend))
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Id < result[j].Id
+	})
+
+	return result, nil
+}
+
+// users finds the existing users among user_ids.
+func (s *Store) users(user_ids []int) (map[int]mongogo.User, error) {
+	users := make(map[int]mongogo.User, len(user_ids))
+	var missing []int
+	for _, id := range user_ids {
+		entry, ok := s.lookup(id)
+		if !ok {
+			missing = append(missing, id)
+		} else if entry.Found {
+			users[id] = entry.User
+		}
+	}
+	if len(missing) == 0 {
+		return users, nil
+	}
+
+	epoch := s.local.Epoch()
+	found, err := s.Store.GetUsers(missing)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, user := range found {
+		users[user.Id] = user
+		s.fill(user.Id, cached{User: user, Found: true}, epoch)
+	}
+	for _, id := range missing {
+		if _, ok := users[id]; !ok {
+			s.fill(id, cached{}, epoch)
+		}
+	}
+
+	return users, nil
+}
+
+func (s *Store) lookup(user_id int) (cached, bool) {
+	key := userKey(user_id)
+
+	if value, ok := s.local.Get(key); ok {
+		atomic.AddInt64(&s.hits, 1)
+		return value.(cached), true
+	}
+
+	if s.shared != nil {
+		data, ok, err := s.shared.Get(key)
+		if err != nil {
+			atomic.AddInt64(&s.sharedErrors, 1)
+			log.Println("shared cache:", err)
+		}
+
+		var entry cached
+		if ok && err == nil && json.Unmarshal(data, &entry) == nil {
+			atomic.AddInt64(&s.hits, 1)
+			atomic.AddInt64(&s.sharedHits, 1)
+			s.local.Add(key, entry, s.local.Epoch())
+			return entry, true
+		}
+	}
+
+	atomic.AddInt64(&s.misses, 1)
+
+	return cached{}, false
+}
+
+// fill caches what the store answered, unless a user was dropped since epoch.
+func (s *Store) fill(user_id int, entry cached, epoch uint64) {
+	key := userKey(user_id)
+	if !s.local.Add(key, entry, epoch) || s.shared == nil {
+		return
+	}
+
+	data, err := json.Marshal(entry)
+	if err == nil {
+		err = s.shared.Set(key, data, s.ttl)
+	}
+	if err != nil {
+		atomic.AddInt64(&s.sharedErrors, 1)
+		log.Println("shared cache:", err)
+	}
+}
+
+// invalidate drops the users from the caches.
+func (s *Store) invalidate(user_ids ...int) {
+	keys := make([]string, len(user_ids))
+	for i, id := range user_ids {
+		keys[i] = userKey(id)
+	}
+
+	atomic.AddInt64(&s.invalidations, int64(len(keys)))
+	s.local.Remove(keys...)
+
+	if s.shared != nil {
+		err := s.shared.Delete(keys...)
+		if err != nil {
+			atomic.AddInt64(&s.sharedErrors, 1)
+			log.Println("shared cache:", err)
+		}
+	}
+}
+
+func userKey(user_id int) string {
+	return "user:" + strconv.Itoa(user_id)
+}
+
+func copyUser(user mongogo.User) mongogo.User {
+	if user.Friends != nil {
+		user.Friends = append([]int{}, user.Friends...)
+	}
+	if user.DeletedAt != nil {
+		deletedAt := *user.DeletedAt
+		user.DeletedAt = &deletedAt
+	}
+
+	return user
+}
@@ -0,0 +1,101 @@
+package cache
+
+import (
+	"gin-server/internal/mongogo"
+	"time"
+)
+
+// The methods below change users and drop them from the cache afterwards, also
+// when they fail, as a failure may come after the write.
+
+func (s *Store) NewUser(name string, age int) (int, error) {
+	user_id, err := s.Store.NewUser(name, age)
+	if err == nil {
+		s.invalidate(user_id)
+	}
+
+	return user_id, err
+}
+
+func (s *Store) CreateUser(user mongogo.User) (int, error) {
+	user_id, err := s.Store.CreateUser(user)
+	if err == nil {
+		s.invalidate(user_id)
+	}
+
+	return user_id, err
+}
+
+func (s *Store) UpdateProfile(user_id, version int, update mongogo.ProfileUpdate) (mongogo.User, error) {
+	defer s.invalidate(user_id)
+
+	return s.Store.UpdateProfile(user_id, version, update)
+}
+
+func (s *Store) UpdateAge(user_id, newAge, version int) (mongogo.User, error) {
+	defer s.invalidate(user_id)
+
+	return s.Store.UpdateAge(user_id, newAge, version)
+}
+
+// AddFriend changes the friend list of friend_id.
+func (s *Store) AddFriend(user_id, friend_id int) error {
+	defer s.invalidate(friend_id)
+
+	return s.Store.AddFriend(user_id, friend_id)
+}
+
+func (s *Store) DelFriend(user_id, friend_id int) error {
+	defer s.invalidate(user_id)
+
+	return s.Store.DelFriend(user_id, friend_id)
+}
+
+func (s *Store) DelUser(user_id int) (string, error) {
+	defer s.invalidate(user_id)
+
+	return s.Store.DelUser(user_id)
+}
+
+func (s *Store) RestoreUser(user_id int) (mongogo.User, error) {
+	defer s.invalidate(user_id)
+
+	return s.Store.RestoreUser(user_id)
+}
+
+// PurgeUsers changes the friend lists of unknown users, the whole cache of
+// this instance is dropped. Users in the shared cache expire with the TTL.
+func (s *Store) PurgeUsers(before time.Time) (int, error) {
+	purged, err := s.Store.PurgeUsers(before)
+	if purged > 0 || err != nil {
+		s.local.Clear()
+	}
+
+	return purged, err
+}
+
+func (s *Store) InsertUsers(users []mongogo.User) []error {
+	ids := make([]int, len(users))
+	for i, user := range users {
+		ids[i] = user.Id
+	}
+	defer s.invalidate(ids...)
+
+	return s.Store.InsertUsers(users)
+}
+
+func (s *Store) SendFriendRequest(source_id, target_id int) (mongogo.FriendRequest, error) {
+	defer s.invalidate(source_id, target_id)
+
+	return s.Store.SendFriendRequest(source_id, target_id)
+}
+
+// AnswerFriendRequest makes the users friends when the request is accepted.
+func (s *Store) AnswerFriendRequest(request_id, user_id int, state string) (mongogo.FriendRequest, error) {
+	request, err := s.Store.AnswerFriendRequest(request_id, user_id, state)
+	if err == nil {
+		s.invalidate(request.SourceId, request.TargetId)
+	}
+
+	return request, err
+}
@@ -0,0 +1,145 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// LRU keeps at most size values for ttl each, the least recently used value is
+// evicted first. It is safe for concurrent use.
+type LRU struct {
+	size  int
+	ttl   time.Duration
+	now   func() time.Time
+	mu    sync.Mutex
+	order *list.List
+	items map[string]*list.Element
+	// epoch grows with every removal, a value read from the store before a
+	// removal may be stale and is not added.
+	epoch     uint64
+	evictions int
+}
+
+type entry struct {
+	key     string
+	value   interface{}
+	expires time.Time
+}
+
+func NewLRU(size int, ttl time.Duration) *LRU {
+	return &LRU{
+		size:  size,
+		ttl:   ttl,
+		now:   time.Now,
+		order: list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+// Get returns the value of key unless it is missing or expired.
+func (l *LRU) Get(key string) (interface{}, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	element, ok := l.items[key]
+	if !ok {
+		return nil, false
+	}
+
+	e := element.Value.(*entry)
+	if l.ttl > 0 && !l.now().Before(e.expires) {
+		l.order.Remove(element)
+		delete(l.items, key)
+		return nil, false
+	}
+
+	l.order.MoveToFront(element)
+
+	return e.value, true
+}
+
+// Epoch is passed to Add with a value read after it.
+func (l *LRU) Epoch() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.epoch
+}
+
+// Add stores the value unless something was removed since epoch, and reports
+// whether it did.
+func (l *LRU) Add(key string, value interface{}, epoch uint64) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if epoch != l.epoch {
+		return false
+	}
+
+	e := &entry{key: key, value: value, expires: l.now().Add(l.ttl)}
+	if element, ok := l.items[key]; ok {
+		element.Value = e
+		l.order.MoveToFront(element)
+		return true
+	}
+
+	l.items[key] = l.order.PushFront(e)
+	for l.order.Len() > l.size {
+		oldest := l.order.Back()
+		l.order.Remove(oldest)
+		delete(l.items, oldest.Value.(*entry).key)
+		l.evictions++
+	}
+
+	return true
+}
+
+func (l *LRU) Remove(keys ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.epoch++
+	for _, key := range keys {
+		if element, ok := l.items[key]; ok {
+			l.order.Remove(element)
+			delete(l.items, key)
+		}
+	}
+}
+
+func (l *LRU) Clear() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.epoch++
+	l.order.Init()
+	l.items = make(map[string]*list.Element)
+}
+
+func (l *LRU) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.order.Len()
+}
+
+func (l *LRU) Evictions() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.evictions
+}
+
+// Keys returns the keys from the most to the least recently used.
+func (l *LRU) Keys() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	keys := make([]string, 0, l.order.Len())
+	for element := l.order.Front(); element != nil; element = element.Next() {
+		keys = append(keys, element.Value.(*entry).key)
+	}
+
+	return keys
+}
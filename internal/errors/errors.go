@@ -3,12 +3,13 @@ package errors
 import (
 	"fmt"
 	"net/http"
+	"reflect"
 
 	"github.com/gin-gonic/gin"
 )
 
 type UndefinedIndexes struct {
-	Indexes []int
+	Indexes []int `json:"indexes"`
 }
 
 func (ui *UndefinedIndexes) Error() string {
@@ -16,7 +17,7 @@ func (ui *UndefinedIndexes) Error() string {
 }
 
 type InternarMongoError struct {
-	Err error
+	Err error `json:"-"`
 }
 
 func (ime *InternarMongoError) Error() string {
@@ -24,8 +25,8 @@ func (ime *InternarMongoError) Error() string {
 }
 
 type FriendsExists struct {
-	SourceId int
-	TargetId int
+	SourceId int `json:"source_id"`
+	TargetId int `json:"target_id"`
 }
 
 func (fe *FriendsExists) Error() string {
@@ -38,9 +39,123 @@ func (he *HTTPErrors) InternalError(w http.ResponseWriter, message interface{})
 	w.WriteHeader(http.StatusInternalServerError)
 	w.Write([]byte(fmt.Sprintf("%v", message)))
 }
+
+// ErrorJSON is the answer of a failed request. Errors of this package are also
+// described in "error" by their type name and fields, so clients can tell them
+// apart without parsing the message.
 func (he *HTTPErrors) ErrorJSON(err error) gin.H {
-	return gin.H{
+	answer := gin.H{
 		"ok":       false,
 		"response": err.Error(),
 	}
+
+	if t := reflect.TypeOf(err); t.Kind() == reflect.Ptr && t.Elem().PkgPath() == reflect.TypeOf(he).Elem().PkgPath() {
+		answer["error"] = gin.H{"type": t.Elem().Name(), "details": err}
+	}
+
+	return answer
+}
+
+type UserExists struct {
+	Id int `json:"id"`
+}
+
+func (ue *UserExists) Error() string {
+	return fmt.Sprintf("User %d already exists", ue.Id)
+}
+
+type PathNotFound struct {
+	SourceId int `json:"source_id"`
+	TargetId int `json:"target_id"`
+	MaxDepth int `json:"max_depth"`
+}
+
+func (pnf *PathNotFound) Error() string {
+	return fmt.Sprintf("User %d is not connected to %d within %d hops", pnf.TargetId, pnf.SourceId, pnf.MaxDepth)
+}
+
+type UndefinedRequest struct {
+	Id int `json:"id"`
+}
+
+func (ur *UndefinedRequest) Error() string {
+	return fmt.Sprintf("Undefined friend request: %d", ur.Id)
+}
+
+type RequestExists struct {
+	SourceId int `json:"source_id"`
+	TargetId int `json:"target_id"`
+}
+
+func (re *RequestExists) Error() string {
+	return fmt.Sprintf("User %d already has a pending friend request to %d", re.SourceId, re.TargetId)
+}
+
+type RequestState struct {
+	Id    int    `json:"id"`
+	State string `json:"state"`
+}
+
+func (rs *RequestState) Error() string {
+	return fmt.Sprintf("Friend request %d is %s", rs.Id, rs.State)
+}
+
+type UserBlocked struct {
+	SourceId int `json:"source_id"`
+	TargetId int `json:"target_id"`
+}
+
+func (ub *UserBlocked) Error() string {
+	return fmt.Sprintf("User %d can not send friend requests to %d", ub.SourceId, ub.TargetId)
+}
+
+type Forbidden struct {
+	UserId int    `json:"user_id"`
+	Action string `json:"action"`
+}
+
+func (f *Forbidden) Error() string {
+	return fmt.Sprintf("User %d is not allowed to %s", f.UserId, f.Action)
+}
+
+type EmailExists struct {
+	Email string `json:"email"`
+}
+
+func (ee *EmailExists) Error() string {
+	return fmt.Sprintf("Email %s is already taken", ee.Email)
+}
+
+type VersionMismatch struct {
+	Id       int `json:"id"`
+	Expected int `json:"expected"`
+	Actual   int `json:"actual"`
+}
+
+func (vm *VersionMismatch) Error() string {
+	return fmt.Sprintf("User %d has version %d, not %d", vm.Id, vm.Actual, vm.Expected)
+}
+
+type KeyInProgress struct {
+	Key string `json:"key"`
+}
+
+func (kp *KeyInProgress) Error() string {
+	return fmt.Sprintf("Request with idempotency key %s is still in progress", kp.Key)
+}
+
+type KeyConflict struct {
+	Key string `json:"key"`
+}
+
+func (kc *KeyConflict) Error() string {
+	return fmt.Sprintf("Idempotency key %s was used with a different request", kc.Key)
+}
+
+type UndefinedWebhook struct {
+	Id int `json:"id"`
+}
+
+func (uw *UndefinedWebhook) Error() string {
+	return fmt.Sprintf("Webhook %d does not exist", uw.Id)
 }
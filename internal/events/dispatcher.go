@@ -0,0 +1,144 @@
+package events
+
+import (
+	"gin-server/internal/mongogo"
+	"gin-server/internal/storage"
+	"log"
+	"sync"
+	"time"
+)
+
+const BATCH int = 100
+
+// GAP_SETTLE is how long a reader waits for a missing id to commit before it
+// moves past it. Ids are taken before the write, so the event of a lower id
+// may still be on its way; one that has not come by then was never written.
+const GAP_SETTLE time.Duration = 5 * time.Second
+
+// Handler receives an event. An error stops delivery to the subscriber, the
+// event is delivered again on the next pass.
+type Handler func(event mongogo.Event) error
+
+// Dispatcher delivers outbox events to named subscribers at least once and in
+// id order. The position of a subscriber is saved after every delivered event, so
+// after a restart it continues where it stopped and an event may be delivered
+// twice. Replay moves the position back.
+type Dispatcher struct {
+	store storage.EventStore
+
+	mu          sync.Mutex
+	subscribers map[string]Handler
+	names       []string
+}
+
+func NewDispatcher(store storage.EventStore) *Dispatcher {
+	return &Dispatcher{
+		store:       store,
+		subscribers: make(map[string]Handler),
+	}
+}
+
+// Subscribe registers a handler under a name, which identifies its position
+// in the outbox across restarts.
+func (d *Dispatcher) Subscribe(name string, handler Handler) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.subscribers[name]; !ok {
+		d.names = append(d.names, name)
+	}
+	d.subscribers[name] = handler
+}
+
+// Replay makes the subscriber receive again all events after the given id.
+func (d *Dispatcher) Replay(name string, after int) error {
+	return d.store.SaveEventCursor(name, after)
+}
+
+// Dispatch delivers the pending events to every subscriber and returns the
+// first error.
+func (d *Dispatcher) Dispatch() error {
+	d.mu.Lock()
+	names := append([]string(nil), d.names...)
+	handlers := make([]Handler, len(names))
+	for i, name := range names {
+		handlers[i] = d.subscribers[name]
+	}
+	d.mu.Unlock()
+
+	var result error
+	for i, name := range names {
+		err := d.deliver(name, handlers[i])
+		if err != nil && result == nil {
+			result = err
+		}
+	}
+
+	return result
+}
+
+func (d *Dispatcher) deliver(name string, handler Handler) error {
+	cursor, err := d.store.EventCursor(name)
+	if err != nil {
+		return err
+	}
+
+	for {
+		events, err := d.store.Events(cursor, BATCH)
+		if err != nil {
+			return err
+		}
+
+		ready := Settled(cursor, events, time.Now())
+		for _, event := range ready {
+			err := handler(event)
+			if err != nil {
+				return err
+			}
+
+			cursor = event.Id
+			err = d.store.SaveEventCursor(name, cursor)
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(ready) < len(events) || len(events) < BATCH {
+			return nil
+		}
+	}
+}
+
+// Settled returns the events, read in id order after cursor, that a reader can
+// take now: it stops at a missing id until the event after it is GAP_SETTLE
+// old.
+func Settled(cursor int, events []mongogo.Event, now time.Time) []mongogo.Event {
+	for i, event := range events {
+		if event.Id != cursor+1 && now.Sub(event.CreatedAt) < GAP_SETTLE {
+			return events[:i]
+		}
+
+		cursor = event.Id
+	}
+
+	return events
+}
+
+// Run dispatches events once per interval until stop is closed.
+func (d *Dispatcher) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		err := d.Dispatch()
+		if err != nil {
+			log.Println("events:", err)
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
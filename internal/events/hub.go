@@ -0,0 +1,154 @@
+package events
+
+import (
+	"gin-server/internal/mongogo"
+	"gin-server/internal/storage"
+	"log"
+	"sync"
+	"time"
+)
+
+// HUB_BUFFER is how many events a stream may fall behind before it is closed.
+const HUB_BUFFER int = 64
+
+// Hub polls the outbox and passes new events of a user to the streams opened
+// for it on this server. Every server has its own hub, they all read the same
+// outbox, so a stream sees the changes made through any of them.
+type Hub struct {
+	store storage.EventStore
+
+	// polling keeps polls apart, mu guards the fields below it and is not held
+	// while the outbox is read
+	polling sync.Mutex
+	mu      sync.Mutex
+	cursor  int
+	started bool
+	streams map[int]map[chan mongogo.Event]bool
+}
+
+func NewHub(store storage.EventStore) *Hub {
+	return &Hub{
+		store:   store,
+		streams: make(map[int]map[chan mongogo.Event]bool),
+	}
+}
+
+// Subscribe opens a stream of the events of a user. The channel is closed when
+// the stream falls behind by more than HUB_BUFFER events, the returned function
+// closes the stream.
+func (h *Hub) Subscribe(user_id int) (<-chan mongogo.Event, func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	stream := make(chan mongogo.Event, HUB_BUFFER)
+	if h.streams[user_id] == nil {
+		h.streams[user_id] = make(map[chan mongogo.Event]bool)
+	}
+	h.streams[user_id][stream] = true
+
+	return stream, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+
+		h.close(user_id, stream)
+	}
+}
+
+// Poll passes the events written since the last poll to the streams. The first
+// poll only finds the end of the outbox.
+func (h *Hub) Poll() error {
+	h.polling.Lock()
+	defer h.polling.Unlock()
+
+	h.mu.Lock()
+	cursor, started := h.cursor, h.started
+	h.mu.Unlock()
+
+	if !started {
+		last, err := h.store.LastEventId()
+		if err != nil {
+			return err
+		}
+
+		h.mu.Lock()
+		h.cursor = last
+		h.started = true
+		h.mu.Unlock()
+
+		return nil
+	}
+
+	for {
+		events, err := h.store.Events(cursor, BATCH)
+		if err != nil {
+			return err
+		}
+
+		ready := Settled(cursor, events, time.Now())
+		if len(ready) > 0 {
+			cursor = ready[len(ready)-1].Id
+			h.fanOut(ready)
+		}
+
+		if len(ready) < len(events) || len(events) < BATCH {
+			return nil
+		}
+	}
+}
+
+func (h *Hub) fanOut(events []mongogo.Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, event := range events {
+		h.cursor = event.Id
+
+		for stream := range h.streams[event.UserId] {
+			select {
+			case stream <- event:
+			default:
+				h.close(event.UserId, stream)
+			}
+		}
+	}
+}
+
+// Cursor returns the id of the last event passed to the streams. Streams get
+// every later event, the earlier ones are read from the outbox.
+func (h *Hub) Cursor() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.cursor
+}
+
+// Run polls once per interval until stop is closed.
+func (h *Hub) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		err := h.Poll()
+		if err != nil {
+			log.Println("hub:", err)
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (h *Hub) close(user_id int, stream chan mongogo.Event) {
+	if !h.streams[user_id][stream] {
+		return
+	}
+
+	delete(h.streams[user_id], stream)
+	if len(h.streams[user_id]) == 0 {
+		delete(h.streams, user_id)
+	}
+	close(stream)
+}
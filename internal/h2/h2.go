@@ -0,0 +1,99 @@
+// Package h2 lets the server and the proxy speak HTTP/2: over TLS, where it is
+// negotiated with ALPN, and as cleartext h2c between internal hops.
+package h2
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+const (
+	// MAX_IDLE_PER_HOST is the number of idle HTTP/1 connections kept to each
+	// host, for requests that cannot be multiplexed.
+	MAX_IDLE_PER_HOST int = 16
+	// PING_INTERVAL is how long a multiplexed connection may stay silent before
+	// it is pinged, a dead connection would fail every request sharing it.
+	PING_INTERVAL time.Duration = 30 * time.Second
+	DIAL_TIMEOUT  time.Duration = 10 * time.Second
+)
+
+// Handler accepts cleartext HTTP/2, with prior knowledge or an h2c upgrade,
+// next to HTTP/1 which is passed to h unchanged.
+func Handler(h http.Handler) http.Handler {
+	return h2c.NewHandler(h, &http2.Server{})
+}
+
+// ConfigureServer offers HTTP/2 to the TLS clients of s.
+func ConfigureServer(s *http.Server) error {
+	return http2.ConfigureServer(s, &http2.Server{})
+}
+
+// Transport sends requests to backends over as few connections as possible:
+// https backends are asked for HTTP/2 and http backends are spoken to with
+// h2c when it is enabled. Upgrade requests such as WebSockets cannot be
+// multiplexed and go over HTTP/1.
+type Transport struct {
+	http1 *http.Transport
+	h2c   *http2.Transport
+}
+
+// NewTransport builds the transport, config verifies https backends and may
+// carry a client certificate, it can be nil.
+func NewTransport(config *tls.Config, cleartext bool) (*Transport, error) {
+	http1 := http.DefaultTransport.(*http.Transport).Clone()
+	http1.TLSClientConfig = config
+	http1.MaxIdleConnsPerHost = MAX_IDLE_PER_HOST
+
+	h2, err := http2.ConfigureTransports(http1)
+	if err != nil {
+		return nil, err
+	}
+	h2.ReadIdleTimeout = PING_INTERVAL
+
+	t := &Transport{http1: http1}
+	if cleartext {
+		dialer := &net.Dialer{Timeout: DIAL_TIMEOUT}
+		t.h2c = &http2.Transport{
+			AllowHTTP:       true,
+			ReadIdleTimeout: PING_INTERVAL,
+			DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
+				return dialer.Dial(network, addr)
+			},
+		}
+	}
+
+	return t, nil
+}
+
+func (t *Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if t.h2c != nil && r.URL.Scheme == "http" && !upgrade(r) {
+		return t.h2c.RoundTrip(r)
+	}
+
+	return t.http1.RoundTrip(r)
+}
+
+func (t *Transport) CloseIdleConnections() {
+	t.http1.CloseIdleConnections()
+	if t.h2c != nil {
+		t.h2c.CloseIdleConnections()
+	}
+}
+
+func upgrade(r *http.Request) bool {
+	for _, value := range r.Header.Values("Connection") {
+		for _, token := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
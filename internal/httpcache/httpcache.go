@@ -0,0 +1,571 @@
+// Package httpcache keeps GET answers of a handler in memory as their
+// Cache-Control and ETag headers allow, the proxy puts it behind its routing.
+package httpcache
+
+import (
+	"bytes"
+	"gin-server/internal/cache"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DEFAULT_SIZE     int = 1000
+	DEFAULT_MAX_BODY int = 1 << 20
+)
+
+// X-Cache tells how an answer was served.
+const (
+	HIT         string = "HIT"
+	MISS        string = "MISS"
+	REVALIDATED string = "REVALIDATED"
+	BYPASS      string = "BYPASS"
+)
+
+type Config struct {
+	// Size is the number of answers kept, DEFAULT_SIZE when zero.
+	Size int
+	// MaxBody is the largest body kept in bytes, DEFAULT_MAX_BODY when zero.
+	MaxBody int
+	// Variant tells apart the answers to the same URL, such as the ones of
+	// different backend pools. Requests with different variants never share
+	// an answer.
+	Variant func(r *http.Request) string
+}
+
+type Stats struct {
+	Entries     int   `json:"entries"`
+	Hits        int64 `json:"hits"`
+	Misses      int64 `json:"misses"`
+	Revalidated int64 `json:"revalidated"`
+	Coalesced   int64 `json:"coalesced"`
+	Bypassed    int64 `json:"bypassed"`
+}
+
+// Cache is an http.Handler that answers GET requests from stored answers of
+// next while they are fresh and revalidates them with If-None-Match once they
+// are stale. Concurrent requests for the same URL wait for a single request to
+// next. Other methods pass through and drop the answers stored for their path
+// and for the other paths of the ids in it.
+type Cache struct {
+	next    http.Handler
+	entries *cache.LRU
+	maxBody int
+	variant func(r *http.Request) string
+	now     func() time.Time
+
+	mu      sync.Mutex
+	flights map[string]*flight
+
+	hits        int64
+	misses      int64
+	revalidated int64
+	coalesced   int64
+	bypassed    int64
+}
+
+// entry is a stored answer, fresh until stored+fresh.
+type entry struct {
+	status int
+	header http.Header
+	body   []byte
+	etag   string
+	stored time.Time
+	fresh  time.Duration
+}
+
+// flight is the request to next that the concurrent requests for a URL wait for.
+type flight struct {
+	// decided is closed once the status and headers tell whether the answer
+	// will be stored, until then the others wait.
+	decided chan struct{}
+	done    chan struct{}
+	storing bool
+	entry   *entry
+}
+
+func New(next http.Handler, config Config) *Cache {
+	if config.Size <= 0 {
+		config.Size = DEFAULT_SIZE
+	}
+	if config.MaxBody <= 0 {
+		config.MaxBody = DEFAULT_MAX_BODY
+	}
+
+	return &Cache{
+		next:    next,
+		entries: cache.NewLRU(config.Size, 0),
+		maxBody: config.MaxBody,
+		variant: config.Variant,
+		now:     time.Now,
+		flights: make(map[string]*flight),
+	}
+}
+
+func (c *Cache) Stats() Stats {
+	return Stats{
+		Entries:     c.entries.Len(),
+		Hits:        atomic.LoadInt64(&c.hits),
+		Misses:      atomic.LoadInt64(&c.misses),
+		Revalidated: atomic.LoadInt64(&c.revalidated),
+		Coalesced:   atomic.LoadInt64(&c.coalesced),
+		Bypassed:    atomic.LoadInt64(&c.bypassed),
+	}
+}
+
+// Purge drops the answers of the URLs starting with prefix and returns how
+// many there were.
+func (c *Cache) Purge(prefix string) int {
+	var keys []string
+	for _, key := range c.entries.Keys() {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+
+	c.entries.Remove(keys...)
+
+	return len(keys)
+}
+
+func (c *Cache) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		c.pass(w, r)
+		return
+	}
+	if !cacheableRequest(r) {
+		atomic.AddInt64(&c.bypassed, 1)
+		w.Header().Set("X-Cache", BYPASS)
+		c.next.ServeHTTP(w, r)
+		return
+	}
+
+	key := c.key(r)
+	var stored *entry
+	if value, ok := c.entries.Get(key); ok {
+		stored = value.(*entry)
+	}
+
+	if stored != nil && stored.isFresh(c.now()) && !revalidate(r) {
+		atomic.AddInt64(&c.hits, 1)
+		c.serve(w, r, stored, HIT)
+		return
+	}
+
+	c.mu.Lock()
+	f, waiting := c.flights[key]
+	if !waiting {
+		f = &flight{decided: make(chan struct{}), done: make(chan struct{})}
+		c.flights[key] = f
+	}
+	c.mu.Unlock()
+
+	if waiting {
+		<-f.decided
+		if f.storing {
+			<-f.done
+		}
+		if f.entry != nil {
+			atomic.AddInt64(&c.coalesced, 1)
+			c.serve(w, r, f.entry, HIT)
+			return
+		}
+
+		// the answer can not be shared, ask next on our own
+		atomic.AddInt64(&c.misses, 1)
+		w.Header().Set("X-Cache", MISS)
+		c.next.ServeHTTP(w, r)
+		return
+	}
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.flights, key)
+		c.mu.Unlock()
+
+		f.decide(false)
+		close(f.done)
+	}()
+
+	c.fetch(w, r, key, stored, f)
+}
+
+// fetch asks next for the answer of key, conditionally if there is a stale
+// answer with an ETag, and stores it if its headers allow.
+func (c *Cache) fetch(w http.ResponseWriter, r *http.Request, key string, stale *entry, f *flight) {
+	epoch := c.entries.Epoch()
+
+	req := r.Clone(r.Context())
+	req.Header.Del("If-None-Match")
+	req.Header.Del("If-Modified-Since")
+	if stale != nil && stale.etag != "" {
+		req.Header.Set("If-None-Match", stale.etag)
+	} else {
+		stale = nil
+	}
+
+	cw := &captureWriter{
+		w:            w,
+		header:       http.Header{},
+		maxBody:      c.maxBody,
+		revalidating: stale != nil,
+		flight:       f,
+	}
+	c.next.ServeHTTP(cw, req)
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+
+	switch cw.mode {
+	case notModified:
+		e := stale.refresh(cw.header, c.now())
+		c.entries.Add(key, e, epoch)
+		f.entry = e
+
+		atomic.AddInt64(&c.revalidated, 1)
+		c.serve(w, r, e, REVALIDATED)
+	case buffering:
+		e := &entry{
+			status: cw.status,
+			header: cw.header,
+			body:   cw.body.Bytes(),
+			etag:   cw.header.Get("ETag"),
+			stored: c.now(),
+			fresh:  freshness(cw.header),
+		}
+		c.entries.Add(key, e, epoch)
+		f.entry = e
+
+		atomic.AddInt64(&c.misses, 1)
+		c.serve(w, r, e, MISS)
+	default:
+		atomic.AddInt64(&c.misses, 1)
+	}
+}
+
+// key is the request URI of r followed by its variant.
+func (c *Cache) key(r *http.Request) string {
+	if c.variant == nil {
+		return r.URL.RequestURI()
+	}
+
+	return r.URL.RequestURI() + " " + c.variant(r)
+}
+
+// pass forwards a request that is not a GET and, when it succeeds, drops the
+// answers of its path and of every path with one of its ids, as it probably
+// changed what they hold: PUT /v1/5 drops /v1/users/5 and /v1/friends/5.
+func (c *Cache) pass(w http.ResponseWriter, r *http.Request) {
+	sw := &statusWriter{ResponseWriter: w}
+	c.next.ServeHTTP(sw, r)
+
+	if sw.status >= http.StatusBadRequest || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+		return
+	}
+
+	changed := ids(r.URL.Path)
+
+	var keys []string
+	for _, key := range c.entries.Keys() {
+		path := key
+		if i := strings.IndexAny(path, "? "); i >= 0 {
+			path = path[:i]
+		}
+
+		if path == r.URL.Path || related(ids(path), changed) {
+			keys = append(keys, key)
+		}
+	}
+
+	c.entries.Remove(keys...)
+}
+
+// ids returns the numeric segments of a path.
+func ids(path string) []string {
+	var result []string
+	for _, segment := range strings.Split(path, "/") {
+		if segment != "" && strings.Trim(segment, "0123456789") == "" {
+			result = append(result, segment)
+		}
+	}
+
+	return result
+}
+
+func related(ids, changed []string) bool {
+	for _, id := range ids {
+		for _, other := range changed {
+			if id == other {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// serve answers from an entry, with 304 if the client already has it.
+func (c *Cache) serve(w http.ResponseWriter, r *http.Request, e *entry, state string) {
+	header := w.Header()
+	for name, values := range e.header {
+		header[name] = append([]string(nil), values...)
+	}
+	header.Set("X-Cache", state)
+	header.Set("Age", strconv.Itoa(int(c.now().Sub(e.stored).Seconds())))
+
+	if e.etag != "" && matches(r.Header.Get("If-None-Match"), e.etag) {
+		header.Del("Content-Length")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.WriteHeader(e.status)
+	w.Write(e.body)
+}
+
+func (e *entry) isFresh(now time.Time) bool {
+	return now.Sub(e.stored) < e.fresh
+}
+
+// refresh is the entry after next answered 304 with the given headers.
+func (e *entry) refresh(header http.Header, now time.Time) *entry {
+	refreshed := *e
+	refreshed.header = e.header.Clone()
+	for _, name := range []string{"Cache-Control", "Date", "Expires", "ETag"} {
+		if value := header.Get(name); value != "" {
+			refreshed.header.Set(name, value)
+		}
+	}
+
+	refreshed.etag = refreshed.header.Get("ETag")
+	refreshed.stored = now
+	refreshed.fresh = freshness(refreshed.header)
+
+	return &refreshed
+}
+
+func (f *flight) decide(storing bool) {
+	select {
+	case <-f.decided:
+	default:
+		f.storing = storing
+		close(f.decided)
+	}
+}
+
+// cacheableRequest reports whether a GET may be answered from the cache:
+// requests with credentials, upgrades, ranges, event streams and no-store go
+// straight to next.
+func cacheableRequest(r *http.Request) bool {
+	if r.Header.Get("Authorization") != "" || r.Header.Get("Upgrade") != "" || r.Header.Get("Range") != "" {
+		return false
+	}
+	if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		return false
+	}
+
+	_, noStore := directives(r.Header)["no-store"]
+
+	return !noStore
+}
+
+// revalidate reports whether the client asks not to get a stored answer
+// without asking next.
+func revalidate(r *http.Request) bool {
+	d := directives(r.Header)
+	_, noCache := d["no-cache"]
+
+	return noCache || d["max-age"] == "0" || r.Header.Get("Pragma") == "no-cache"
+}
+
+// storable reports whether an answer may be kept: a 200 that is neither
+// private nor no-store and carries an ETag or a lifetime.
+func storable(status int, header http.Header) bool {
+	if status != http.StatusOK || header.Get("Vary") != "" || header.Get("Set-Cookie") != "" {
+		return false
+	}
+	if strings.HasPrefix(header.Get("Content-Type"), "text/event-stream") {
+		return false
+	}
+
+	d := directives(header)
+	if _, ok := d["no-store"]; ok {
+		return false
+	}
+	if _, ok := d["private"]; ok {
+		return false
+	}
+
+	return header.Get("ETag") != "" || freshness(header) > 0
+}
+
+// freshness is how long an answer is fresh, zero when it must be revalidated.
+func freshness(header http.Header) time.Duration {
+	d := directives(header)
+	if _, ok := d["no-cache"]; ok {
+		return 0
+	}
+
+	for _, name := range []string{"s-maxage", "max-age"} {
+		if value, ok := d[name]; ok {
+			seconds, err := strconv.Atoi(value)
+			if err != nil || seconds < 0 {
+				return 0
+			}
+			return time.Duration(seconds) * time.Second
+		}
+	}
+
+	return 0
+}
+
+// directives parses the Cache-Control header into names and values.
+func directives(header http.Header) map[string]string {
+	result := make(map[string]string)
+	for _, line := range header.Values("Cache-Control") {
+		for _, directive := range strings.Split(line, ",") {
+			name, value := strings.TrimSpace(directive), ""
+			if i := strings.IndexByte(name, '='); i >= 0 {
+				name, value = name[:i], strings.Trim(name[i+1:], `"`)
+			}
+			if name != "" {
+				result[strings.ToLower(name)] = value
+			}
+		}
+	}
+
+	return result
+}
+
+// matches compares the If-None-Match header with an entity tag, weakly.
+func matches(ifNoneMatch, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+const (
+	deciding int = iota
+	buffering
+	notModified
+	passing
+)
+
+// captureWriter buffers the answer of next while it may be stored and passes
+// it on to the client as soon as it is clear that it will not be.
+type captureWriter struct {
+	w            http.ResponseWriter
+	header       http.Header
+	status       int
+	wroteHeader  bool
+	body         bytes.Buffer
+	maxBody      int
+	revalidating bool
+	mode         int
+	flight       *flight
+}
+
+func (cw *captureWriter) Header() http.Header {
+	return cw.header
+}
+
+func (cw *captureWriter) WriteHeader(status int) {
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+	cw.status = status
+
+	switch {
+	case cw.revalidating && status == http.StatusNotModified:
+		cw.mode = notModified
+		cw.flight.decide(true)
+	case storable(status, cw.header):
+		cw.mode = buffering
+		cw.flight.decide(true)
+	default:
+		cw.flight.decide(false)
+		cw.pass()
+	}
+}
+
+func (cw *captureWriter) Write(p []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+
+	switch cw.mode {
+	case notModified:
+		return len(p), nil
+	case buffering:
+		if cw.body.Len()+len(p) <= cw.maxBody {
+			return cw.body.Write(p)
+		}
+
+		// too large to keep, the waiting requests ask next themselves
+		cw.pass()
+		_, err := cw.w.Write(cw.body.Bytes())
+		if err != nil {
+			return 0, err
+		}
+		cw.body.Reset()
+	}
+
+	return cw.w.Write(p)
+}
+
+// pass sends the status and headers to the client, the body follows as it comes.
+func (cw *captureWriter) pass() {
+	cw.mode = passing
+
+	header := cw.w.Header()
+	for name, values := range cw.header {
+		header[name] = values
+	}
+	header.Set("X-Cache", MISS)
+
+	cw.w.WriteHeader(cw.status)
+}
+
+// Flush lets event streams through when they are passed on.
+func (cw *captureWriter) Flush() {
+	if flusher, ok := cw.w.(http.Flusher); ok && cw.mode == passing {
+		flusher.Flush()
+	}
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sw *statusWriter) WriteHeader(status int) {
+	if sw.status == 0 {
+		sw.status = status
+	}
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *statusWriter) Write(p []byte) (int, error) {
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+
+	return sw.ResponseWriter.Write(p)
+}
+
+func (sw *statusWriter) Flush() {
+	if flusher, ok := sw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
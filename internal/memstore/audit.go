@@ -0,0 +1,38 @@
+package memstore
+
+import (
+	"gin-server/internal/mongogo"
+	"time"
+)
+
+func (s *Store) AppendAudit(record mongogo.AuditRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record.Id = len(s.audit) + 1
+	if record.CreatedAt.IsZero() {
+		record.CreatedAt = time.Now().UTC()
+	}
+	record.CreatedAt = record.CreatedAt.Truncate(time.Millisecond)
+
+	s.audit = append(s.audit, record)
+
+	return nil
+}
+
+func (s *Store) AuditLog(query mongogo.AuditQuery) ([]mongogo.AuditRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := []mongogo.AuditRecord{}
+	for i := len(s.audit) - 1; i >= 0; i-- {
+		if query.Limit > 0 && len(result) == query.Limit {
+			break
+		}
+		if query.Matches(s.audit[i]) {
+			result = append(result, s.audit[i])
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,83 @@
+package memstore
+
+import (
+	"gin-server/internal/mongogo"
+	"time"
+)
+
+func (s *Store) Events(after, limit int) ([]mongogo.Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := []mongogo.Event{}
+	for _, event := range s.events {
+		if len(result) == limit {
+			break
+		}
+		if event.Id > after {
+			result = append(result, copyEvent(event))
+		}
+	}
+
+	return result, nil
+}
+
+func (s *Store) UserEvents(user_id, after, limit int) ([]mongogo.Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := []mongogo.Event{}
+	for _, event := range s.events {
+		if len(result) == limit {
+			break
+		}
+		if event.UserId == user_id && event.Id > after {
+			result = append(result, copyEvent(event))
+		}
+	}
+
+	return result, nil
+}
+
+func (s *Store) LastEventId() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.events), nil
+}
+
+func (s *Store) EventCursor(name string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.cursors[name], nil
+}
+
+func (s *Store) SaveEventCursor(name string, event_id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.cursors[name] = event_id
+
+	return nil
+}
+
+// publish appends events to the outbox, the caller holds the lock.
+func (s *Store) publish(events ...mongogo.Event) {
+	now := time.Now().UTC().Truncate(time.Millisecond)
+
+	for _, event := range events {
+		event.Id = len(s.events) + 1
+		event.CreatedAt = now
+		s.events = append(s.events, copyEvent(event))
+	}
+}
+
+func copyEvent(event mongogo.Event) mongogo.Event {
+	if event.User != nil {
+		user := copyUser(*event.User)
+		event.User = &user
+	}
+
+	return event
+}
@@ -0,0 +1,179 @@
+package memstore
+
+import (
+	"gin-server/internal/errors"
+	"gin-server/internal/mongogo"
+	"time"
+)
+
+func (s *Store) SendFriendRequest(source_id, target_id int) (mongogo.FriendRequest, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.checkIds([]int{source_id, target_id})
+	if err != nil {
+		return mongogo.FriendRequest{}, err
+	}
+
+	if contains(s.users[target_id].Friends, source_id) {
+		return mongogo.FriendRequest{}, &errors.FriendsExists{SourceId: source_id, TargetId: target_id}
+	}
+	if s.isBlocked(source_id, target_id) {
+		return mongogo.FriendRequest{}, &errors.UserBlocked{SourceId: source_id, TargetId: target_id}
+	}
+
+	for _, request := range s.requests {
+		if request.State != mongogo.PENDING {
+			continue
+		}
+		if (request.SourceId == source_id && request.TargetId == target_id) ||
+			(request.SourceId == target_id && request.TargetId == source_id) {
+			return mongogo.FriendRequest{}, &errors.RequestExists{SourceId: request.SourceId, TargetId: request.TargetId}
+		}
+	}
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	request := mongogo.FriendRequest{
+		Id:        s.nextRequestId,
+		SourceId:  source_id,
+		TargetId:  target_id,
+		State:     mongogo.PENDING,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	s.requests = append(s.requests, request)
+	s.nextRequestId++
+
+	return request, nil
+}
+
+func (s *Store) FriendRequests(user_id int, incoming bool, state string) ([]mongogo.FriendRequest, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.checkIds([]int{user_id})
+	if err != nil {
+		return []mongogo.FriendRequest{}, err
+	}
+
+	result := []mongogo.FriendRequest{}
+	for _, request := range s.requests {
+		id := request.SourceId
+		if incoming {
+			id = request.TargetId
+		}
+
+		if id == user_id && (state == "" || request.State == state) {
+			result = append(result, request)
+		}
+	}
+
+	return result, nil
+}
+
+func (s *Store) AnswerFriendRequest(request_id, user_id int, state string) (mongogo.FriendRequest, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, request := range s.requests {
+		if request.Id != request_id {
+			continue
+		}
+
+		err := mongogo.CheckTransition(request, user_id, state)
+		if err != nil {
+			return mongogo.FriendRequest{}, err
+		}
+
+		if state == mongogo.ACCEPTED {
+			err = s.addFriend(request.SourceId, request.TargetId)
+			if _, ok := err.(*errors.FriendsExists); !ok && err != nil {
+				return mongogo.FriendRequest{}, err
+			}
+		}
+
+		request.State = state
+		request.UpdatedAt = time.Now().UTC().Truncate(time.Millisecond)
+		s.requests[i] = request
+
+		return request, nil
+	}
+
+	return mongogo.FriendRequest{}, &errors.UndefinedRequest{Id: request_id}
+}
+
+func (s *Store) BlockUser(user_id, blocked_id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.checkIds([]int{user_id, blocked_id})
+	if err != nil {
+		return err
+	}
+
+	if !s.hasBlocked(user_id, blocked_id) {
+		s.blocks = append(s.blocks, mongogo.Block{
+			UserId:    user_id,
+			BlockedId: blocked_id,
+			CreatedAt: time.Now().UTC().Truncate(time.Millisecond),
+		})
+	}
+
+	for i, request := range s.requests {
+		if request.SourceId == blocked_id && request.TargetId == user_id && request.State == mongogo.PENDING {
+			s.requests[i].State = mongogo.REJECTED
+			s.requests[i].UpdatedAt = time.Now().UTC().Truncate(time.Millisecond)
+		}
+	}
+
+	return nil
+}
+
+func (s *Store) UnblockUser(user_id, blocked_id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	blocks := s.blocks[:0]
+	for _, block := range s.blocks {
+		if block.UserId != user_id || block.BlockedId != blocked_id {
+			blocks = append(blocks, block)
+		}
+	}
+	s.blocks = blocks
+
+	return nil
+}
+
+func (s *Store) BlockedUsers(user_id int) ([]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.checkIds([]int{user_id})
+	if err != nil {
+		return []int{}, err
+	}
+
+	result := []int{}
+	for _, id := range s.sortedIds() {
+		if s.hasBlocked(user_id, id) {
+			result = append(result, id)
+		}
+	}
+
+	return result, nil
+}
+
+func (s *Store) isBlocked(source_id, target_id int) bool {
+	return s.hasBlocked(target_id, source_id) || s.hasBlocked(source_id, target_id)
+}
+
+func (s *Store) hasBlocked(user_id, blocked_id int) bool {
+	for _, block := range s.blocks {
+		if block.UserId == user_id && block.BlockedId == blocked_id {
+			return true
+		}
+	}
+
+	return false
+}
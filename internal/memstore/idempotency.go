@@ -0,0 +1,48 @@
+package memstore
+
+import (
+	"gin-server/internal/mongogo"
+	"time"
+)
+
+func (s *Store) ReserveKey(key, hash string) (mongogo.IdempotencyRecord, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+
+	if record, ok := s.keys[key]; ok && now.Before(record.CreatedAt.Add(mongogo.IDEMPOTENCY_TTL)) {
+		return record, false, nil
+	}
+
+	record := mongogo.IdempotencyRecord{Key: key, Hash: hash, CreatedAt: now}
+	s.keys[key] = record
+
+	return record, true, nil
+}
+
+func (s *Store) CompleteKey(key string, status int, contentType string, body []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, ok := s.keys[key]
+	if !ok {
+		return nil
+	}
+
+	record.Status = status
+	record.ContentType = contentType
+	record.Body = append([]byte(nil), body...)
+	s.keys[key] = record
+
+	return nil
+}
+
+func (s *Store) ReleaseKey(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.keys, key)
+
+	return nil
+}
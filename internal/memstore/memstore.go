@@ -0,0 +1,605 @@
+package memstore
+
+import (
+	"gin-server/internal/errors"
+	"gin-server/internal/mongogo"
+	"gin-server/internal/storage"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Store keeps users in memory and mirrors the behaviour of mongogo.Connector,
+// so the api can be tested without mongodb.
+type Store struct {
+	mu     sync.Mutex
+	users  map[int]mongogo.User
+	nextId int
+
+	requests      []mongogo.FriendRequest
+	nextRequestId int
+	blocks        []mongogo.Block
+
+	keys map[string]mongogo.IdempotencyRecord
+
+	events  []mongogo.Event
+	cursors map[string]int
+
+	webhooks       []mongogo.Webhook
+	nextWebhookId  int
+	deliveries     []mongogo.Delivery
+	nextDeliveryId int
+
+	audit []mongogo.AuditRecord
+}
+
+var _ storage.Store = (*Store)(nil)
+
+func New() *Store {
+	return &Store{
+		users:  make(map[int]mongogo.User),
+		nextId: 1,
+
+		nextRequestId: 1,
+
+		keys: make(map[string]mongogo.IdempotencyRecord),
+
+		cursors: make(map[string]int),
+	}
+}
+
+func (s *Store) NewUser(name string, age int) (int, error) {
+	return s.CreateUser(mongogo.User{Name: name, Age: age})
+}
+
+func (s *Store) CreateUser(user mongogo.User) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.emailTaken(user.Email, 0) {
+		return 0, &errors.EmailExists{Email: user.Email}
+	}
+
+	user.Id = s.nextId
+	s.users[user.Id] = mongogo.NewProfile(copyUser(user), time.Now())
+	s.nextId++
+
+	s.publish(mongogo.UserEvent(mongogo.USER_CREATED, s.users[user.Id]))
+
+	return user.Id, nil
+}
+
+func (s *Store) UpdateProfile(user_id, version int, update mongogo.ProfileUpdate) (mongogo.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, err := s.versioned(user_id, version)
+	if err != nil {
+		return mongogo.User{}, err
+	}
+
+	if update.Email != nil && s.emailTaken(*update.Email, user_id) {
+		return mongogo.User{}, &errors.EmailExists{Email: *update.Email}
+	}
+
+	if update.Name != nil {
+		user.Name = *update.Name
+	}
+	if update.Age != nil {
+		user.Age = *update.Age
+	}
+	if update.Email != nil {
+		user.Email = *update.Email
+	}
+	if update.DisplayName != nil {
+		user.DisplayName = *update.DisplayName
+	}
+	if update.Bio != nil {
+		user.Bio = *update.Bio
+	}
+	if update.AvatarURL != nil {
+		user.AvatarURL = *update.AvatarURL
+	}
+	user.UpdatedAt = time.Now().UTC().Truncate(time.Millisecond)
+	user.Version++
+
+	s.users[user_id] = user
+	s.publish(mongogo.UserEvent(mongogo.USER_UPDATED, user))
+
+	return copyUser(user), nil
+}
+
+func (s *Store) GetUser(user_id int) (mongogo.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.user(user_id)
+	if !ok {
+		return mongogo.User{}, &errors.UndefinedIndexes{Indexes: []int{user_id}}
+	}
+
+	return copyUser(user), nil
+}
+
+func (s *Store) GetUsers(user_ids []int) ([]mongogo.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := []mongogo.User{}
+	for _, id := range user_ids {
+		if user, ok := s.user(id); ok {
+			result = append(result, copyUser(user))
+		}
+	}
+
+	return result, nil
+}
+
+func (s *Store) UpdateAge(user_id, newAge, version int) (mongogo.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, err := s.versioned(user_id, version)
+	if err != nil {
+		return mongogo.User{}, err
+	}
+
+	user.Age = newAge
+	user.UpdatedAt = time.Now().UTC().Truncate(time.Millisecond)
+	user.Version++
+	s.users[user_id] = user
+
+	s.publish(mongogo.UserEvent(mongogo.USER_UPDATED, user))
+
+	return copyUser(user), nil
+}
+
+// versioned returns the alive user, checking its version unless version is zero.
+func (s *Store) versioned(user_id, version int) (mongogo.User, error) {
+	user, ok := s.user(user_id)
+	if !ok {
+		return mongogo.User{}, &errors.UndefinedIndexes{Indexes: []int{user_id}}
+	}
+	if version != 0 && user.Version != version {
+		return mongogo.User{}, &errors.VersionMismatch{Id: user_id, Expected: version, Actual: user.Version}
+	}
+
+	return user, nil
+}
+
+func (s *Store) AddFriend(user_id, friend_id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.addFriend(user_id, friend_id)
+}
+
+func (s *Store) addFriend(user_id, friend_id int) error {
+	err := s.checkIds([]int{user_id, friend_id})
+	if err != nil {
+		return err
+	}
+
+	friend := s.users[friend_id]
+	if contains(friend.Friends, user_id) {
+		return &errors.FriendsExists{SourceId: user_id, TargetId: friend_id}
+	}
+	if s.isBlocked(user_id, friend_id) {
+		return &errors.UserBlocked{SourceId: user_id, TargetId: friend_id}
+	}
+
+	friend.Friends = append(copyUser(friend).Friends, user_id)
+	friend.Version++
+	s.users[friend_id] = friend
+
+	s.publish(mongogo.FriendEvent(mongogo.FRIEND_ADDED, friend_id, user_id))
+
+	return nil
+}
+
+func (s *Store) FriendExists(user_id, friend_id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, userOk := s.user(user_id)
+	friend, ok := s.user(friend_id)
+	if userOk && ok && contains(friend.Friends, user_id) {
+		return &errors.FriendsExists{SourceId: user_id, TargetId: friend_id}
+	}
+
+	return nil
+}
+
+func (s *Store) DelFriend(user_id, friend_id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[user_id]
+	if !ok || !contains(user.Friends, friend_id) {
+		return nil
+	}
+
+	user.Friends = without(user.Friends, friend_id)
+	user.Version++
+	s.users[user_id] = user
+
+	s.publish(mongogo.FriendEvent(mongogo.FRIEND_REMOVED, user_id, friend_id))
+
+	return nil
+}
+
+func (s *Store) DelUser(user_id int) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.user(user_id)
+	if !ok {
+		return "", &errors.UndefinedIndexes{Indexes: []int{user_id}}
+	}
+
+	deletedAt := time.Now().UTC().Truncate(time.Millisecond)
+	user.DeletedAt = &deletedAt
+	user.Version++
+	s.users[user_id] = user
+
+	s.publish(mongogo.UserEvent(mongogo.USER_DELETED, user))
+
+	return user.Name, nil
+}
+
+func (s *Store) RestoreUser(user_id int) (mongogo.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[user_id]
+	if !ok || user.DeletedAt == nil {
+		return mongogo.User{}, &errors.UndefinedIndexes{Indexes: []int{user_id}}
+	}
+
+	user.DeletedAt = nil
+	user.Version++
+	s.users[user_id] = user
+
+	s.publish(mongogo.UserEvent(mongogo.USER_UPDATED, user))
+
+	return copyUser(user), nil
+}
+
+func (s *Store) PurgeUsers(before time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var purged []int
+	for id, user := range s.users {
+		if user.DeletedAt != nil && user.DeletedAt.Before(before) {
+			purged = append(purged, id)
+			delete(s.users, id)
+		}
+	}
+
+	for _, id := range s.sortedIds() {
+		user := s.users[id]
+
+		removed := mongogo.Intersect(user.Friends, purged)
+		for _, purgedId := range removed {
+			user.Friends = without(user.Friends, purgedId)
+			s.publish(mongogo.FriendEvent(mongogo.FRIEND_REMOVED, id, purgedId))
+		}
+
+		if len(removed) > 0 {
+			user.Version++
+			s.users[id] = user
+		}
+	}
+
+	return len(purged), nil
+}
+
+func (s *Store) GetFriends(user_id int) ([]mongogo.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.user(user_id)
+	if !ok {
+		return []mongogo.User{}, &errors.UndefinedIndexes{Indexes: []int{user_id}}
+	}
+
+	var result []mongogo.User
+	for _, id := range s.sortedIds() {
+		if friend, ok := s.user(id); ok && contains(user.Friends, id) {
+			result = append(result, copyUser(friend))
+		}
+	}
+
+	return result, nil
+}
+
+func (s *Store) CheckIds(user_ids []int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.checkIds(user_ids)
+}
+
+func (s *Store) ReserveIds(n int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	first := s.nextId
+	s.nextId += n
+
+	return first, nil
+}
+
+func (s *Store) InsertUsers(users []mongogo.User) []error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := make([]error, len(users))
+
+	for i, user := range users {
+		if _, ok := s.users[user.Id]; ok {
+			result[i] = &errors.UserExists{Id: user.Id}
+			continue
+		}
+		if s.emailTaken(user.Email, 0) {
+			result[i] = &errors.EmailExists{Email: user.Email}
+			continue
+		}
+
+		user = mongogo.NewProfile(copyUser(user), time.Now())
+		s.users[user.Id] = user
+		s.publish(mongogo.UserEvent(mongogo.USER_CREATED, user))
+
+		if user.Id >= s.nextId {
+			s.nextId = user.Id + 1
+		}
+	}
+
+	return result
+}
+
+func (s *Store) ExportUsers(fn func(mongogo.User) error) error {
+	s.mu.Lock()
+	users := make([]mongogo.User, 0, len(s.users))
+	for _, id := range s.sortedIds() {
+		if user, ok := s.user(id); ok {
+			users = append(users, copyUser(user))
+		}
+	}
+	s.mu.Unlock()
+
+	for _, user := range users {
+		err := fn(user)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Suggestions walks the friend graph breadth first, like $graphLookup in mongogo.
+func (s *Store) Suggestions(user_id int, query mongogo.SuggestionsQuery) ([]mongogo.Suggestion, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.user(user_id)
+	if !ok {
+		return []mongogo.Suggestion{}, &errors.UndefinedIndexes{Indexes: []int{user_id}}
+	}
+
+	hops := make(map[int]int)
+	frontier := user.Friends
+
+	for depth := 1; depth <= query.Depth && len(frontier) > 0; depth++ {
+		var next []int
+
+		for _, id := range frontier {
+			friend, ok := s.user(id)
+			if _, seen := hops[id]; seen || !ok {
+				continue
+			}
+
+			hops[id] = depth
+			next = append(next, friend.Friends...)
+		}
+
+		frontier = next
+	}
+
+	result := []mongogo.Suggestion{}
+	for id, hop := range hops {
+		candidate := s.users[id]
+
+		if id == user_id || contains(user.Friends, id) {
+			continue
+		}
+		if candidate.Age < query.MinAge || (query.MaxAge > 0 && candidate.Age > query.MaxAge) {
+			continue
+		}
+
+		mutual := 0
+		for _, friend := range distinct(user.Friends) {
+			if contains(candidate.Friends, friend) {
+				mutual++
+			}
+		}
+
+		result = append(result, mongogo.Suggestion{User: copyUser(candidate), MutualFriends: mutual, Hops: hop})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].MutualFriends != result[j].MutualFriends {
+			return result[i].MutualFriends > result[j].MutualFriends
+		}
+		if result[i].Hops != result[j].Hops {
+			return result[i].Hops < result[j].Hops
+		}
+		return result[i].User.Id < result[j].User.Id
+	})
+
+	if query.Limit > 0 && len(result) > query.Limit {
+		result = result[:query.Limit]
+	}
+
+	return result, nil
+}
+
+func (s *Store) MutualFriends(user_id, other_id int) ([]mongogo.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.exist(user_id, other_id)
+	if err != nil {
+		return []mongogo.User{}, err
+	}
+
+	result := []mongogo.User{}
+	for _, id := range mongogo.Intersect(s.users[user_id].Friends, s.users[other_id].Friends) {
+		if friend, ok := s.user(id); ok {
+			result = append(result, copyUser(friend))
+		}
+	}
+
+	return result, nil
+}
+
+func (s *Store) FriendPath(user_id, other_id, maxDepth int) ([]mongogo.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.exist(user_id, other_id)
+	if err != nil {
+		return []mongogo.User{}, err
+	}
+
+	ids, err := mongogo.ShortestPath(user_id, other_id, maxDepth, func(user_ids []int) (map[int][]int, error) {
+		result := make(map[int][]int, len(user_ids))
+		for _, id := range user_ids {
+			if user, ok := s.user(id); ok {
+				result[id] = user.Friends
+			}
+		}
+
+		return result, nil
+	})
+	if err != nil {
+		return []mongogo.User{}, err
+	}
+
+	result := make([]mongogo.User, 0, len(ids))
+	for _, id := range ids {
+		result = append(result, copyUser(s.users[id]))
+	}
+
+	return result, nil
+}
+
+// emailTaken reports whether another user, deleted ones included, has the email,
+// like the unique email index in mongogo.
+func (s *Store) emailTaken(email string, user_id int) bool {
+	if email == "" {
+		return false
+	}
+
+	for id, user := range s.users {
+		if id != user_id && user.Email == email {
+			return true
+		}
+	}
+
+	return false
+}
+
+// user returns the user unless it is missing or soft deleted.
+func (s *Store) user(user_id int) (mongogo.User, bool) {
+	user, ok := s.users[user_id]
+	if !ok || user.DeletedAt != nil {
+		return mongogo.User{}, false
+	}
+
+	return user, true
+}
+
+func (s *Store) exist(user_ids ...int) error {
+	for _, id := range user_ids {
+		if _, ok := s.user(id); !ok {
+			return &errors.UndefinedIndexes{Indexes: []int{id}}
+		}
+	}
+
+	return nil
+}
+
+// checkIds counts matching users like the $in query in mongogo, so repeated ids
+// are reported as undefined.
+func (s *Store) checkIds(user_ids []int) error {
+	found := 0
+	for id, user := range s.users {
+		if user.DeletedAt == nil && contains(user_ids, id) {
+			found++
+		}
+	}
+
+	if found != len(user_ids) {
+		return &errors.UndefinedIndexes{Indexes: user_ids}
+	}
+
+	return nil
+}
+
+func (s *Store) sortedIds() []int {
+	ids := make([]int, 0, len(s.users))
+	for id := range s.users {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	return ids
+}
+
+func copyUser(user mongogo.User) mongogo.User {
+	user.Friends = append([]int{}, user.Friends...)
+	if user.DeletedAt != nil {
+		deletedAt := *user.DeletedAt
+		user.DeletedAt = &deletedAt
+	}
+
+	return user
+}
+
+func contains(ids []int, id int) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+func without(ids []int, id int) []int {
+	result := make([]int, 0, len(ids))
+	for _, i := range ids {
+		if i != id {
+			result = append(result, i)
+		}
+	}
+
+	return result
+}
+
+func distinct(ids []int) []int {
+	seen := make(map[int]bool)
+	result := make([]int, 0, len(ids))
+
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			result = append(result, id)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,79 @@
+package memstore
+
+import (
+	"gin-server/internal/mongogo"
+	"sort"
+	"strings"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+	"golang.org/x/text/unicode/norm"
+)
+
+// SearchUsers matches names by case folded prefix and sorts them with an
+// English case-insensitive collation, like mongogo.NameCollation.
+func (s *Store) SearchUsers(query mongogo.SearchQuery) ([]mongogo.User, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fold := cases.Fold()
+	prefix := fold.String(norm.NFC.String(query.Text))
+
+	matches := []mongogo.User{}
+	for _, id := range s.sortedIds() {
+		user, ok := s.user(id)
+		if !ok {
+			continue
+		}
+		if user.Age < query.MinAge || (query.MaxAge > 0 && user.Age > query.MaxAge) {
+			continue
+		}
+		if !strings.HasPrefix(fold.String(norm.NFC.String(user.Name)), prefix) {
+			continue
+		}
+
+		matches = append(matches, copyUser(user))
+	}
+
+	collator := collate.New(language.English, collate.IgnoreCase)
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		if query.Descending {
+			a, b = b, a
+		}
+
+		switch query.SortField {
+		case "name":
+			if cmp := collator.CompareString(a.Name, b.Name); cmp != 0 {
+				return cmp < 0
+			}
+		case "age":
+			if a.Age != b.Age {
+				return a.Age < b.Age
+			}
+		case "created_at":
+			if !a.CreatedAt.Equal(b.CreatedAt) {
+				return a.CreatedAt.Before(b.CreatedAt)
+			}
+		default:
+			return a.Id < b.Id
+		}
+
+		return matches[i].Id < matches[j].Id
+	})
+
+	total := len(matches)
+
+	if query.Skip >= len(matches) {
+		return []mongogo.User{}, total, nil
+	}
+	matches = matches[query.Skip:]
+
+	if query.Limit > 0 && len(matches) > query.Limit {
+		matches = matches[:query.Limit]
+	}
+
+	return matches, total, nil
+}
@@ -0,0 +1,140 @@
+package memstore
+
+import (
+	"gin-server/internal/errors"
+	"gin-server/internal/mongogo"
+	"time"
+)
+
+func (s *Store) CreateWebhook(hook mongogo.Webhook) (mongogo.Webhook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextWebhookId++
+	hook.Id = s.nextWebhookId
+	hook.CreatedAt = time.Now().UTC().Truncate(time.Millisecond)
+	hook.Events = append([]string{}, hook.Events...)
+
+	s.webhooks = append(s.webhooks, hook)
+
+	return hook, nil
+}
+
+func (s *Store) Webhooks() ([]mongogo.Webhook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]mongogo.Webhook{}, s.webhooks...), nil
+}
+
+func (s *Store) DeleteWebhook(webhook_id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, hook := range s.webhooks {
+		if hook.Id != webhook_id {
+			continue
+		}
+
+		s.webhooks = append(s.webhooks[:i:i], s.webhooks[i+1:]...)
+
+		deliveries := s.deliveries[:0:0]
+		for _, delivery := range s.deliveries {
+			if delivery.WebhookId != webhook_id || delivery.State != mongogo.DELIVERY_PENDING {
+				deliveries = append(deliveries, delivery)
+			}
+		}
+		s.deliveries = deliveries
+
+		return nil
+	}
+
+	return &errors.UndefinedWebhook{Id: webhook_id}
+}
+
+func (s *Store) EnqueueDeliveries(event mongogo.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+
+	for _, hook := range s.webhooks {
+		if !hook.Wants(event.Type) || s.enqueued(hook.Id, event.Id) {
+			continue
+		}
+
+		s.nextDeliveryId++
+		s.deliveries = append(s.deliveries, mongogo.Delivery{
+			Id:          s.nextDeliveryId,
+			WebhookId:   hook.Id,
+			Event:       copyEvent(event),
+			State:       mongogo.DELIVERY_PENDING,
+			NextAttempt: now,
+			CreatedAt:   now,
+			UpdatedAt:   now,
+		})
+	}
+
+	return nil
+}
+
+func (s *Store) DueDeliveries(now time.Time, limit int) ([]mongogo.Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := []mongogo.Delivery{}
+	for i, delivery := range s.deliveries {
+		if len(result) == limit {
+			break
+		}
+		if delivery.State == mongogo.DELIVERY_PENDING && !delivery.NextAttempt.After(now) {
+			s.deliveries[i].NextAttempt = now.Add(mongogo.DELIVERY_LEASE)
+			result = append(result, delivery)
+		}
+	}
+
+	return result, nil
+}
+
+func (s *Store) SaveDelivery(delivery mongogo.Delivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.deliveries {
+		if s.deliveries[i].Id == delivery.Id {
+			delivery.UpdatedAt = time.Now().UTC().Truncate(time.Millisecond)
+			s.deliveries[i] = delivery
+		}
+	}
+
+	return nil
+}
+
+func (s *Store) Deliveries(query mongogo.DeliveryQuery) ([]mongogo.Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := []mongogo.Delivery{}
+	for i := len(s.deliveries) - 1; i >= 0; i-- {
+		delivery := s.deliveries[i]
+
+		if query.Limit > 0 && len(result) == query.Limit {
+			break
+		}
+		if delivery.WebhookId == query.WebhookId && (query.State == "" || delivery.State == query.State) {
+			result = append(result, delivery)
+		}
+	}
+
+	return result, nil
+}
+
+func (s *Store) enqueued(webhook_id, event_id int) bool {
+	for _, delivery := range s.deliveries {
+		if delivery.WebhookId == webhook_id && delivery.Event.Id == event_id {
+			return true
+		}
+	}
+
+	return false
+}
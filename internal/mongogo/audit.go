@@ -0,0 +1,179 @@
+package mongogo
+
+import (
+	"context"
+	"encoding/json"
+	"gin-server/internal/errors"
+	"reflect"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// AuditRecord is a mutating request: who sent it, what it did and how the users
+// it touched changed. Records are only ever inserted. Actor is the
+// authenticated caller, ClaimedActor the name the caller gave itself, which
+// nothing checks.
+type AuditRecord struct {
+	Id           int               `json:"id" bson:"id"`
+	Actor        string            `json:"actor" bson:"actor"`
+	ClaimedActor string            `json:"claimed_actor,omitempty" bson:"claimed_actor,omitempty"`
+	Action       string            `json:"action" bson:"action"`
+	Method       string            `json:"method" bson:"method"`
+	Path         string            `json:"path" bson:"path"`
+	Params       map[string]string `json:"params,omitempty" bson:"params,omitempty"`
+	Targets      []int             `json:"targets" bson:"targets"`
+	Changes      []UserChange      `json:"changes,omitempty" bson:"changes,omitempty"`
+	Status       int               `json:"status" bson:"status"`
+	RequestId    string            `json:"request_id" bson:"request_id"`
+	IP           string            `json:"ip" bson:"ip"`
+	CreatedAt    time.Time         `json:"created_at" bson:"created_at"`
+}
+
+// UserChange holds the fields of a user that differ before and after a request.
+type UserChange struct {
+	UserId int                    `json:"user_id" bson:"user_id"`
+	Fields map[string]FieldChange `json:"fields" bson:"fields"`
+}
+
+// FieldChange is a changed field, Before is nil for a created user and After
+// for a deleted one.
+type FieldChange struct {
+	Before interface{} `json:"before" bson:"before"`
+	After  interface{} `json:"after" bson:"after"`
+}
+
+// AuditQuery selects audit records, every filter is optional.
+type AuditQuery struct {
+	Actor        string
+	ClaimedActor string
+	TargetId     int
+	From         time.Time
+	To           time.Time
+	Limit        int
+}
+
+// auditIgnored are the fields that change with every update of a user.
+var auditIgnored = map[string]bool{"version": true, "updated_at": true}
+
+// DiffUser returns the change of a user between two snapshots, nil stands for
+// a user that did not exist.
+func DiffUser(user_id int, before, after *User) (UserChange, bool) {
+	was, now := userFields(before), userFields(after)
+
+	change := UserChange{UserId: user_id, Fields: map[string]FieldChange{}}
+	for key := range was {
+		if !auditIgnored[key] && !reflect.DeepEqual(was[key], now[key]) {
+			change.Fields[key] = FieldChange{Before: was[key], After: now[key]}
+		}
+	}
+	for key := range now {
+		if _, ok := was[key]; !ok && !auditIgnored[key] {
+			change.Fields[key] = FieldChange{After: now[key]}
+		}
+	}
+
+	return change, len(change.Fields) > 0
+}
+
+func userFields(user *User) map[string]interface{} {
+	fields := map[string]interface{}{}
+	if user == nil {
+		return fields
+	}
+
+	data, err := json.Marshal(user)
+	if err == nil {
+		json.Unmarshal(data, &fields)
+	}
+
+	return fields
+}
+
+// Matches reports whether the record is selected by the query, Limit aside.
+func (q AuditQuery) Matches(record AuditRecord) bool {
+	if q.Actor != "" && record.Actor != q.Actor {
+		return false
+	}
+	if q.ClaimedActor != "" && record.ClaimedActor != q.ClaimedActor {
+		return false
+	}
+	if !q.From.IsZero() && record.CreatedAt.Before(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && !record.CreatedAt.Before(q.To) {
+		return false
+	}
+	if q.TargetId == 0 {
+		return true
+	}
+
+	for _, target := range record.Targets {
+		if target == q.TargetId {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *Connector) AppendAudit(record AuditRecord) error {
+	id, err := c.nextIds(context.TODO(), "audit_id", 1)
+	if err != nil {
+		return err
+	}
+
+	record.Id = id
+	if record.CreatedAt.IsZero() {
+		record.CreatedAt = time.Now().UTC()
+	}
+	record.CreatedAt = record.CreatedAt.Truncate(time.Millisecond)
+
+	_, err = c.audit.InsertOne(context.TODO(), record)
+	if err != nil {
+		return &errors.InternarMongoError{Err: err}
+	}
+
+	return nil
+}
+
+// AuditLog returns the records selected by query, newest first. To is exclusive.
+func (c *Connector) AuditLog(query AuditQuery) ([]AuditRecord, error) {
+	filter := bson.D{}
+	if query.Actor != "" {
+		filter = append(filter, bson.E{Key: "actor", Value: query.Actor})
+	}
+	if query.ClaimedActor != "" {
+		filter = append(filter, bson.E{Key: "claimed_actor", Value: query.ClaimedActor})
+	}
+	if query.TargetId != 0 {
+		filter = append(filter, bson.E{Key: "targets", Value: query.TargetId})
+	}
+
+	period := bson.D{}
+	if !query.From.IsZero() {
+		period = append(period, bson.E{Key: "$gte", Value: query.From})
+	}
+	if !query.To.IsZero() {
+		period = append(period, bson.E{Key: "$lt", Value: query.To})
+	}
+	if len(period) > 0 {
+		filter = append(filter, bson.E{Key: "created_at", Value: period})
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "id", Value: -1}}).SetLimit(int64(query.Limit))
+
+	cursor, err := c.audit.Find(context.TODO(), filter, opts)
+	if err != nil {
+		return []AuditRecord{}, &errors.InternarMongoError{Err: err}
+	}
+
+	result := []AuditRecord{}
+	err = cursor.All(context.TODO(), &result)
+	if err != nil {
+		return []AuditRecord{}, &errors.InternarMongoError{Err: err}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,243 @@
+package mongogo
+
+import (
+	"context"
+	"gin-server/internal/errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ReserveIds allocates n consecutive user ids and returns the first one.
+func (c *Connector) ReserveIds(n int) (int, error) {
+	return c.reserveIds("user_id", n)
+}
+
+// reserveIds allocates n consecutive ids of the counter name in one update, so
+// concurrent callers never get the same ids, and returns the first one.
+func (c *Connector) reserveIds(name string, n int) (int, error) {
+	_, err := c.GetCounter(name)
+	if err != nil {
+		return 0, err
+	}
+
+	filter := bson.D{{Key: "name", Value: name}}
+	update := bson.D{{
+		Key: "$inc", Value: bson.D{{Key: "value", Value: n}},
+	}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var result Counter
+	err = c.counters.FindOneAndUpdate(context.TODO(), filter, update, opts).Decode(&result)
+	if err != nil {
+		return 0, &errors.InternarMongoError{Err: err}
+	}
+
+	return result.Value - n, nil
+}
+
+// InsertUsers inserts users with their ids and friend lists as given. The
+// returned slice holds an error for every user that was not inserted.
+func (c *Connector) InsertUsers(users []User) []error {
+	result := make([]error, len(users))
+
+	ids := make([]int, 0, len(users))
+	for _, user := range users {
+		ids = append(ids, user.Id)
+	}
+
+	existing, err := c.existingIds(ids)
+	if err != nil {
+		return fill(result, err)
+	}
+
+	now := time.Now()
+	profiles := make([]User, 0, len(users))
+	positions := make([]int, 0, len(users))
+	maxId := 0
+
+	for i, user := range users {
+		if existing[user.Id] {
+			result[i] = &errors.UserExists{Id: user.Id}
+			continue
+		}
+		profiles = append(profiles, NewProfile(user, now))
+		positions = append(positions, i)
+
+		if user.Id > maxId {
+			maxId = user.Id
+		}
+	}
+
+	// a failed insert aborts the whole transaction, so the batch is retried
+	// without the users that failed until the rest goes through
+	for len(profiles) > 0 {
+		bwe, err := c.insertProfiles(profiles)
+		if err != nil {
+			return fill(result, err)
+		}
+
+		failed := make(map[int]bool, len(bwe.WriteErrors))
+		for _, we := range bwe.WriteErrors {
+			i := positions[we.Index]
+			failed[we.Index] = true
+
+			if we.Code == DUPLICATE_KEY && onEmailIndex(we.Message) {
+				result[i] = &errors.EmailExists{Email: users[i].Email}
+			} else if we.Code == DUPLICATE_KEY {
+				result[i] = &errors.UserExists{Id: users[i].Id}
+			} else {
+				result[i] = &errors.InternarMongoError{Err: we}
+			}
+		}
+		if len(failed) == 0 || !c.transactions {
+			break
+		}
+
+		var retry []User
+		var retryPositions []int
+		for j, profile := range profiles {
+			if !failed[j] {
+				retry = append(retry, profile)
+				retryPositions = append(retryPositions, positions[j])
+			}
+		}
+		profiles, positions = retry, retryPositions
+	}
+
+	err = c.counterAtLeast("user_id", maxId+1)
+	if err != nil {
+		return fill(result, err)
+	}
+
+	return result
+}
+
+// insertProfiles inserts users and their UserCreated events. Users that can not
+// be inserted are reported in the returned exception, without transactions the
+// others are inserted anyway.
+func (c *Connector) insertProfiles(profiles []User) (mongo.BulkWriteException, error) {
+	var bwe mongo.BulkWriteException
+
+	docs := make([]interface{}, len(profiles))
+	for i, profile := range profiles {
+		docs[i] = profile
+	}
+
+	err := c.inTransaction(func(ctx context.Context) error {
+		_, err := c.users.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+		if e, ok := err.(mongo.BulkWriteException); ok && c.transactions {
+			bwe = e
+			return err
+		} else if ok {
+			bwe = e
+		} else if err != nil {
+			return &errors.InternarMongoError{Err: err}
+		}
+
+		failed := make(map[int]bool, len(bwe.WriteErrors))
+		for _, we := range bwe.WriteErrors {
+			failed[we.Index] = true
+		}
+
+		events := make([]Event, 0, len(profiles))
+		for i, profile := range profiles {
+			if !failed[i] {
+				events = append(events, UserEvent(USER_CREATED, profile))
+			}
+		}
+
+		return c.publish(ctx, events...)
+	})
+	if _, ok := err.(mongo.BulkWriteException); ok {
+		return bwe, nil
+	}
+
+	return bwe, err
+}
+
+// ExportUsers calls fn for every user in id order, reading straight from the cursor.
+func (c *Connector) ExportUsers(fn func(User) error) error {
+	opts := options.Find().SetSort(bson.D{{Key: "id", Value: 1}})
+
+	cursor, err := c.users.Find(context.TODO(), alive(bson.D{}), opts)
+	if err != nil {
+		return &errors.InternarMongoError{Err: err}
+	}
+	defer cursor.Close(context.TODO())
+
+	for cursor.Next(context.TODO()) {
+		var user User
+
+		err := cursor.Decode(&user)
+		if err != nil {
+			return &errors.InternarMongoError{Err: err}
+		}
+
+		err = fn(user)
+		if err != nil {
+			return err
+		}
+	}
+
+	if cursor.Err() != nil {
+		return &errors.InternarMongoError{Err: cursor.Err()}
+	}
+
+	return nil
+}
+
+func (c *Connector) existingIds(user_ids []int) (map[int]bool, error) {
+	filter := bson.D{{
+		Key: "id",
+		Value: bson.D{{
+			Key:   "$in",
+			Value: user_ids,
+		}},
+	}}
+
+	cursor, err := c.users.Find(context.TODO(), filter)
+	if err != nil {
+		return nil, &errors.InternarMongoError{Err: err}
+	}
+
+	var found []User
+	err = cursor.All(context.TODO(), &found)
+	if err != nil {
+		return nil, &errors.InternarMongoError{Err: err}
+	}
+
+	result := make(map[int]bool, len(found))
+	for _, user := range found {
+		result[user.Id] = true
+	}
+
+	return result, nil
+}
+
+// counterAtLeast moves the counter forward so explicitly inserted ids are never reissued.
+func (c *Connector) counterAtLeast(name string, value int) error {
+	filter := bson.D{{Key: "name", Value: name}}
+	update := bson.D{{
+		Key: "$max", Value: bson.D{{Key: "value", Value: value}},
+	}}
+
+	_, err := c.counters.UpdateOne(context.TODO(), filter, update, options.Update().SetUpsert(true))
+	if err != nil {
+		return &errors.InternarMongoError{Err: err}
+	}
+
+	return nil
+}
+
+func fill(result []error, err error) []error {
+	for i := range result {
+		if result[i] == nil {
+			result[i] = err
+		}
+	}
+
+	return result
+}
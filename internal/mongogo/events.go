@@ -0,0 +1,216 @@
+package mongogo
+
+import (
+	"context"
+	"gin-server/internal/errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	USER_CREATED   string = "UserCreated"
+	USER_UPDATED   string = "UserUpdated"
+	USER_DELETED   string = "UserDeleted"
+	FRIEND_ADDED   string = "FriendAdded"
+	FRIEND_REMOVED string = "FriendRemoved"
+)
+
+// OUTBOX_RETENTION is how long events are kept in the outbox.
+const OUTBOX_RETENTION time.Duration = 7 * 24 * time.Hour
+
+// Event is a change of a user written to the outbox together with the change
+// itself. Ids come from a counter taken before the write, so they have no
+// holes but an event may commit after one with a higher id: a reader that
+// keeps only the id of the last event it has seen must wait at a missing id
+// for a while before it moves past it. For friend events UserId is the user
+// whose friend list changed and FriendId the added or removed friend, for the
+// other events User is the user after the change.
+type Event struct {
+	Id        int       `json:"id" bson:"id"`
+	Type      string    `json:"type" bson:"type"`
+	UserId    int       `json:"user_id" bson:"user_id"`
+	FriendId  int       `json:"friend_id,omitempty" bson:"friend_id,omitempty"`
+	User      *User     `json:"user,omitempty" bson:"user,omitempty"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+}
+
+type EventCursor struct {
+	Name    string `json:"name" bson:"name"`
+	EventId int    `json:"event_id" bson:"event_id"`
+}
+
+func UserEvent(eventType string, user User) Event {
+	return Event{Type: eventType, UserId: user.Id, User: &user}
+}
+
+func FriendEvent(eventType string, user_id, friend_id int) Event {
+	return Event{Type: eventType, UserId: user_id, FriendId: friend_id}
+}
+
+// inTransaction runs fn in a transaction when the deployment supports them.
+// A standalone server does not, there fn runs as is and an event can be lost
+// if the server fails between a change and its outbox write.
+func (c *Connector) inTransaction(fn func(ctx context.Context) error) error {
+	if !c.transactions {
+		return fn(context.TODO())
+	}
+
+	session, err := c.client.StartSession()
+	if err != nil {
+		return &errors.InternarMongoError{Err: err}
+	}
+	defer session.EndSession(context.TODO())
+
+	_, err = session.WithTransaction(context.TODO(), func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+
+	return err
+}
+
+// publish writes events to the outbox, within the transaction of ctx if any.
+func (c *Connector) publish(ctx context.Context, events ...Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	first, err := c.nextIds(ctx, "event_id", len(events))
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	docs := make([]interface{}, len(events))
+	for i, event := range events {
+		event.Id = first + i
+		event.CreatedAt = now
+		docs[i] = event
+	}
+
+	_, err = c.outbox.InsertMany(ctx, docs)
+	if err != nil {
+		return &errors.InternarMongoError{Err: err}
+	}
+
+	return nil
+}
+
+// nextIds allocates n consecutive ids from a counter that starts at 1 and
+// returns the first one.
+func (c *Connector) nextIds(ctx context.Context, name string, n int) (int, error) {
+	filter := bson.D{{Key: "name", Value: name}}
+	update := bson.D{{
+		Key: "$inc", Value: bson.D{{Key: "value", Value: n}},
+	}}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var counter Counter
+	err := c.counters.FindOneAndUpdate(ctx, filter, update, opts).Decode(&counter)
+	if err != nil {
+		return 0, &errors.InternarMongoError{Err: err}
+	}
+
+	return counter.Value - n + 1, nil
+}
+
+// Events returns up to limit events with ids greater than after, in id order.
+func (c *Connector) Events(after, limit int) ([]Event, error) {
+	filter := bson.D{{Key: "id", Value: bson.D{{Key: "$gt", Value: after}}}}
+	opts := options.Find().SetSort(bson.D{{Key: "id", Value: 1}}).SetLimit(int64(limit))
+
+	cursor, err := c.outbox.Find(context.TODO(), filter, opts)
+	if err != nil {
+		return []Event{}, &errors.InternarMongoError{Err: err}
+	}
+
+	result := []Event{}
+	err = cursor.All(context.TODO(), &result)
+	if err != nil {
+		return []Event{}, &errors.InternarMongoError{Err: err}
+	}
+
+	return result, nil
+}
+
+// UserEvents returns up to limit events of a user with ids greater than after,
+// in id order.
+func (c *Connector) UserEvents(user_id, after, limit int) ([]Event, error) {
+	filter := bson.D{
+		{Key: "user_id", Value: user_id},
+		{Key: "id", Value: bson.D{{Key: "$gt", Value: after}}},
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "id", Value: 1}}).SetLimit(int64(limit))
+
+	cursor, err := c.outbox.Find(context.TODO(), filter, opts)
+	if err != nil {
+		return []Event{}, &errors.InternarMongoError{Err: err}
+	}
+
+	result := []Event{}
+	err = cursor.All(context.TODO(), &result)
+	if err != nil {
+		return []Event{}, &errors.InternarMongoError{Err: err}
+	}
+
+	return result, nil
+}
+
+// LastEventId returns the id of the newest event, zero if there are none.
+func (c *Connector) LastEventId() (int, error) {
+	opts := options.FindOne().SetSort(bson.D{{Key: "id", Value: -1}})
+
+	var result Event
+	err := c.outbox.FindOne(context.TODO(), bson.D{}, opts).Decode(&result)
+	if err == mongo.ErrNoDocuments {
+		return 0, nil
+	} else if err != nil {
+		return 0, &errors.InternarMongoError{Err: err}
+	}
+
+	return result.Id, nil
+}
+
+// EventCursor returns the id of the last event delivered to the subscriber.
+func (c *Connector) EventCursor(name string) (int, error) {
+	var result EventCursor
+
+	err := c.eventCursors.FindOne(context.TODO(), bson.D{{Key: "name", Value: name}}).Decode(&result)
+	if err == mongo.ErrNoDocuments {
+		return 0, nil
+	} else if err != nil {
+		return 0, &errors.InternarMongoError{Err: err}
+	}
+
+	return result.EventId, nil
+}
+
+func (c *Connector) SaveEventCursor(name string, event_id int) error {
+	filter := bson.D{{Key: "name", Value: name}}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "event_id", Value: event_id}}}}
+
+	_, err := c.eventCursors.UpdateOne(context.TODO(), filter, update, options.Update().SetUpsert(true))
+	if err != nil {
+		return &errors.InternarMongoError{Err: err}
+	}
+
+	return nil
+}
+
+// supportsTransactions reports whether the server is a replica set member or
+// a mongos, the deployments with multi-document transactions.
+func supportsTransactions(client *mongo.Client) (bool, error) {
+	var result struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+
+	err := client.Database("admin").RunCommand(context.TODO(), bson.D{{Key: "isMaster", Value: 1}}).Decode(&result)
+	if err != nil {
+		return false, &errors.InternarMongoError{Err: err}
+	}
+
+	return result.SetName != "" || result.Msg == "isdbgrid", nil
+}
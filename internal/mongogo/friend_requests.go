@@ -0,0 +1,322 @@
+package mongogo
+
+import (
+	"context"
+	"gin-server/internal/errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	PENDING   string = "pending"
+	ACCEPTED  string = "accepted"
+	REJECTED  string = "rejected"
+	CANCELLED string = "cancelled"
+)
+
+// FriendRequest asks TargetId to add SourceId to its friend list, the same
+// direction as AddFriend(SourceId, TargetId).
+type FriendRequest struct {
+	Id        int       `json:"id" bson:"id"`
+	SourceId  int       `json:"source_id" bson:"source_id"`
+	TargetId  int       `json:"target_id" bson:"target_id"`
+	State     string    `json:"state" bson:"state"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" bson:"updated_at"`
+}
+
+type Block struct {
+	UserId    int       `json:"user_id" bson:"user_id"`
+	BlockedId int       `json:"blocked_id" bson:"blocked_id"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+}
+
+// CheckTransition reports whether user_id may move the request to state: the
+// target accepts or rejects, the source cancels, and only pending requests move.
+func CheckTransition(request FriendRequest, user_id int, state string) error {
+	switch state {
+	case ACCEPTED, REJECTED:
+		if request.TargetId != user_id {
+			return &errors.Forbidden{UserId: user_id, Action: "answer friend request"}
+		}
+	case CANCELLED:
+		if request.SourceId != user_id {
+			return &errors.Forbidden{UserId: user_id, Action: "cancel friend request"}
+		}
+	default:
+		return &errors.RequestState{Id: request.Id, State: state}
+	}
+
+	if request.State != PENDING {
+		return &errors.RequestState{Id: request.Id, State: request.State}
+	}
+
+	return nil
+}
+
+func (c *Connector) SendFriendRequest(source_id, target_id int) (FriendRequest, error) {
+	err := c.CheckIds([]int{source_id, target_id})
+	if err != nil {
+		return FriendRequest{}, err
+	}
+
+	err = c.FriendExists(source_id, target_id)
+	if err != nil {
+		return FriendRequest{}, err
+	}
+
+	blocked, err := c.isBlocked(source_id, target_id)
+	if err != nil {
+		return FriendRequest{}, err
+	}
+	if blocked {
+		return FriendRequest{}, &errors.UserBlocked{SourceId: source_id, TargetId: target_id}
+	}
+
+	// a pending request the other way is answered rather than crossed
+	for _, pair := range [][2]int{{source_id, target_id}, {target_id, source_id}} {
+		filter := bson.D{
+			{Key: "source_id", Value: pair[0]},
+			{Key: "target_id", Value: pair[1]},
+			{Key: "state", Value: PENDING},
+		}
+		count, err := c.friendRequests.CountDocuments(context.TODO(), filter)
+		if err != nil {
+			return FriendRequest{}, &errors.InternarMongoError{Err: err}
+		}
+		if count > 0 {
+			return FriendRequest{}, &errors.RequestExists{SourceId: pair[0], TargetId: pair[1]}
+		}
+	}
+
+	requestId, err := c.reserveIds("friend_request_id", 1)
+	if err != nil {
+		return FriendRequest{}, err
+	}
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	request := FriendRequest{
+		Id:        requestId,
+		SourceId:  source_id,
+		TargetId:  target_id,
+		State:     PENDING,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	// the pending index of migration 11 refuses a request sent concurrently
+	_, err = c.friendRequests.InsertOne(context.TODO(), request)
+	if mongo.IsDuplicateKeyError(err) {
+		return FriendRequest{}, &errors.RequestExists{SourceId: source_id, TargetId: target_id}
+	} else if err != nil {
+		return FriendRequest{}, &errors.InternarMongoError{Err: err}
+	}
+
+	return request, nil
+}
+
+// FriendRequests lists the incoming or outgoing requests of the user, state ""
+// lists requests in every state.
+func (c *Connector) FriendRequests(user_id int, incoming bool, state string) ([]FriendRequest, error) {
+	err := c.CheckIds([]int{user_id})
+	if err != nil {
+		return []FriendRequest{}, err
+	}
+
+	field := "source_id"
+	if incoming {
+		field = "target_id"
+	}
+
+	filter := bson.D{{Key: field, Value: user_id}}
+	if state != "" {
+		filter = append(filter, bson.E{Key: "state", Value: state})
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "id", Value: 1}})
+	cursor, err := c.friendRequests.Find(context.TODO(), filter, opts)
+	if err != nil {
+		return []FriendRequest{}, &errors.InternarMongoError{Err: err}
+	}
+
+	result := []FriendRequest{}
+	err = cursor.All(context.TODO(), &result)
+	if err != nil {
+		return []FriendRequest{}, &errors.InternarMongoError{Err: err}
+	}
+
+	return result, nil
+}
+
+// AnswerFriendRequest moves a pending request to state on behalf of user_id.
+// Accepting adds the friendship and moves the request in one transaction, a
+// friendship that exists already accepts the request as well. Without
+// transactions the friendship is added first, a request left pending by a
+// failure in between can be accepted again.
+func (c *Connector) AnswerFriendRequest(request_id, user_id int, state string) (FriendRequest, error) {
+	var request FriendRequest
+
+	err := c.friendRequests.FindOne(context.TODO(), bson.D{{Key: "id", Value: request_id}}).Decode(&request)
+	if err == mongo.ErrNoDocuments {
+		return FriendRequest{}, &errors.UndefinedRequest{Id: request_id}
+	} else if err != nil {
+		return FriendRequest{}, &errors.InternarMongoError{Err: err}
+	}
+
+	err = CheckTransition(request, user_id, state)
+	if err != nil {
+		return FriendRequest{}, err
+	}
+
+	if state == ACCEPTED {
+		err = c.CheckIds([]int{request.SourceId, request.TargetId})
+		if err != nil {
+			return FriendRequest{}, err
+		}
+
+		blocked, err := c.isBlocked(request.SourceId, request.TargetId)
+		if err != nil {
+			return FriendRequest{}, err
+		}
+		if blocked {
+			return FriendRequest{}, &errors.UserBlocked{SourceId: request.SourceId, TargetId: request.TargetId}
+		}
+	}
+
+	filter := bson.D{
+		{Key: "id", Value: request_id},
+		{Key: "state", Value: PENDING},
+	}
+	update := bson.D{{
+		Key: "$set",
+		Value: bson.D{
+			{Key: "state", Value: state},
+			{Key: "updated_at", Value: time.Now().UTC().Truncate(time.Millisecond)},
+		},
+	}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	err = c.inTransaction(func(ctx context.Context) error {
+		if state == ACCEPTED {
+			err := c.addFriend(ctx, request.SourceId, request.TargetId)
+			if _, ok := err.(*errors.FriendsExists); !ok && err != nil {
+				return err
+			}
+		}
+
+		err := c.friendRequests.FindOneAndUpdate(ctx, filter, update, opts).Decode(&request)
+		if err == mongo.ErrNoDocuments {
+			return &errors.RequestState{Id: request_id, State: "no longer pending"}
+		} else if err != nil {
+			return &errors.InternarMongoError{Err: err}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return FriendRequest{}, err
+	}
+
+	return request, nil
+}
+
+// BlockUser stops blocked_id from sending friend requests to user_id and rejects
+// the pending ones.
+func (c *Connector) BlockUser(user_id, blocked_id int) error {
+	err := c.CheckIds([]int{user_id, blocked_id})
+	if err != nil {
+		return err
+	}
+
+	filter := bson.D{
+		{Key: "user_id", Value: user_id},
+		{Key: "blocked_id", Value: blocked_id},
+	}
+	update := bson.D{{
+		Key:   "$setOnInsert",
+		Value: Block{UserId: user_id, BlockedId: blocked_id, CreatedAt: time.Now().UTC().Truncate(time.Millisecond)},
+	}}
+
+	_, err = c.blocks.UpdateOne(context.TODO(), filter, update, options.Update().SetUpsert(true))
+	if err != nil {
+		return &errors.InternarMongoError{Err: err}
+	}
+
+	pending := bson.D{
+		{Key: "source_id", Value: blocked_id},
+		{Key: "target_id", Value: user_id},
+		{Key: "state", Value: PENDING},
+	}
+	reject := bson.D{{
+		Key: "$set",
+		Value: bson.D{
+			{Key: "state", Value: REJECTED},
+			{Key: "updated_at", Value: time.Now().UTC().Truncate(time.Millisecond)},
+		},
+	}}
+
+	_, err = c.friendRequests.UpdateMany(context.TODO(), pending, reject)
+	if err != nil {
+		return &errors.InternarMongoError{Err: err}
+	}
+
+	return nil
+}
+
+func (c *Connector) UnblockUser(user_id, blocked_id int) error {
+	filter := bson.D{
+		{Key: "user_id", Value: user_id},
+		{Key: "blocked_id", Value: blocked_id},
+	}
+
+	_, err := c.blocks.DeleteOne(context.TODO(), filter)
+	if err != nil {
+		return &errors.InternarMongoError{Err: err}
+	}
+
+	return nil
+}
+
+func (c *Connector) BlockedUsers(user_id int) ([]int, error) {
+	err := c.CheckIds([]int{user_id})
+	if err != nil {
+		return []int{}, err
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "blocked_id", Value: 1}})
+	cursor, err := c.blocks.Find(context.TODO(), bson.D{{Key: "user_id", Value: user_id}}, opts)
+	if err != nil {
+		return []int{}, &errors.InternarMongoError{Err: err}
+	}
+
+	var blocks []Block
+	err = cursor.All(context.TODO(), &blocks)
+	if err != nil {
+		return []int{}, &errors.InternarMongoError{Err: err}
+	}
+
+	result := make([]int, 0, len(blocks))
+	for _, block := range blocks {
+		result = append(result, block.BlockedId)
+	}
+
+	return result, nil
+}
+
+// isBlocked reports whether either of the users has blocked the other one.
+func (c *Connector) isBlocked(source_id, target_id int) (bool, error) {
+	filter := bson.D{{Key: "$or", Value: bson.A{
+		bson.D{{Key: "user_id", Value: target_id}, {Key: "blocked_id", Value: source_id}},
+		bson.D{{Key: "user_id", Value: source_id}, {Key: "blocked_id", Value: target_id}},
+	}}}
+
+	count, err := c.blocks.CountDocuments(context.TODO(), filter)
+	if err != nil {
+		return false, &errors.InternarMongoError{Err: err}
+	}
+
+	return count > 0, nil
+}
@@ -0,0 +1,242 @@
+package mongogo
+
+import (
+	"context"
+	"gin-server/internal/errors"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// SuggestionsQuery limits friend suggestions: Depth is the maximum number of hops
+// (2 or 3), MaxAge 0 means no upper age bound, Limit 0 means no limit.
+type SuggestionsQuery struct {
+	Depth  int
+	MinAge int
+	MaxAge int
+	Limit  int
+}
+
+type Suggestion struct {
+	User          User `json:"user"`
+	MutualFriends int  `json:"mutual_friends"`
+	Hops          int  `json:"hops"`
+}
+
+// Suggestions returns users within query.Depth hops of the friend graph who are
+// not friends of user_id yet, ranked by the number of mutual friends.
+func (c *Connector) Suggestions(user_id int, query SuggestionsQuery) ([]Suggestion, error) {
+	err := c.CheckIds([]int{user_id})
+	if err != nil {
+		return []Suggestion{}, err
+	}
+
+	age := bson.D{{Key: "$gte", Value: query.MinAge}}
+	if query.MaxAge > 0 {
+		age = append(age, bson.E{Key: "$lte", Value: query.MaxAge})
+	}
+
+	pipeline := bson.A{
+		bson.D{{Key: "$match", Value: bson.D{{Key: "id", Value: user_id}}}},
+		bson.D{{Key: "$graphLookup", Value: bson.D{
+			{Key: "from", Value: USERS},
+			{Key: "startWith", Value: "$friends"},
+			{Key: "connectFromField", Value: "friends"},
+			{Key: "connectToField", Value: "id"},
+			{Key: "as", Value: "network"},
+			{Key: "maxDepth", Value: query.Depth - 1},
+			{Key: "depthField", Value: "depth"},
+			{Key: "restrictSearchWithMatch", Value: alive(bson.D{})},
+		}}},
+		bson.D{{Key: "$unwind", Value: "$network"}},
+		bson.D{{Key: "$match", Value: bson.D{
+			{Key: "network.age", Value: age},
+			{Key: "$expr", Value: bson.D{{Key: "$and", Value: bson.A{
+				bson.D{{Key: "$ne", Value: bson.A{"$network.id", "$id"}}},
+				bson.D{{Key: "$not", Value: bson.A{
+					bson.D{{Key: "$in", Value: bson.A{"$network.id", "$friends"}}},
+				}}},
+			}}}},
+		}}},
+		bson.D{{Key: "$project", Value: bson.D{
+			{Key: "_id", Value: 0},
+			{Key: "user", Value: "$network"},
+			{Key: "mutualfriends", Value: bson.D{{Key: "$size", Value: bson.D{
+				{Key: "$setIntersection", Value: bson.A{"$friends", "$network.friends"}},
+			}}}},
+			{Key: "hops", Value: bson.D{{Key: "$add", Value: bson.A{"$network.depth", 1}}}},
+		}}},
+		bson.D{{Key: "$sort", Value: bson.D{
+			{Key: "mutualfriends", Value: -1},
+			{Key: "hops", Value: 1},
+			{Key: "user.id", Value: 1},
+		}}},
+	}
+	if query.Limit > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$limit", Value: query.Limit}})
+	}
+
+	cursor, err := c.users.Aggregate(context.TODO(), pipeline)
+	if err != nil {
+		return []Suggestion{}, &errors.InternarMongoError{Err: err}
+	}
+
+	result := []Suggestion{}
+	err = cursor.All(context.TODO(), &result)
+	if err != nil {
+		return []Suggestion{}, &errors.InternarMongoError{Err: err}
+	}
+
+	return result, nil
+}
+
+// MutualFriends returns the users that are in the friend lists of both users.
+func (c *Connector) MutualFriends(user_id, other_id int) ([]User, error) {
+	user, err := c.GetUser(user_id)
+	if err != nil {
+		return []User{}, err
+	}
+
+	other, err := c.GetUser(other_id)
+	if err != nil {
+		return []User{}, err
+	}
+
+	return c.findUsers(Intersect(user.Friends, other.Friends))
+}
+
+// FriendPath returns the shortest friendship chain from user_id to other_id,
+// both included, looking at most maxDepth hops away.
+func (c *Connector) FriendPath(user_id, other_id, maxDepth int) ([]User, error) {
+	err := c.CheckIds([]int{user_id})
+	if err != nil {
+		return []User{}, err
+	}
+	err = c.CheckIds([]int{other_id})
+	if err != nil {
+		return []User{}, err
+	}
+
+	ids, err := ShortestPath(user_id, other_id, maxDepth, c.friendLists)
+	if err != nil {
+		return []User{}, err
+	}
+
+	users, err := c.findUsers(ids)
+	if err != nil {
+		return []User{}, err
+	}
+
+	byId := make(map[int]User, len(users))
+	for _, user := range users {
+		byId[user.Id] = user
+	}
+
+	result := make([]User, 0, len(ids))
+	for _, id := range ids {
+		result = append(result, byId[id])
+	}
+
+	return result, nil
+}
+
+// ShortestPath runs a breadth first search from source to target, friendLists
+// returns the friend lists of one level of the search at a time.
+func ShortestPath(source, target, maxDepth int, friendLists func([]int) (map[int][]int, error)) ([]int, error) {
+	if source == target {
+		return []int{source}, nil
+	}
+
+	parents := map[int]int{source: source}
+	frontier := []int{source}
+
+	for depth := 0; depth < maxDepth && len(frontier) > 0; depth++ {
+		lists, err := friendLists(frontier)
+		if err != nil {
+			return nil, err
+		}
+
+		var next []int
+		for _, id := range frontier {
+			for _, friend := range lists[id] {
+				if _, seen := parents[friend]; seen {
+					continue
+				}
+
+				parents[friend] = id
+				if friend == target {
+					return chain(parents, source, target), nil
+				}
+
+				next = append(next, friend)
+			}
+		}
+
+		frontier = next
+	}
+
+	return nil, &errors.PathNotFound{SourceId: source, TargetId: target, MaxDepth: maxDepth}
+}
+
+func Intersect(a, b []int) []int {
+	inB := make(map[int]bool, len(b))
+	for _, id := range b {
+		inB[id] = true
+	}
+
+	result := []int{}
+	for _, id := range a {
+		if inB[id] {
+			result = append(result, id)
+			delete(inB, id)
+		}
+	}
+
+	return result
+}
+
+func chain(parents map[int]int, source, target int) []int {
+	ids := []int{target}
+	for id := target; id != source; {
+		id = parents[id]
+		ids = append([]int{id}, ids...)
+	}
+
+	return ids
+}
+
+func (c *Connector) friendLists(user_ids []int) (map[int][]int, error) {
+	users, err := c.findUsers(user_ids)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[int][]int, len(users))
+	for _, user := range users {
+		result[user.Id] = user.Friends
+	}
+
+	return result, nil
+}
+
+func (c *Connector) findUsers(user_ids []int) ([]User, error) {
+	filter := alive(bson.D{{
+		Key: "id",
+		Value: bson.D{{
+			Key:   "$in",
+			Value: user_ids,
+		}},
+	}})
+
+	cursor, err := c.users.Find(context.TODO(), filter)
+	if err != nil {
+		return []User{}, &errors.InternarMongoError{Err: err}
+	}
+
+	result := []User{}
+	err = cursor.All(context.TODO(), &result)
+	if err != nil {
+		return []User{}, &errors.InternarMongoError{Err: err}
+	}
+
+	return result, nil
+}
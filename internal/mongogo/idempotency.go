@@ -0,0 +1,82 @@
+package mongogo
+
+import (
+	"context"
+	"gin-server/internal/errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// IDEMPOTENCY_TTL is how long a stored response is replayed for its key.
+const IDEMPOTENCY_TTL time.Duration = 24 * time.Hour
+
+// IdempotencyRecord is the response stored for an Idempotency-Key. A record
+// without Status belongs to a request that is still being handled.
+type IdempotencyRecord struct {
+	Key         string    `json:"key" bson:"key"`
+	Hash        string    `json:"hash" bson:"hash"`
+	Status      int       `json:"status,omitempty" bson:"status,omitempty"`
+	ContentType string    `json:"content_type,omitempty" bson:"content_type,omitempty"`
+	Body        []byte    `json:"body,omitempty" bson:"body,omitempty"`
+	CreatedAt   time.Time `json:"created_at" bson:"created_at"`
+}
+
+// ReserveKey stores a pending record for the key and reports true, or returns
+// the record already stored for it and false.
+func (c *Connector) ReserveKey(key, hash string) (IdempotencyRecord, bool, error) {
+	record := IdempotencyRecord{
+		Key:       key,
+		Hash:      hash,
+		CreatedAt: time.Now().UTC().Truncate(time.Millisecond),
+	}
+
+	// the stored record may expire between the failed insert and the lookup,
+	// so try once more before giving up
+	for try := 0; try < 2; try++ {
+		_, err := c.idempotency.InsertOne(context.TODO(), record)
+		if err == nil {
+			return record, true, nil
+		} else if !mongo.IsDuplicateKeyError(err) {
+			return IdempotencyRecord{}, false, &errors.InternarMongoError{Err: err}
+		}
+
+		var stored IdempotencyRecord
+		err = c.idempotency.FindOne(context.TODO(), bson.D{{Key: "key", Value: key}}).Decode(&stored)
+		if err == nil {
+			return stored, false, nil
+		} else if err != mongo.ErrNoDocuments {
+			return IdempotencyRecord{}, false, &errors.InternarMongoError{Err: err}
+		}
+	}
+
+	return IdempotencyRecord{}, false, &errors.KeyInProgress{Key: key}
+}
+
+// CompleteKey stores the response of the request the key was reserved for.
+func (c *Connector) CompleteKey(key string, status int, contentType string, body []byte) error {
+	filter := bson.D{{Key: "key", Value: key}}
+	update := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "status", Value: status},
+		{Key: "content_type", Value: contentType},
+		{Key: "body", Value: body},
+	}}}
+
+	_, err := c.idempotency.UpdateOne(context.TODO(), filter, update)
+	if err != nil {
+		return &errors.InternarMongoError{Err: err}
+	}
+
+	return nil
+}
+
+// ReleaseKey forgets a key whose request failed, so that it can be retried.
+func (c *Connector) ReleaseKey(key string) error {
+	_, err := c.idempotency.DeleteOne(context.TODO(), bson.D{{Key: "key", Value: key}})
+	if err != nil {
+		return &errors.InternarMongoError{Err: err}
+	}
+
+	return nil
+}
@@ -0,0 +1,403 @@
+package mongogo
+
+import (
+	"context"
+	"gin-server/internal/errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const DUPLICATE_KEY int = 11000
+
+// EMAIL_INDEX is the name mongodb gave the unique email index of migration 2.
+const EMAIL_INDEX string = "email_1"
+
+// Migration upgrades stored documents or indexes. Up must be safe to run again,
+// because servers started at the same time may both apply it.
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(c *Connector) error
+}
+
+type AppliedMigration struct {
+	Version     int       `json:"version" bson:"version"`
+	Description string    `json:"description" bson:"description"`
+	AppliedAt   time.Time `json:"applied_at" bson:"applied_at"`
+}
+
+var Migrations = []Migration{
+	{
+		Version:     1,
+		Description: "user profile: display_name, created_at, updated_at and schema_version",
+		Up:          migrateProfile,
+	},
+	{
+		Version:     2,
+		Description: "indexes: unique user id and email, friends, friend requests and blocks",
+		Up:          migrateIndexes,
+	},
+	{
+		Version:     3,
+		Description: "case-insensitive collation index on user names for search",
+		Up:          migrateNameIndex,
+	},
+	{
+		Version:     4,
+		Description: "user version for optimistic concurrency",
+		Up:          migrateUserVersion,
+	},
+	{
+		Version:     5,
+		Description: "idempotency keys: unique key and expiry of stored responses",
+		Up:          migrateIdempotency,
+	},
+	{
+		Version:     6,
+		Description: "event outbox and subscriber cursors",
+		Up:          migrateOutbox,
+	},
+	{
+		Version:     7,
+		Description: "webhooks and the delivery log",
+		Up:          migrateWebhooks,
+	},
+	{
+		Version:     8,
+		Description: "audit log: lookups by actor, target and time",
+		Up:          migrateAudit,
+	},
+	{
+		Version:     9,
+		Description: "outbox: expiry of old events and lookups by user",
+		Up:          migrateOutboxRetention,
+	},
+	{
+		Version:     10,
+		Description: "audit log: lookups by claimed actor",
+		Up:          migrateClaimedActor,
+	},
+	{
+		Version:     11,
+		Description: "friend requests: one pending request per pair",
+		Up:          migratePendingRequests,
+	},
+}
+
+// Migrate applies the migrations that are not recorded in the migrations
+// collection yet, in version order, and returns the applied versions.
+func (c *Connector) Migrate() ([]int, error) {
+	migrations := c.client.Database(DATABASE).Collection(MIGRATIONS)
+
+	_, err := migrations.Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "version", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return nil, &errors.InternarMongoError{Err: err}
+	}
+
+	cursor, err := migrations.Find(context.TODO(), bson.D{})
+	if err != nil {
+		return nil, &errors.InternarMongoError{Err: err}
+	}
+
+	var done []AppliedMigration
+	err = cursor.All(context.TODO(), &done)
+	if err != nil {
+		return nil, &errors.InternarMongoError{Err: err}
+	}
+
+	applied := make(map[int]bool, len(done))
+	for _, migration := range done {
+		applied[migration.Version] = true
+	}
+
+	result := []int{}
+	for _, migration := range Migrations {
+		if applied[migration.Version] {
+			continue
+		}
+
+		err := migration.Up(c)
+		if err != nil {
+			return result, err
+		}
+
+		filter := bson.D{{Key: "version", Value: migration.Version}}
+		update := bson.D{{Key: "$setOnInsert", Value: AppliedMigration{
+			Version:     migration.Version,
+			Description: migration.Description,
+			AppliedAt:   time.Now().UTC().Truncate(time.Millisecond),
+		}}}
+
+		_, err = migrations.UpdateOne(context.TODO(), filter, update, options.Update().SetUpsert(true))
+		if err != nil && !mongo.IsDuplicateKeyError(err) {
+			return result, &errors.InternarMongoError{Err: err}
+		}
+
+		result = append(result, migration.Version)
+	}
+
+	return result, nil
+}
+
+// migrateProfile brings users created before the profile schema to version 1,
+// their creation time is unknown and set to the time of the migration.
+func migrateProfile(c *Connector) error {
+	now := time.Now().UTC().Truncate(time.Millisecond)
+
+	filter := bson.D{{Key: "schema_version", Value: bson.D{{Key: "$exists", Value: false}}}}
+	update := bson.A{
+		bson.D{{Key: "$set", Value: bson.D{
+			{Key: "display_name", Value: bson.D{{Key: "$ifNull", Value: bson.A{"$display_name", "$name"}}}},
+			{Key: "friends", Value: bson.D{{Key: "$ifNull", Value: bson.A{"$friends", bson.A{}}}}},
+			{Key: "created_at", Value: bson.D{{Key: "$ifNull", Value: bson.A{"$created_at", now}}}},
+			{Key: "updated_at", Value: now},
+			{Key: "schema_version", Value: 1},
+		}}},
+	}
+
+	_, err := c.users.UpdateMany(context.TODO(), filter, update)
+	if err != nil {
+		return &errors.InternarMongoError{Err: err}
+	}
+
+	return nil
+}
+
+func migrateIndexes(c *Connector) error {
+	indexes := map[*mongo.Collection][]mongo.IndexModel{
+		c.users: {
+			{
+				Keys:    bson.D{{Key: "id", Value: 1}},
+				Options: options.Index().SetUnique(true),
+			},
+			{
+				Keys: bson.D{{Key: "email", Value: 1}},
+				Options: options.Index().SetUnique(true).SetPartialFilterExpression(
+					bson.D{{Key: "email", Value: bson.D{{Key: "$type", Value: "string"}}}},
+				),
+			},
+			{
+				Keys: bson.D{{Key: "friends", Value: 1}},
+			},
+			{
+				Keys: bson.D{{Key: "deleted_at", Value: 1}},
+				Options: options.Index().SetPartialFilterExpression(
+					bson.D{{Key: "deleted_at", Value: bson.D{{Key: "$exists", Value: true}}}},
+				),
+			},
+		},
+		c.counters: {
+			{
+				Keys:    bson.D{{Key: "name", Value: 1}},
+				Options: options.Index().SetUnique(true),
+			},
+		},
+		c.friendRequests: {
+			{Keys: bson.D{{Key: "id", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "target_id", Value: 1}, {Key: "state", Value: 1}}},
+			{Keys: bson.D{{Key: "source_id", Value: 1}, {Key: "state", Value: 1}}},
+		},
+		c.blocks: {
+			{
+				Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "blocked_id", Value: 1}},
+				Options: options.Index().SetUnique(true),
+			},
+		},
+	}
+
+	for collection, models := range indexes {
+		_, err := collection.Indexes().CreateMany(context.TODO(), models)
+		if err != nil {
+			return &errors.InternarMongoError{Err: err}
+		}
+	}
+
+	return nil
+}
+
+func migrateNameIndex(c *Connector) error {
+	_, err := c.users.Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "name", Value: 1}, {Key: "id", Value: 1}},
+		Options: options.Index().SetCollation(NameCollation),
+	})
+	if err != nil {
+		return &errors.InternarMongoError{Err: err}
+	}
+
+	return nil
+}
+
+func migrateUserVersion(c *Connector) error {
+	filter := bson.D{{Key: "version", Value: bson.D{{Key: "$exists", Value: false}}}}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "version", Value: 1}}}}
+
+	_, err := c.users.UpdateMany(context.TODO(), filter, update)
+	if err != nil {
+		return &errors.InternarMongoError{Err: err}
+	}
+
+	return nil
+}
+
+func migrateIdempotency(c *Connector) error {
+	_, err := c.idempotency.Indexes().CreateMany(context.TODO(), []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "key", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "created_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(int32(IDEMPOTENCY_TTL / time.Second)),
+		},
+	})
+	if err != nil {
+		return &errors.InternarMongoError{Err: err}
+	}
+
+	return nil
+}
+
+// migrateOutbox also creates the collections, transactions on older servers
+// can not create them on the first write.
+func migrateOutbox(c *Connector) error {
+	indexes := map[*mongo.Collection]mongo.IndexModel{
+		c.outbox: {
+			Keys:    bson.D{{Key: "id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		c.eventCursors: {
+			Keys:    bson.D{{Key: "name", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+	}
+
+	for collection, model := range indexes {
+		_, err := collection.Indexes().CreateOne(context.TODO(), model)
+		if err != nil {
+			return &errors.InternarMongoError{Err: err}
+		}
+	}
+
+	return nil
+}
+
+func migrateWebhooks(c *Connector) error {
+	indexes := map[*mongo.Collection][]mongo.IndexModel{
+		c.webhooks: {
+			{Keys: bson.D{{Key: "id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		},
+		c.deliveries: {
+			{Keys: bson.D{{Key: "id", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{
+				Keys:    bson.D{{Key: "webhook_id", Value: 1}, {Key: "event.id", Value: 1}},
+				Options: options.Index().SetUnique(true),
+			},
+			{Keys: bson.D{{Key: "state", Value: 1}, {Key: "next_attempt", Value: 1}}},
+		},
+	}
+
+	for collection, models := range indexes {
+		_, err := collection.Indexes().CreateMany(context.TODO(), models)
+		if err != nil {
+			return &errors.InternarMongoError{Err: err}
+		}
+	}
+
+	return nil
+}
+
+func migrateAudit(c *Connector) error {
+	_, err := c.audit.Indexes().CreateMany(context.TODO(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "actor", Value: 1}, {Key: "id", Value: -1}}},
+		{Keys: bson.D{{Key: "targets", Value: 1}, {Key: "id", Value: -1}}},
+		{Keys: bson.D{{Key: "created_at", Value: 1}}},
+	})
+	if err != nil {
+		return &errors.InternarMongoError{Err: err}
+	}
+
+	return nil
+}
+
+func migrateOutboxRetention(c *Connector) error {
+	_, err := c.outbox.Indexes().CreateMany(context.TODO(), []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "created_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(int32(OUTBOX_RETENTION / time.Second)),
+		},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "id", Value: 1}}},
+	})
+	if err != nil {
+		return &errors.InternarMongoError{Err: err}
+	}
+
+	return nil
+}
+
+func migrateClaimedActor(c *Connector) error {
+	_, err := c.audit.Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "claimed_actor", Value: 1}, {Key: "id", Value: -1}},
+		Options: options.Index().SetSparse(true),
+	})
+	if err != nil {
+		return &errors.InternarMongoError{Err: err}
+	}
+
+	return nil
+}
+
+// migratePendingRequests cancels all but the first of the pending requests of
+// a pair, which concurrent sends could create, before it makes them unique.
+func migratePendingRequests(c *Connector) error {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.D{{Key: "state", Value: PENDING}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "id", Value: 1}}}},
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: bson.D{{Key: "source_id", Value: "$source_id"}, {Key: "target_id", Value: "$target_id"}}},
+			{Key: "ids", Value: bson.D{{Key: "$push", Value: "$id"}}},
+		}}},
+		{{Key: "$match", Value: bson.D{{Key: "ids.1", Value: bson.D{{Key: "$exists", Value: true}}}}}},
+	}
+
+	cursor, err := c.friendRequests.Aggregate(context.TODO(), pipeline)
+	if err != nil {
+		return &errors.InternarMongoError{Err: err}
+	}
+
+	var pairs []struct {
+		Ids []int `bson:"ids"`
+	}
+	err = cursor.All(context.TODO(), &pairs)
+	if err != nil {
+		return &errors.InternarMongoError{Err: err}
+	}
+
+	for _, pair := range pairs {
+		filter := bson.D{{Key: "id", Value: bson.D{{Key: "$in", Value: pair.Ids[1:]}}}}
+		update := bson.D{{Key: "$set", Value: bson.D{{Key: "state", Value: CANCELLED}}}}
+
+		_, err = c.friendRequests.UpdateMany(context.TODO(), filter, update)
+		if err != nil {
+			return &errors.InternarMongoError{Err: err}
+		}
+	}
+
+	_, err = c.friendRequests.Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+		Keys: bson.D{{Key: "source_id", Value: 1}, {Key: "target_id", Value: 1}},
+		Options: options.Index().SetUnique(true).SetPartialFilterExpression(
+			bson.D{{Key: "state", Value: PENDING}},
+		),
+	})
+	if err != nil {
+		return &errors.InternarMongoError{Err: err}
+	}
+
+	return nil
+}
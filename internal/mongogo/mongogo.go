@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"gin-server/internal/errors"
+	"strings"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -11,28 +13,70 @@ import (
 )
 
 type Connector struct {
-	url      string
-	client   *mongo.Client
-	users    *mongo.Collection
-	counters *mongo.Collection
+	url            string
+	client         *mongo.Client
+	users          *mongo.Collection
+	counters       *mongo.Collection
+	friendRequests *mongo.Collection
+	blocks         *mongo.Collection
+	idempotency    *mongo.Collection
+	outbox         *mongo.Collection
+	eventCursors   *mongo.Collection
+	webhooks       *mongo.Collection
+	deliveries     *mongo.Collection
+	audit          *mongo.Collection
+	transactions   bool
 }
 
 type User struct {
-	Id      int    `json:"id"`
-	Name    string `json:"name"`
-	Age     int    `json:"age"`
-	Friends []int  `json:"friends"`
+	Id            int        `json:"id" bson:"id"`
+	Name          string     `json:"name" bson:"name"`
+	Age           int        `json:"age" bson:"age"`
+	Friends       []int      `json:"friends" bson:"friends"`
+	Email         string     `json:"email,omitempty" bson:"email,omitempty"`
+	DisplayName   string     `json:"display_name,omitempty" bson:"display_name,omitempty"`
+	Bio           string     `json:"bio,omitempty" bson:"bio,omitempty"`
+	AvatarURL     string     `json:"avatar_url,omitempty" bson:"avatar_url,omitempty"`
+	CreatedAt     time.Time  `json:"created_at" bson:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at" bson:"updated_at"`
+	DeletedAt     *time.Time `json:"deleted_at,omitempty" bson:"deleted_at,omitempty"`
+	Version       int        `json:"version" bson:"version"`
+	SchemaVersion int        `json:"-" bson:"schema_version"`
+}
+
+// ProfileUpdate holds the profile fields to change, nil fields are left as they are.
+type ProfileUpdate struct {
+	Name        *string `json:"name"`
+	Age         *int    `json:"age"`
+	Email       *string `json:"email"`
+	DisplayName *string `json:"display_name"`
+	Bio         *string `json:"bio"`
+	AvatarURL   *string `json:"avatar_url"`
 }
 
 type Counter struct {
-	Name  string `json:"name"`
-	Value int    `json:"value"`
+	Name  string `json:"name" bson:"name"`
+	Value int    `json:"value" bson:"value"`
 }
 
+// incVersion is added to every update of a user, so that conditional updates
+// notice any change made in the meantime.
+var incVersion = bson.E{Key: "$inc", Value: bson.D{{Key: "version", Value: 1}}}
+
 const (
-	DATABASE string = "lesson31"
-	USERS    string = "users"
-	COUNTERS string = "counters"
+	DATABASE        string = "lesson31"
+	SCHEMA_VERSION  int    = 1
+	USERS           string = "users"
+	MIGRATIONS      string = "migrations"
+	COUNTERS        string = "counters"
+	FRIEND_REQUESTS string = "friend_requests"
+	BLOCKS          string = "blocks"
+	IDEMPOTENCY     string = "idempotency_keys"
+	OUTBOX          string = "outbox"
+	EVENT_CURSORS   string = "event_cursors"
+	WEBHOOKS        string = "webhooks"
+	DELIVERIES      string = "webhook_deliveries"
+	AUDIT_LOG       string = "audit_log"
 )
 
 func Init(url string) (Connector, error) {
@@ -52,6 +96,19 @@ func Init(url string) (Connector, error) {
 	conn.client = client
 	conn.users = client.Database(DATABASE).Collection(USERS)
 	conn.counters = client.Database(DATABASE).Collection(COUNTERS)
+	conn.friendRequests = client.Database(DATABASE).Collection(FRIEND_REQUESTS)
+	conn.blocks = client.Database(DATABASE).Collection(BLOCKS)
+	conn.idempotency = client.Database(DATABASE).Collection(IDEMPOTENCY)
+	conn.outbox = client.Database(DATABASE).Collection(OUTBOX)
+	conn.eventCursors = client.Database(DATABASE).Collection(EVENT_CURSORS)
+	conn.webhooks = client.Database(DATABASE).Collection(WEBHOOKS)
+	conn.deliveries = client.Database(DATABASE).Collection(DELIVERIES)
+	conn.audit = client.Database(DATABASE).Collection(AUDIT_LOG)
+
+	conn.transactions, err = supportsTransactions(client)
+	if err != nil {
+		return Connector{}, err
+	}
 
 	return conn, nil
 }
@@ -100,17 +157,32 @@ func (c *Connector) CounterPlusPlus(name string) error {
 }
 
 func (c *Connector) NewUser(name string, age int) (int, error) {
+	return c.CreateUser(User{Name: name, Age: age})
+}
+
+// CreateUser stores a new user with a fresh id and the profile of the given one.
+func (c *Connector) CreateUser(user User) (int, error) {
 	userId, err := c.GetCounter("user_id")
 	if err != nil {
 		return 0, &errors.InternarMongoError{Err: err}
 	}
 
-	_, err = c.users.InsertOne(
-		context.TODO(),
-		User{userId, name, age, []int{}})
+	user.Id = userId
+	user = NewProfile(user, time.Now())
+
+	err = c.inTransaction(func(ctx context.Context) error {
+		_, err := c.users.InsertOne(ctx, user)
 
+		if duplicateEmail(err) {
+			return &errors.EmailExists{Email: user.Email}
+		} else if err != nil {
+			return &errors.InternarMongoError{Err: err}
+		}
+
+		return c.publish(ctx, UserEvent(USER_CREATED, user))
+	})
 	if err != nil {
-		return 0, &errors.InternarMongoError{Err: err}
+		return 0, err
 	}
 
 	err = c.CounterPlusPlus("user_id")
@@ -121,25 +193,156 @@ func (c *Connector) NewUser(name string, age int) (int, error) {
 	return userId, nil
 }
 
-func (c *Connector) UpdateAge(user_id, newAge int) error {
+// duplicateEmail reports whether err is a clash on the unique email index. A
+// clash on the user id is not, it means the id counter was raced.
+func duplicateEmail(err error) bool {
+	return mongo.IsDuplicateKeyError(err) && onEmailIndex(err.Error())
+}
+
+// onEmailIndex reports whether a duplicate key message names the email index.
+func onEmailIndex(message string) bool {
+	return strings.Contains(message, " index: "+EMAIL_INDEX+" ")
+}
+
+// NewProfile fills in the fields a user gets on creation.
+func NewProfile(user User, now time.Time) User {
+	now = now.UTC().Truncate(time.Millisecond)
+
+	if user.Friends == nil {
+		user.Friends = []int{}
+	}
+	if user.DisplayName == "" {
+		user.DisplayName = user.Name
+	}
+	if user.CreatedAt.IsZero() {
+		user.CreatedAt = now
+	}
+	user.UpdatedAt = now
+	user.Version = 1
+	user.SchemaVersion = SCHEMA_VERSION
+
+	return user
+}
+
+// UpdateProfile changes the given profile fields and returns the updated user.
+// With a non-zero version the user is changed only if it still has that version.
+func (c *Connector) UpdateProfile(user_id, version int, update ProfileUpdate) (User, error) {
 	err := c.CheckIds([]int{user_id})
 	if err != nil {
-		return err
+		return User{}, err
+	}
+
+	set := bson.D{{Key: "updated_at", Value: time.Now().UTC().Truncate(time.Millisecond)}}
+	unset := bson.D{}
+
+	for _, field := range []struct {
+		key   string
+		value *string
+	}{
+		{"name", update.Name},
+		{"email", update.Email},
+		{"display_name", update.DisplayName},
+		{"bio", update.Bio},
+		{"avatar_url", update.AvatarURL},
+	} {
+		if field.value == nil {
+			continue
+		}
+
+		if *field.value == "" && field.key != "name" {
+			unset = append(unset, bson.E{Key: field.key, Value: ""})
+		} else {
+			set = append(set, bson.E{Key: field.key, Value: *field.value})
+		}
+	}
+	if update.Age != nil {
+		set = append(set, bson.E{Key: "age", Value: *update.Age})
+	}
+
+	changes := bson.D{{Key: "$set", Value: set}, incVersion}
+	if len(unset) > 0 {
+		changes = append(changes, bson.E{Key: "$unset", Value: unset})
+	}
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var result User
+	err = c.inTransaction(func(ctx context.Context) error {
+		err := c.users.FindOneAndUpdate(ctx, versioned(user_id, version), changes, opts).Decode(&result)
+
+		if duplicateEmail(err) && update.Email != nil {
+			return &errors.EmailExists{Email: *update.Email}
+		} else if err == mongo.ErrNoDocuments {
+			return c.versionConflict(user_id, version)
+		} else if err != nil {
+			return &errors.InternarMongoError{Err: err}
+		}
+
+		return c.publish(ctx, UserEvent(USER_UPDATED, result))
+	})
+	if err != nil {
+		return User{}, err
+	}
+
+	return result, nil
+}
+
+// UpdateAge sets the age of the user and returns the updated user. With a
+// non-zero version the user is changed only if it still has that version.
+func (c *Connector) UpdateAge(user_id, newAge, version int) (User, error) {
+	err := c.CheckIds([]int{user_id})
+	if err != nil {
+		return User{}, err
 	}
 
-	filter := bson.D{{Key: "id", Value: user_id}}
 	update := bson.D{{
-		Key:   "$set",
-		Value: bson.D{{Key: "age", Value: newAge}},
-	}}
+		Key: "$set",
+		Value: bson.D{
+			{Key: "age", Value: newAge},
+			{Key: "updated_at", Value: time.Now().UTC().Truncate(time.Millisecond)},
+		},
+	}, incVersion}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
 
-	_, err = c.users.UpdateOne(context.TODO(), filter, update)
+	var result User
+	err = c.inTransaction(func(ctx context.Context) error {
+		err := c.users.FindOneAndUpdate(ctx, versioned(user_id, version), update, opts).Decode(&result)
+
+		if err == mongo.ErrNoDocuments {
+			return c.versionConflict(user_id, version)
+		} else if err != nil {
+			return &errors.InternarMongoError{Err: err}
+		}
 
+		return c.publish(ctx, UserEvent(USER_UPDATED, result))
+	})
 	if err != nil {
-		return &errors.InternarMongoError{Err: err}
+		return User{}, err
 	}
 
-	return nil
+	return result, nil
+}
+
+// versioned matches the alive user with the given id and, if version is not
+// zero, with that version.
+func versioned(user_id, version int) bson.D {
+	filter := alive(bson.D{{Key: "id", Value: user_id}})
+	if version != 0 {
+		filter = append(filter, bson.E{Key: "version", Value: version})
+	}
+
+	return filter
+}
+
+// versionConflict explains why a versioned update matched nothing: the user is
+// gone or has been changed in the meantime.
+func (c *Connector) versionConflict(user_id, version int) error {
+	user, err := c.GetUser(user_id)
+	if err != nil {
+		return err
+	}
+
+	return &errors.VersionMismatch{Id: user_id, Expected: version, Actual: user.Version}
 }
 
 func (c *Connector) AddFriend(user_id, friend_id int) error {
@@ -153,22 +356,50 @@ func (c *Connector) AddFriend(user_id, friend_id int) error {
 		return err
 	}
 
-	filter := bson.D{{Key: "id", Value: friend_id}}
+	blocked, err := c.isBlocked(user_id, friend_id)
+	if err != nil {
+		return err
+	}
+	if blocked {
+		return &errors.UserBlocked{SourceId: user_id, TargetId: friend_id}
+	}
+
+	return c.inTransaction(func(ctx context.Context) error {
+		return c.addFriend(ctx, user_id, friend_id)
+	})
+}
+
+// addFriend puts user_id in the friend list of friend_id and publishes the
+// event, within the transaction of ctx if any. It reports FriendsExists when
+// user_id is in the list already.
+func (c *Connector) addFriend(ctx context.Context, user_id, friend_id int) error {
+	filter := bson.D{
+		{Key: "id", Value: friend_id},
+		{Key: "friends", Value: bson.D{{Key: "$ne", Value: user_id}}},
+	}
 	update := bson.D{{
 		Key:   "$push",
 		Value: bson.D{{Key: "friends", Value: user_id}},
-	}}
-
-	_, err = c.users.UpdateOne(context.TODO(), filter, update)
+	}, incVersion}
 
+	result, err := c.users.UpdateOne(ctx, filter, update)
 	if err != nil {
 		return &errors.InternarMongoError{Err: err}
 	}
+	if result.MatchedCount == 0 {
+		return &errors.FriendsExists{SourceId: user_id, TargetId: friend_id}
+	}
 
-	return nil
+	return c.publish(ctx, FriendEvent(FRIEND_ADDED, friend_id, user_id))
 }
 
+// FriendExists reports FriendsExists if user_id is in the friend list of friend_id,
+// friendships of soft deleted users are hidden.
 func (c *Connector) FriendExists(user_id, friend_id int) error {
+	if _, ok := c.CheckIds([]int{user_id, friend_id}).(*errors.UndefinedIndexes); ok {
+		return nil
+	}
+
 	filter := bson.D{
 		{Key: "id", Value: friend_id},
 		{Key: "friends", Value: user_id},
@@ -187,45 +418,162 @@ func (c *Connector) FriendExists(user_id, friend_id int) error {
 }
 
 func (c *Connector) DelFriend(user_id, friend_id int) error {
-	filter := bson.D{{Key: "id", Value: user_id}}
+	filter := bson.D{
+		{Key: "id", Value: user_id},
+		{Key: "friends", Value: friend_id},
+	}
 	update := bson.D{{
 		Key:   "$pull",
 		Value: bson.D{{Key: "friends", Value: friend_id}},
-	}}
-
-	_, err := c.users.UpdateOne(context.TODO(), filter, update)
+	}, incVersion}
 
-	if err != nil {
-		return &errors.InternarMongoError{Err: err}
-	}
+	return c.inTransaction(func(ctx context.Context) error {
+		result, err := c.users.UpdateOne(ctx, filter, update)
+		if err != nil {
+			return &errors.InternarMongoError{Err: err}
+		}
+		if result.ModifiedCount == 0 {
+			return nil
+		}
 
-	return nil
+		return c.publish(ctx, FriendEvent(FRIEND_REMOVED, user_id, friend_id))
+	})
 }
 
+// DelUser soft deletes the user: it is hidden from reads, but keeps its
+// friendships until PurgeUsers removes it for good.
 func (c *Connector) DelUser(user_id int) (string, error) {
 	user, err := c.GetUser(user_id)
 	if err != nil {
 		return "", err
 	}
 
-	filter := bson.D{{Key: "id", Value: user_id}}
+	filter := alive(bson.D{{Key: "id", Value: user_id}})
+	update := bson.D{{
+		Key:   "$set",
+		Value: bson.D{{Key: "deleted_at", Value: time.Now().UTC().Truncate(time.Millisecond)}},
+	}, incVersion}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	err = c.inTransaction(func(ctx context.Context) error {
+		err := c.users.FindOneAndUpdate(ctx, filter, update, opts).Decode(&user)
+		if err == mongo.ErrNoDocuments {
+			return &errors.UndefinedIndexes{Indexes: []int{user_id}}
+		} else if err != nil {
+			return &errors.InternarMongoError{Err: err}
+		}
+
+		return c.publish(ctx, UserEvent(USER_DELETED, user))
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return user.Name, nil
+}
+
+// RestoreUser brings back a soft deleted user together with its friendships.
+func (c *Connector) RestoreUser(user_id int) (User, error) {
+	filter := bson.D{
+		{Key: "id", Value: user_id},
+		{Key: "deleted_at", Value: bson.D{{Key: "$exists", Value: true}}},
+	}
+	update := bson.D{{
+		Key:   "$unset",
+		Value: bson.D{{Key: "deleted_at", Value: ""}},
+	}, incVersion}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var result User
+	err := c.inTransaction(func(ctx context.Context) error {
+		err := c.users.FindOneAndUpdate(ctx, filter, update, opts).Decode(&result)
+		if err == mongo.ErrNoDocuments {
+			return &errors.UndefinedIndexes{Indexes: []int{user_id}}
+		} else if err != nil {
+			return &errors.InternarMongoError{Err: err}
+		}
+
+		return c.publish(ctx, UserEvent(USER_UPDATED, result))
+	})
+	if err != nil {
+		return User{}, err
+	}
+
+	return result, nil
+}
+
+// PurgeUsers hard deletes users soft deleted before the given time and removes
+// them from the friend lists they are in.
+func (c *Connector) PurgeUsers(before time.Time) (int, error) {
+	filter := bson.D{{Key: "deleted_at", Value: bson.D{{Key: "$lt", Value: before}}}}
+
+	cursor, err := c.users.Find(context.TODO(), filter)
+	if err != nil {
+		return 0, &errors.InternarMongoError{Err: err}
+	}
 
-	_, err = c.users.DeleteOne(context.TODO(), filter)
+	var purged []User
+	err = cursor.All(context.TODO(), &purged)
 	if err != nil {
-		return "", &errors.InternarMongoError{Err: err}
+		return 0, &errors.InternarMongoError{Err: err}
+	}
+	if len(purged) == 0 {
+		return 0, nil
+	}
+
+	ids := make([]int, 0, len(purged))
+	for _, user := range purged {
+		ids = append(ids, user.Id)
 	}
 
 	update := bson.D{{
 		Key:   "$pull",
-		Value: bson.D{{Key: "friends", Value: user_id}},
+		Value: bson.D{{Key: "friends", Value: bson.D{{Key: "$in", Value: ids}}}},
+	}, incVersion}
+	friendsFilter := bson.D{{
+		Key:   "friends",
+		Value: bson.D{{Key: "$in", Value: ids}},
 	}}
 
-	_, err = c.users.UpdateMany(context.TODO(), bson.D{{}}, update)
+	err = c.inTransaction(func(ctx context.Context) error {
+		_, err := c.users.DeleteMany(ctx, bson.D{{
+			Key:   "id",
+			Value: bson.D{{Key: "$in", Value: ids}},
+		}})
+		if err != nil {
+			return &errors.InternarMongoError{Err: err}
+		}
+
+		cursor, err := c.users.Find(ctx, friendsFilter)
+		if err != nil {
+			return &errors.InternarMongoError{Err: err}
+		}
+
+		var holders []User
+		err = cursor.All(ctx, &holders)
+		if err != nil {
+			return &errors.InternarMongoError{Err: err}
+		}
+
+		_, err = c.users.UpdateMany(ctx, friendsFilter, update)
+		if err != nil {
+			return &errors.InternarMongoError{Err: err}
+		}
+
+		var events []Event
+		for _, holder := range holders {
+			for _, id := range Intersect(holder.Friends, ids) {
+				events = append(events, FriendEvent(FRIEND_REMOVED, holder.Id, id))
+			}
+		}
+
+		return c.publish(ctx, events...)
+	})
 	if err != nil {
-		return "", &errors.InternarMongoError{Err: err}
+		return 0, err
 	}
 
-	return user.Name, nil
+	return len(ids), nil
 }
 
 func (c *Connector) GetFriends(user_id int) ([]User, error) {
@@ -234,13 +582,13 @@ func (c *Connector) GetFriends(user_id int) ([]User, error) {
 		return []User{}, err
 	}
 
-	filter := bson.D{{
+	filter := alive(bson.D{{
 		Key: "id",
 		Value: bson.D{{
 			Key:   "$in",
 			Value: user.Friends,
 		}},
-	}}
+	}})
 
 	cursor, err := c.users.Find(context.TODO(), filter)
 	if err != nil {
@@ -270,7 +618,7 @@ func (c *Connector) GetUser(user_id int) (User, error) {
 	}
 
 	var result User
-	filter := bson.D{{Key: "id", Value: user_id}}
+	filter := alive(bson.D{{Key: "id", Value: user_id}})
 	err = c.users.FindOne(context.TODO(), filter).Decode(&result)
 
 	if err != nil {
@@ -280,14 +628,38 @@ func (c *Connector) GetUser(user_id int) (User, error) {
 	return result, nil
 }
 
+// GetUsers returns the users with the given ids that exist, in no particular order.
+func (c *Connector) GetUsers(user_ids []int) ([]User, error) {
+	filter := alive(bson.D{{
+		Key: "id",
+		Value: bson.D{{
+			Key:   "$in",
+			Value: user_ids,
+		}},
+	}})
+
+	cursor, err := c.users.Find(context.TODO(), filter)
+	if err != nil {
+		return []User{}, &errors.InternarMongoError{Err: err}
+	}
+
+	result := []User{}
+	err = cursor.All(context.TODO(), &result)
+	if err != nil {
+		return []User{}, &errors.InternarMongoError{Err: err}
+	}
+
+	return result, nil
+}
+
 func (c *Connector) CheckIds(user_ids []int) error {
-	filter := bson.D{{
+	filter := alive(bson.D{{
 		Key: "id",
 		Value: bson.D{{
 			Key:   "$in",
 			Value: user_ids,
 		}},
-	}}
+	}})
 
 	cursor, err := c.users.Find(context.TODO(), filter)
 	if err != nil {
@@ -303,3 +675,8 @@ func (c *Connector) CheckIds(user_ids []int) error {
 		return &errors.UndefinedIndexes{Indexes: user_ids}
 	}
 }
+
+// alive narrows a users filter down to users that are not soft deleted.
+func alive(filter bson.D) bson.D {
+	return append(filter, bson.E{Key: "deleted_at", Value: bson.D{{Key: "$exists", Value: false}}})
+}
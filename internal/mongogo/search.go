@@ -0,0 +1,79 @@
+package mongogo
+
+import (
+	"context"
+	"gin-server/internal/errors"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SEARCH_SORT lists the fields users can be sorted by in a search.
+var SEARCH_SORT = []string{"name", "age", "id", "created_at"}
+
+// NameCollation compares names ignoring case, the users name index is built with it.
+var NameCollation = &options.Collation{Locale: "en", Strength: 2}
+
+// SearchQuery filters users by a case-insensitive name prefix and an age range,
+// MaxAge 0 means no upper bound.
+type SearchQuery struct {
+	Text       string
+	MinAge     int
+	MaxAge     int
+	SortField  string
+	Descending bool
+	Skip       int
+	Limit      int
+}
+
+// SearchUsers returns one page of matching users and the number of all matches.
+func (c *Connector) SearchUsers(query SearchQuery) ([]User, int, error) {
+	age := bson.D{{Key: "$gte", Value: query.MinAge}}
+	if query.MaxAge > 0 {
+		age = append(age, bson.E{Key: "$lte", Value: query.MaxAge})
+	}
+
+	filter := alive(bson.D{{Key: "age", Value: age}})
+	if query.Text != "" {
+		// U+FFFF has the highest primary weight in ICU collations, so with
+		// NameCollation the range matches every name starting with query.Text.
+		filter = append(filter, bson.E{Key: "name", Value: bson.D{
+			{Key: "$gte", Value: query.Text},
+			{Key: "$lt", Value: query.Text + "\uffff"},
+		}})
+	}
+
+	total, err := c.users.CountDocuments(context.TODO(), filter, options.Count().SetCollation(NameCollation))
+	if err != nil {
+		return []User{}, 0, &errors.InternarMongoError{Err: err}
+	}
+
+	order := 1
+	if query.Descending {
+		order = -1
+	}
+
+	sort := bson.D{{Key: query.SortField, Value: order}}
+	if query.SortField != "id" {
+		sort = append(sort, bson.E{Key: "id", Value: 1})
+	}
+
+	opts := options.Find().
+		SetCollation(NameCollation).
+		SetSort(sort).
+		SetSkip(int64(query.Skip)).
+		SetLimit(int64(query.Limit))
+
+	cursor, err := c.users.Find(context.TODO(), filter, opts)
+	if err != nil {
+		return []User{}, 0, &errors.InternarMongoError{Err: err}
+	}
+
+	result := []User{}
+	err = cursor.All(context.TODO(), &result)
+	if err != nil {
+		return []User{}, 0, &errors.InternarMongoError{Err: err}
+	}
+
+	return result, int(total), nil
+}
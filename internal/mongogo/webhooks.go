@@ -0,0 +1,247 @@
+package mongogo
+
+import (
+	"context"
+	"gin-server/internal/errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DELIVERY_LEASE is how long a claimed delivery is left to its sender, it has
+// to outlast a post.
+const DELIVERY_LEASE time.Duration = time.Minute
+
+const (
+	DELIVERY_PENDING   string = "pending"
+	DELIVERY_DELIVERED string = "delivered"
+	DELIVERY_DEAD      string = "dead"
+)
+
+// Webhook receives the events listed in Events, or all events if it is empty.
+// The secret signs the payloads and is never returned by the api.
+type Webhook struct {
+	Id        int       `json:"id" bson:"id"`
+	URL       string    `json:"url" bson:"url"`
+	Secret    string    `json:"-" bson:"secret"`
+	Events    []string  `json:"events" bson:"events"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+}
+
+// Delivery is an event to be sent to a webhook and the log of the attempts.
+type Delivery struct {
+	Id          int       `json:"id" bson:"id"`
+	WebhookId   int       `json:"webhook_id" bson:"webhook_id"`
+	Event       Event     `json:"event" bson:"event"`
+	State       string    `json:"state" bson:"state"`
+	Attempts    int       `json:"attempts" bson:"attempts"`
+	NextAttempt time.Time `json:"next_attempt" bson:"next_attempt"`
+	LastStatus  int       `json:"last_status,omitempty" bson:"last_status,omitempty"`
+	LastError   string    `json:"last_error,omitempty" bson:"last_error,omitempty"`
+	CreatedAt   time.Time `json:"created_at" bson:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at" bson:"updated_at"`
+}
+
+// DeliveryQuery selects deliveries of a webhook, State is optional.
+type DeliveryQuery struct {
+	WebhookId int
+	State     string
+	Limit     int
+}
+
+// Wants reports whether the webhook receives events of the given type.
+func (w Webhook) Wants(eventType string) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+
+	for _, wanted := range w.Events {
+		if wanted == eventType {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *Connector) CreateWebhook(hook Webhook) (Webhook, error) {
+	id, err := c.nextIds(context.TODO(), "webhook_id", 1)
+	if err != nil {
+		return Webhook{}, err
+	}
+
+	hook.Id = id
+	hook.CreatedAt = time.Now().UTC().Truncate(time.Millisecond)
+	if hook.Events == nil {
+		hook.Events = []string{}
+	}
+
+	_, err = c.webhooks.InsertOne(context.TODO(), hook)
+	if err != nil {
+		return Webhook{}, &errors.InternarMongoError{Err: err}
+	}
+
+	return hook, nil
+}
+
+func (c *Connector) Webhooks() ([]Webhook, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "id", Value: 1}})
+
+	cursor, err := c.webhooks.Find(context.TODO(), bson.D{}, opts)
+	if err != nil {
+		return []Webhook{}, &errors.InternarMongoError{Err: err}
+	}
+
+	result := []Webhook{}
+	err = cursor.All(context.TODO(), &result)
+	if err != nil {
+		return []Webhook{}, &errors.InternarMongoError{Err: err}
+	}
+
+	return result, nil
+}
+
+// DeleteWebhook removes the webhook, its pending deliveries are dropped and
+// the log of the others is kept.
+func (c *Connector) DeleteWebhook(webhook_id int) error {
+	result, err := c.webhooks.DeleteOne(context.TODO(), bson.D{{Key: "id", Value: webhook_id}})
+	if err != nil {
+		return &errors.InternarMongoError{Err: err}
+	}
+	if result.DeletedCount == 0 {
+		return &errors.UndefinedWebhook{Id: webhook_id}
+	}
+
+	_, err = c.deliveries.DeleteMany(context.TODO(), bson.D{
+		{Key: "webhook_id", Value: webhook_id},
+		{Key: "state", Value: DELIVERY_PENDING},
+	})
+	if err != nil {
+		return &errors.InternarMongoError{Err: err}
+	}
+
+	return nil
+}
+
+// EnqueueDeliveries creates a pending delivery of the event for every webhook
+// that wants it. An event enqueued again does not get a second delivery.
+func (c *Connector) EnqueueDeliveries(event Event) error {
+	hooks, err := c.Webhooks()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+
+	for _, hook := range hooks {
+		if !hook.Wants(event.Type) {
+			continue
+		}
+
+		filter := bson.D{
+			{Key: "webhook_id", Value: hook.Id},
+			{Key: "event.id", Value: event.Id},
+		}
+		count, err := c.deliveries.CountDocuments(context.TODO(), filter)
+		if err != nil {
+			return &errors.InternarMongoError{Err: err}
+		}
+		if count > 0 {
+			continue
+		}
+
+		id, err := c.nextIds(context.TODO(), "delivery_id", 1)
+		if err != nil {
+			return err
+		}
+
+		_, err = c.deliveries.InsertOne(context.TODO(), Delivery{
+			Id:          id,
+			WebhookId:   hook.Id,
+			Event:       event,
+			State:       DELIVERY_PENDING,
+			NextAttempt: now,
+			CreatedAt:   now,
+			UpdatedAt:   now,
+		})
+		if err != nil && !mongo.IsDuplicateKeyError(err) {
+			return &errors.InternarMongoError{Err: err}
+		}
+	}
+
+	return nil
+}
+
+// DueDeliveries claims up to limit pending deliveries whose next attempt is
+// not after now, by moving their next attempt DELIVERY_LEASE ahead, so that
+// servers running at the same time do not send the same delivery.
+func (c *Connector) DueDeliveries(now time.Time, limit int) ([]Delivery, error) {
+	filter := bson.D{
+		{Key: "state", Value: DELIVERY_PENDING},
+		{Key: "next_attempt", Value: bson.D{{Key: "$lte", Value: now}}},
+	}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "next_attempt", Value: now.Add(DELIVERY_LEASE)}}}}
+	opts := options.FindOneAndUpdate().SetSort(bson.D{{Key: "id", Value: 1}})
+
+	result := []Delivery{}
+	for len(result) < limit {
+		var delivery Delivery
+
+		err := c.deliveries.FindOneAndUpdate(context.TODO(), filter, update, opts).Decode(&delivery)
+		if err == mongo.ErrNoDocuments {
+			break
+		} else if err != nil {
+			return result, &errors.InternarMongoError{Err: err}
+		}
+
+		result = append(result, delivery)
+	}
+
+	return result, nil
+}
+
+// SaveDelivery stores the outcome of an attempt.
+func (c *Connector) SaveDelivery(delivery Delivery) error {
+	delivery.UpdatedAt = time.Now().UTC().Truncate(time.Millisecond)
+
+	filter := bson.D{{Key: "id", Value: delivery.Id}}
+	update := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "state", Value: delivery.State},
+		{Key: "attempts", Value: delivery.Attempts},
+		{Key: "next_attempt", Value: delivery.NextAttempt},
+		{Key: "last_status", Value: delivery.LastStatus},
+		{Key: "last_error", Value: delivery.LastError},
+		{Key: "updated_at", Value: delivery.UpdatedAt},
+	}}}
+
+	_, err := c.deliveries.UpdateOne(context.TODO(), filter, update)
+	if err != nil {
+		return &errors.InternarMongoError{Err: err}
+	}
+
+	return nil
+}
+
+// Deliveries returns the newest deliveries of a webhook first.
+func (c *Connector) Deliveries(query DeliveryQuery) ([]Delivery, error) {
+	filter := bson.D{{Key: "webhook_id", Value: query.WebhookId}}
+	if query.State != "" {
+		filter = append(filter, bson.E{Key: "state", Value: query.State})
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "id", Value: -1}}).SetLimit(int64(query.Limit))
+
+	cursor, err := c.deliveries.Find(context.TODO(), filter, opts)
+	if err != nil {
+		return []Delivery{}, &errors.InternarMongoError{Err: err}
+	}
+
+	result := []Delivery{}
+	err = cursor.All(context.TODO(), &result)
+	if err != nil {
+		return []Delivery{}, &errors.InternarMongoError{Err: err}
+	}
+
+	return result, nil
+}
@@ -1,8 +1,11 @@
 package provider
 
+import "sync"
+
 type Hosts struct {
 	List   []string
 	active int
+	mu     sync.Mutex
 }
 
 func NewProvider() Hosts {
@@ -13,12 +16,27 @@ func NewProvider() Hosts {
 }
 
 func (h *Hosts) Add(host string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
 	h.List = append(h.List, host)
 }
 
+// GetHost returns the hosts in turn, it is called concurrently by the proxy.
 func (h *Hosts) GetHost() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
 	host := h.List[h.active]
 	h.active = (h.active + 1) % len(h.List)
 
 	return host
 }
+
+// All returns a copy of the host list.
+func (h *Hosts) All() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return append([]string{}, h.List...)
+}
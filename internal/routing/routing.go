@@ -0,0 +1,342 @@
+// Package routing chooses the backend pool of a proxied request from a table
+// of rules matching its path, method, headers and query.
+package routing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gin-server/internal/provider"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// DEFAULT_POOL serves the requests no rule matches.
+const DEFAULT_POOL string = "default"
+
+var ErrUnknownSplit = errors.New("unknown split")
+
+// Rule sends the requests it matches to Pool, which names a pool or a split.
+// Every condition that is set must hold: the path starts with Prefix, the
+// method is one of Methods, and the headers and query parameters have the
+// given values, an empty value only requires them to be present.
+type Rule struct {
+	Name    string            `yaml:"name"`
+	Prefix  string            `yaml:"prefix"`
+	Methods []string          `yaml:"methods"`
+	Headers map[string]string `yaml:"headers"`
+	Query   map[string]string `yaml:"query"`
+	Pool    string            `yaml:"pool"`
+	// StripPrefix removes Prefix from the forwarded path, Rewrite replaces it.
+	StripPrefix bool   `yaml:"strip_prefix"`
+	Rewrite     string `yaml:"rewrite"`
+}
+
+// Config is the routing table as it is written in the proxy configuration:
+//
+//	pools:
+//	  canary: [http://localhost:9100]
+//	  writes: [http://localhost:9200, http://localhost:9201]
+//	rules:
+//	  - prefix: /v2/
+//	    pool: canary
+//	    rewrite: /v1/
+//	  - methods: [POST, PUT, PATCH, DELETE]
+//	    pool: writes
+//
+// Default names the pool or split of the requests no rule matches.
+type Config struct {
+	Pools   map[string][]string `yaml:"pools"`
+	Splits  map[string]Split    `yaml:"splits"`
+	Rules   []Rule              `yaml:"rules"`
+	Default string              `yaml:"default"`
+}
+
+func Load(file string) (Config, error) {
+	var config Config
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return config, err
+	}
+
+	err = yaml.UnmarshalStrict(data, &config)
+	if err != nil {
+		return config, fmt.Errorf("%s: %w", file, err)
+	}
+
+	return config, nil
+}
+
+// Route is the pool a request goes to and the rule that chose it, which is nil
+// for the default.
+type Route struct {
+	Pool string
+	Rule *Rule
+
+	hosts *provider.Hosts
+	split *split
+}
+
+type routeKey struct{}
+
+func NewContext(ctx context.Context, route *Route) context.Context {
+	return context.WithValue(ctx, routeKey{}, route)
+}
+
+// FromContext returns the route stored by NewContext, or nil.
+func FromContext(ctx context.Context) *Route {
+	route, _ := ctx.Value(routeKey{}).(*Route)
+	return route
+}
+
+// Host returns the next host of the pool.
+func (r *Route) Host() string {
+	return r.hosts.GetHost()
+}
+
+// Done counts the status of the answer to a request, the split that chose the
+// pool rolls back when the pool fails too often.
+func (r *Route) Done(status int) {
+	if r.split != nil {
+		r.split.done(r.Pool, status)
+	}
+}
+
+// Path is the forwarded path of a request to the route.
+func (r *Route) Path(path string) string {
+	if r.Rule == nil || r.Rule.Prefix == "" {
+		return path
+	}
+
+	rest := strings.TrimPrefix(path, r.Rule.Prefix)
+	switch {
+	case r.Rule.Rewrite != "":
+		return join(r.Rule.Rewrite, rest)
+	case r.Rule.StripPrefix:
+		return join("/", rest)
+	}
+
+	return path
+}
+
+// join puts the rest of a path after a new prefix with one slash between them.
+func join(prefix, rest string) string {
+	if rest == "" {
+		return prefix
+	}
+
+	return strings.TrimSuffix(prefix, "/") + "/" + strings.TrimPrefix(rest, "/")
+}
+
+// Table holds the pools and tries the rules in order, the first rule that
+// matches a request wins.
+type Table struct {
+	rules    []Rule
+	routes   map[string]*Route
+	splits   map[string]*split
+	fallback string
+}
+
+// New builds the table of config, defaults are the hosts of the default pool
+// unless the configuration names them itself.
+func New(config Config, defaults []string) (*Table, error) {
+	t := &Table{
+		rules:    config.Rules,
+		routes:   make(map[string]*Route),
+		splits:   make(map[string]*split),
+		fallback: config.Default,
+	}
+	if t.fallback == "" {
+		t.fallback = DEFAULT_POOL
+	}
+
+	pools := map[string][]string{DEFAULT_POOL: defaults}
+	for name, hosts := range config.Pools {
+		pools[name] = hosts
+	}
+
+	for name, hosts := range pools {
+		if len(hosts) == 0 {
+			return nil, fmt.Errorf("pool %q has no hosts", name)
+		}
+
+		pool := provider.NewProvider()
+		for _, host := range hosts {
+			u, err := url.Parse(host)
+			if err != nil {
+				return nil, fmt.Errorf("pool %q: %w", name, err)
+			}
+			if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return nil, fmt.Errorf("pool %q: host %q is not an http or https address", name, host)
+			}
+
+			pool.Add(host)
+		}
+		t.routes[name] = &Route{Pool: name, hosts: &pool}
+	}
+
+	for name, config := range config.Splits {
+		if _, ok := t.routes[name]; ok {
+			return nil, fmt.Errorf("split %q has the name of a pool", name)
+		}
+
+		s, err := newSplit(name, config, t.routes)
+		if err != nil {
+			return nil, err
+		}
+		t.splits[name] = s
+	}
+
+	if !t.known(t.fallback) {
+		return nil, fmt.Errorf("default: unknown pool %q", t.fallback)
+	}
+
+	for i := range t.rules {
+		rule := &t.rules[i]
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule %d", i+1)
+		}
+
+		if !t.known(rule.Pool) {
+			return nil, fmt.Errorf("%s: unknown pool %q", rule.Name, rule.Pool)
+		}
+		if rule.StripPrefix && rule.Rewrite != "" {
+			return nil, fmt.Errorf("%s: strip_prefix and rewrite exclude each other", rule.Name)
+		}
+		if (rule.StripPrefix || rule.Rewrite != "") && rule.Prefix == "" {
+			return nil, fmt.Errorf("%s: the path can only be changed after a prefix", rule.Name)
+		}
+
+		for j, method := range rule.Methods {
+			rule.Methods[j] = strings.ToUpper(method)
+		}
+	}
+
+	return t, nil
+}
+
+// Match returns the route of r.
+func (t *Table) Match(r *http.Request) *Route {
+	for i := range t.rules {
+		rule := &t.rules[i]
+		if rule.matches(r) {
+			return t.route(rule.Pool, rule, r)
+		}
+	}
+
+	return t.route(t.fallback, nil, r)
+}
+
+// route resolves the pool or split target for r.
+func (t *Table) route(target string, rule *Rule, r *http.Request) *Route {
+	var s *split
+	if s = t.splits[target]; s != nil {
+		target = s.choose(r)
+	}
+
+	if s == nil && rule == nil {
+		return t.routes[target]
+	}
+
+	route := *t.routes[target]
+	route.Rule = rule
+	route.split = s
+
+	return &route
+}
+
+func (t *Table) known(target string) bool {
+	_, pool := t.routes[target]
+	_, split := t.splits[target]
+
+	return pool || split
+}
+
+// Pools returns the hosts of every pool by name.
+func (t *Table) Pools() map[string][]string {
+	pools := make(map[string][]string, len(t.routes))
+	for name, route := range t.routes {
+		pools[name] = route.hosts.All()
+	}
+
+	return pools
+}
+
+// PoolNames returns the names of the pools in order.
+func (t *Table) PoolNames() []string {
+	names := make([]string, 0, len(t.routes))
+	for name := range t.routes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func (rule *Rule) matches(r *http.Request) bool {
+	if rule.Prefix != "" && !hasPrefix(r.URL.Path, rule.Prefix) {
+		return false
+	}
+
+	if len(rule.Methods) > 0 {
+		found := false
+		for _, method := range rule.Methods {
+			if method == r.Method {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	for name, value := range rule.Headers {
+		values, ok := r.Header[http.CanonicalHeaderKey(name)]
+		if !ok || !contains(values, value) {
+			return false
+		}
+	}
+
+	query := r.URL.Query()
+	for name, value := range rule.Query {
+		values, ok := query[name]
+		if !ok || !contains(values, value) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// hasPrefix matches whole path segments, /v2 matches /v2 and /v2/users but
+// not /v20.
+func hasPrefix(path, prefix string) bool {
+	if strings.HasSuffix(prefix, "/") {
+		return strings.HasPrefix(path, prefix)
+	}
+
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+// contains reports whether value is one of values, an empty value is in any.
+func contains(values []string, value string) bool {
+	if value == "" {
+		return true
+	}
+
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
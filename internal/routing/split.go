@@ -0,0 +1,306 @@
+package routing
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ROLLBACK_WINDOW       time.Duration = time.Minute
+	ROLLBACK_MIN_REQUESTS int           = 20
+)
+
+// Split shares the requests between pools by weight. A rule or the default
+// sends requests to a split by its name as it would to a pool.
+//
+//	splits:
+//	  rollout:
+//	    weights: {default: 90, canary: 10}
+//	    sticky: {header: X-Session}
+//	    rollback: {pool: canary, error_rate: 0.05}
+type Split struct {
+	Weights  map[string]int `yaml:"weights"`
+	Sticky   Sticky         `yaml:"sticky"`
+	Rollback *Rollback      `yaml:"rollback"`
+}
+
+// Sticky names what keeps a client on one pool while the weights stay the
+// same: the first of the header, the cookie and the user id in the path
+// (/v1/users/7) the request has. Requests without any are shared at random.
+type Sticky struct {
+	Header string `yaml:"header"`
+	Cookie string `yaml:"cookie"`
+	UserId bool   `yaml:"user_id"`
+}
+
+// Rollback takes the weight of Pool away when more than ErrorRate of its
+// answers in Window are server errors, once it had MinRequests of them.
+type Rollback struct {
+	Pool        string        `yaml:"pool"`
+	ErrorRate   float64       `yaml:"error_rate"`
+	Window      time.Duration `yaml:"window"`
+	MinRequests int           `yaml:"min_requests"`
+}
+
+// SplitStatus is the state of a split as the admin endpoint shows it.
+type SplitStatus struct {
+	Name       string         `json:"name"`
+	Weights    map[string]int `json:"weights"`
+	RolledBack bool           `json:"rolled_back"`
+	Requests   int            `json:"requests"`
+	Errors     int            `json:"errors"`
+}
+
+type split struct {
+	name     string
+	sticky   Sticky
+	rollback *Rollback
+	now      func() time.Time
+
+	mu      sync.Mutex
+	weights map[string]int
+	// order puts the rollback pool first, so raising its weight only moves
+	// clients into it.
+	order       []string
+	total       int
+	rolledBack  bool
+	windowStart time.Time
+	requests    int
+	errors      int
+}
+
+func newSplit(name string, config Split, pools map[string]*Route) (*split, error) {
+	s := &split{name: name, sticky: config.Sticky, rollback: config.Rollback, now: time.Now}
+
+	if s.rollback != nil {
+		if _, ok := config.Weights[s.rollback.Pool]; !ok {
+			return nil, fmt.Errorf("split %q: rollback pool %q has no weight", name, s.rollback.Pool)
+		}
+		if s.rollback.ErrorRate <= 0 || s.rollback.ErrorRate > 1 {
+			return nil, fmt.Errorf("split %q: error_rate must be above 0 and at most 1", name)
+		}
+		if s.rollback.Window == 0 {
+			s.rollback.Window = ROLLBACK_WINDOW
+		}
+		if s.rollback.MinRequests == 0 {
+			s.rollback.MinRequests = ROLLBACK_MIN_REQUESTS
+		}
+	}
+
+	for pool := range config.Weights {
+		if _, ok := pools[pool]; !ok {
+			return nil, fmt.Errorf("split %q: unknown pool %q", name, pool)
+		}
+	}
+
+	err := s.setWeights(config.Weights)
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// setWeights replaces the weights and starts the rollback watch over. The
+// caller holds mu or owns s.
+func (s *split) setWeights(weights map[string]int) error {
+	total := 0
+	for pool, weight := range weights {
+		if weight < 0 {
+			return fmt.Errorf("split %q: negative weight of %q", s.name, pool)
+		}
+		total += weight
+	}
+	if total == 0 {
+		return fmt.Errorf("split %q: the weights add up to 0", s.name)
+	}
+
+	order := make([]string, 0, len(weights))
+	for pool := range weights {
+		if s.rollback == nil || pool != s.rollback.Pool {
+			order = append(order, pool)
+		}
+	}
+	sort.Strings(order)
+	if s.rollback != nil {
+		order = append([]string{s.rollback.Pool}, order...)
+	}
+
+	s.weights = make(map[string]int, len(weights))
+	for pool, weight := range weights {
+		s.weights[pool] = weight
+	}
+	s.order = order
+	s.total = total
+	s.rolledBack = false
+	s.resetWindow()
+
+	return nil
+}
+
+func (s *split) resetWindow() {
+	s.windowStart = s.now()
+	s.requests = 0
+	s.errors = 0
+}
+
+// choose returns the pool of r.
+func (s *split) choose(r *http.Request) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var point int
+	if key := s.key(r); key != "" {
+		// keys differing in a few characters must still spread evenly
+		sum := sha256.Sum256([]byte(key))
+		point = int(uint64(binary.BigEndian.Uint32(sum[:4])) * uint64(s.total) >> 32)
+	} else {
+		point = rand.Intn(s.total)
+	}
+
+	for _, pool := range s.order {
+		point -= s.weights[pool]
+		if point < 0 {
+			return pool
+		}
+	}
+
+	return s.order[len(s.order)-1]
+}
+
+func (s *split) key(r *http.Request) string {
+	if s.sticky.Header != "" {
+		if value := r.Header.Get(s.sticky.Header); value != "" {
+			return value
+		}
+	}
+
+	if s.sticky.Cookie != "" {
+		if cookie, err := r.Cookie(s.sticky.Cookie); err == nil && cookie.Value != "" {
+			return cookie.Value
+		}
+	}
+
+	if s.sticky.UserId {
+		return userId(r.URL.Path)
+	}
+
+	return ""
+}
+
+// done counts an answer of pool and rolls back when the rollback pool fails
+// too often.
+func (s *split) done(pool string, status int) {
+	if s.rollback == nil || pool != s.rollback.Pool {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.rolledBack {
+		return
+	}
+
+	if s.now().Sub(s.windowStart) > s.rollback.Window {
+		s.resetWindow()
+	}
+
+	s.requests++
+	if status >= http.StatusInternalServerError {
+		s.errors++
+	}
+
+	if s.requests < s.rollback.MinRequests {
+		return
+	}
+
+	rate := float64(s.errors) / float64(s.requests)
+	if rate <= s.rollback.ErrorRate || s.total == s.weights[pool] {
+		return
+	}
+
+	log.Printf("split %s: %d of %d answers of %s failed, rolling back\n", s.name, s.errors, s.requests, pool)
+
+	s.total -= s.weights[pool]
+	s.weights[pool] = 0
+	s.rolledBack = true
+}
+
+func (s *split) status() SplitStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	weights := make(map[string]int, len(s.weights))
+	for pool, weight := range s.weights {
+		weights[pool] = weight
+	}
+
+	return SplitStatus{
+		Name:       s.name,
+		Weights:    weights,
+		RolledBack: s.rolledBack,
+		Requests:   s.requests,
+		Errors:     s.errors,
+	}
+}
+
+// userId returns the first numeric segment of a path.
+func userId(path string) string {
+	for _, segment := range strings.Split(path, "/") {
+		if segment != "" && strings.Trim(segment, "0123456789") == "" {
+			return segment
+		}
+	}
+
+	return ""
+}
+
+// Splits returns the state of every split by name.
+func (t *Table) Splits() []SplitStatus {
+	statuses := make([]SplitStatus, 0, len(t.splits))
+	for _, s := range t.splits {
+		statuses = append(statuses, s.status())
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+
+	return statuses
+}
+
+// SetWeights changes the weights of a split at runtime, pools it leaves out
+// keep their weight. A rolled back split is watched again.
+func (t *Table) SetWeights(name string, weights map[string]int) (SplitStatus, error) {
+	s, ok := t.splits[name]
+	if !ok {
+		return SplitStatus{}, fmt.Errorf("%w: %q", ErrUnknownSplit, name)
+	}
+
+	s.mu.Lock()
+	merged := make(map[string]int, len(s.weights))
+	for pool, weight := range s.weights {
+		merged[pool] = weight
+	}
+	for pool, weight := range weights {
+		if _, ok := s.weights[pool]; !ok {
+			s.mu.Unlock()
+			return SplitStatus{}, fmt.Errorf("split %q has no pool %q", name, pool)
+		}
+		merged[pool] = weight
+	}
+	err := s.setWeights(merged)
+	s.mu.Unlock()
+
+	if err != nil {
+		return SplitStatus{}, err
+	}
+
+	return s.status(), nil
+}
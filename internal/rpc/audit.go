@@ -0,0 +1,153 @@
+package rpc
+
+import (
+	"context"
+	"gin-server/internal/api"
+	"gin-server/internal/mongogo"
+	"gin-server/internal/rpc/userspb"
+	"gin-server/internal/storage"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// AUDIT_METHOD stands in for the HTTP method in the audit records of calls.
+const AUDIT_METHOD string = "GRPC"
+
+// Audit returns an interceptor that appends a record of every mutating call
+// to the audit log of store, as the api handlers do for requests. The actor is
+// the subject of the client certificate, the x-actor metadata is only kept as
+// the claimed actor. The request id is taken from x-request-id, a missing one
+// is generated and sent back in the header.
+func Audit(store storage.Store) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, request interface{}, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (interface{}, error) {
+		targets, ok := auditTargets(request)
+		if !ok {
+			return handler(ctx, request)
+		}
+
+		md, _ := metadata.FromIncomingContext(ctx)
+		requestId := first(md, "x-request-id")
+		if requestId == "" {
+			requestId = api.NewRequestId()
+		}
+		grpc.SetHeader(ctx, metadata.Pairs("x-request-id", requestId))
+
+		before := make(map[int]*mongogo.User, len(targets))
+		for _, userId := range targets {
+			before[userId] = snapshot(store, userId)
+		}
+
+		response, err := handler(ctx, request)
+
+		// a created user is only known from the response
+		if user, ok := response.(*userspb.User); ok && user != nil && len(targets) == 0 {
+			targets = append(targets, int(user.Id))
+		}
+
+		record := mongogo.AuditRecord{
+			Actor:        api.AUDIT_ANONYMOUS,
+			ClaimedActor: first(md, "x-actor"),
+			Action:       info.FullMethod[strings.LastIndex(info.FullMethod, "/")+1:],
+			Method:       AUDIT_METHOD,
+			Path:         info.FullMethod,
+			Targets:      append([]int{}, targets...),
+			Status:       httpStatus(status.Code(err)),
+			RequestId:    requestId,
+		}
+
+		if p, ok := peer.FromContext(ctx); ok {
+			record.IP = p.Addr.String()
+			if host, _, err := net.SplitHostPort(record.IP); err == nil {
+				record.IP = host
+			}
+
+			if tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+				if actor := api.CertActor(&tlsInfo.State); actor != "" {
+					record.Actor = actor
+				}
+			}
+		}
+
+		if len(targets) <= api.AUDIT_MAX_CHANGES {
+			for _, userId := range targets {
+				if change, ok := mongogo.DiffUser(userId, before[userId], snapshot(store, userId)); ok {
+					record.Changes = append(record.Changes, change)
+				}
+			}
+		}
+
+		if err := store.AppendAudit(record); err != nil {
+			log.Printf("audit %s %s: %v", requestId, record.Path, err)
+		}
+
+		return response, err
+	}
+}
+
+// auditTargets returns the users a call is about to change, false for calls
+// that only read.
+func auditTargets(request interface{}) ([]int, bool) {
+	switch request := request.(type) {
+	case *userspb.CreateUserRequest:
+		return nil, true
+	case *userspb.AddFriendRequest:
+		return []int{int(request.TargetId), int(request.SourceId)}, true
+	case *userspb.RemoveFriendRequest:
+		return []int{int(request.UserId), int(request.FriendId)}, true
+	case *userspb.UpdateUserRequest:
+		return []int{int(request.UserId)}, true
+	case *userspb.DeleteUserRequest:
+		return []int{int(request.UserId)}, true
+	}
+
+	return nil, false
+}
+
+// snapshot returns the user or nil when there is no such user.
+func snapshot(store storage.Store, user_id int) *mongogo.User {
+	user, err := store.GetUser(user_id)
+	if err != nil {
+		return nil
+	}
+
+	return &user
+}
+
+func first(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+
+	return ""
+}
+
+// httpStatus is the status the api handlers answer with for the same error,
+// so that records of calls and requests read alike.
+func httpStatus(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.InvalidArgument:
+		return http.StatusBadRequest
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists:
+		return http.StatusConflict
+	case codes.Aborted:
+		return http.StatusPreconditionFailed
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	}
+
+	return http.StatusInternalServerError
+}
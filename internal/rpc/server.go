@@ -0,0 +1,180 @@
+// Package rpc serves the users over gRPC, next to the gin handlers and on top
+// of the same storage.
+package rpc
+
+import (
+	"context"
+	"gin-server/internal/api"
+	"gin-server/internal/errors"
+	"gin-server/internal/mongogo"
+	"gin-server/internal/rpc/userspb"
+	"gin-server/internal/storage"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Server implements userspb.UserServiceServer.
+type Server struct {
+	userspb.UnimplementedUserServiceServer
+	store storage.Store
+}
+
+func NewServer(store storage.Store) *Server {
+	return &Server{store: store}
+}
+
+// Register adds the UserService on top of store to server.
+func Register(server *grpc.Server, store storage.Store) {
+	userspb.RegisterUserServiceServer(server, NewServer(store))
+}
+
+func (s *Server) CreateUser(ctx context.Context, request *userspb.CreateUserRequest) (*userspb.User, error) {
+	err := api.ValidateProfile(request.Email, request.AvatarUrl)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	userId, err := s.store.CreateUser(mongogo.User{
+		Name:        request.Name,
+		Age:         int(request.Age),
+		Email:       request.Email,
+		DisplayName: request.DisplayName,
+		Bio:         request.Bio,
+		AvatarURL:   request.AvatarUrl,
+	})
+	if err != nil {
+		return nil, statusOf(err)
+	}
+
+	user, err := s.store.GetUser(userId)
+	if err != nil {
+		return nil, statusOf(err)
+	}
+
+	return toUser(user), nil
+}
+
+func (s *Server) GetUser(ctx context.Context, request *userspb.GetUserRequest) (*userspb.User, error) {
+	user, err := s.store.GetUser(int(request.UserId))
+	if err != nil {
+		return nil, statusOf(err)
+	}
+
+	return toUser(user), nil
+}
+
+func (s *Server) ListFriends(ctx context.Context, request *userspb.ListFriendsRequest) (*userspb.ListFriendsResponse, error) {
+	friends, err := s.store.GetFriends(int(request.UserId))
+	if err != nil {
+		return nil, statusOf(err)
+	}
+
+	response := &userspb.ListFriendsResponse{Friends: make([]*userspb.User, 0, len(friends))}
+	for _, friend := range friends {
+		response.Friends = append(response.Friends, toUser(friend))
+	}
+
+	return response, nil
+}
+
+func (s *Server) AddFriend(ctx context.Context, request *userspb.AddFriendRequest) (*userspb.AddFriendResponse, error) {
+	err := s.store.AddFriend(int(request.SourceId), int(request.TargetId))
+	if err != nil {
+		return nil, statusOf(err)
+	}
+
+	return &userspb.AddFriendResponse{}, nil
+}
+
+func (s *Server) RemoveFriend(ctx context.Context, request *userspb.RemoveFriendRequest) (*userspb.RemoveFriendResponse, error) {
+	err := s.store.CheckIds([]int{int(request.UserId), int(request.FriendId)})
+	if err == nil {
+		err = s.store.DelFriend(int(request.UserId), int(request.FriendId))
+	}
+	if err != nil {
+		return nil, statusOf(err)
+	}
+
+	return &userspb.RemoveFriendResponse{}, nil
+}
+
+func (s *Server) UpdateUser(ctx context.Context, request *userspb.UpdateUserRequest) (*userspb.User, error) {
+	update := mongogo.ProfileUpdate{
+		Name:        request.Name,
+		Email:       request.Email,
+		DisplayName: request.DisplayName,
+		Bio:         request.Bio,
+		AvatarURL:   request.AvatarUrl,
+	}
+	if request.Age != nil {
+		age := int(*request.Age)
+		update.Age = &age
+	}
+
+	err := api.ValidateUpdate(update)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	user, err := s.store.UpdateProfile(int(request.UserId), int(request.Version), update)
+	if err != nil {
+		return nil, statusOf(err)
+	}
+
+	return toUser(user), nil
+}
+
+func (s *Server) DeleteUser(ctx context.Context, request *userspb.DeleteUserRequest) (*userspb.DeleteUserResponse, error) {
+	_, err := s.store.DelUser(int(request.UserId))
+	if err != nil {
+		return nil, statusOf(err)
+	}
+
+	return &userspb.DeleteUserResponse{}, nil
+}
+
+// statusOf maps the storage errors to gRPC codes the way the api handlers map
+// them to HTTP statuses.
+func statusOf(err error) error {
+	code := codes.Internal
+
+	switch err.(type) {
+	case *errors.UndefinedIndexes:
+		code = codes.NotFound
+	case *errors.FriendsExists, *errors.EmailExists:
+		code = codes.AlreadyExists
+	case *errors.VersionMismatch:
+		code = codes.Aborted
+	case *errors.UserBlocked:
+		code = codes.PermissionDenied
+	case *errors.InternarMongoError:
+		code = codes.Internal
+	}
+
+	return status.Error(code, err.Error())
+}
+
+func toUser(user mongogo.User) *userspb.User {
+	result := &userspb.User{
+		Id:          int64(user.Id),
+		Name:        user.Name,
+		Age:         int32(user.Age),
+		Friends:     make([]int64, 0, len(user.Friends)),
+		Email:       user.Email,
+		DisplayName: user.DisplayName,
+		Bio:         user.Bio,
+		AvatarUrl:   user.AvatarURL,
+		Version:     int64(user.Version),
+		CreatedAt:   timestamppb.New(user.CreatedAt),
+		UpdatedAt:   timestamppb.New(user.UpdatedAt),
+	}
+
+	for _, friend := range user.Friends {
+		result.Friends = append(result.Friends, int64(friend))
+	}
+
+	return result
+}
@@ -0,0 +1,4 @@
+// Package userspb holds the messages and stubs generated from users.proto.
+package userspb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative users.proto
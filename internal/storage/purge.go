@@ -0,0 +1,28 @@
+package storage
+
+import (
+	"log"
+	"time"
+)
+
+// PurgeLoop hard deletes users that were soft deleted more than retention ago,
+// once per interval, until stop is closed.
+func PurgeLoop(store Store, retention, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := store.PurgeUsers(time.Now().Add(-retention))
+		if err != nil {
+			log.Println("purge:", err)
+		} else if purged > 0 {
+			log.Printf("purge: %d users deleted\n", purged)
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"gin-server/internal/mongogo"
+	"time"
+)
+
+// Store is the storage layer behind the api handlers, implemented by mongogo.Connector
+// and memstore.Store.
+type Store interface {
+	FriendRequestStore
+	IdempotencyStore
+	EventStore
+	WebhookStore
+	AuditStore
+
+	NewUser(name string, age int) (int, error)
+	CreateUser(user mongogo.User) (int, error)
+	UpdateProfile(user_id, version int, update mongogo.ProfileUpdate) (mongogo.User, error)
+	GetUser(user_id int) (mongogo.User, error)
+	GetUsers(user_ids []int) ([]mongogo.User, error)
+	UpdateAge(user_id, newAge, version int) (mongogo.User, error)
+	AddFriend(user_id, friend_id int) error
+	FriendExists(user_id, friend_id int) error
+	DelFriend(user_id, friend_id int) error
+	DelUser(user_id int) (string, error)
+	RestoreUser(user_id int) (mongogo.User, error)
+	PurgeUsers(before time.Time) (int, error)
+	GetFriends(user_id int) ([]mongogo.User, error)
+	CheckIds(user_ids []int) error
+	SearchUsers(query mongogo.SearchQuery) ([]mongogo.User, int, error)
+
+	ReserveIds(n int) (int, error)
+	InsertUsers(users []mongogo.User) []error
+	ExportUsers(fn func(mongogo.User) error) error
+
+	Suggestions(user_id int, query mongogo.SuggestionsQuery) ([]mongogo.Suggestion, error)
+	MutualFriends(user_id, other_id int) ([]mongogo.User, error)
+	FriendPath(user_id, other_id, maxDepth int) ([]mongogo.User, error)
+}
+
+type FriendRequestStore interface {
+	SendFriendRequest(source_id, target_id int) (mongogo.FriendRequest, error)
+	FriendRequests(user_id int, incoming bool, state string) ([]mongogo.FriendRequest, error)
+	AnswerFriendRequest(request_id, user_id int, state string) (mongogo.FriendRequest, error)
+	BlockUser(user_id, blocked_id int) error
+	UnblockUser(user_id, blocked_id int) error
+	BlockedUsers(user_id int) ([]int, error)
+}
+
+// IdempotencyStore keeps the responses of requests sent with an Idempotency-Key.
+type IdempotencyStore interface {
+	ReserveKey(key, hash string) (mongogo.IdempotencyRecord, bool, error)
+	CompleteKey(key string, status int, contentType string, body []byte) error
+	ReleaseKey(key string) error
+}
+
+// EventStore reads the outbox of user events and keeps the position of every
+// subscriber in it.
+type EventStore interface {
+	Events(after, limit int) ([]mongogo.Event, error)
+	UserEvents(user_id, after, limit int) ([]mongogo.Event, error)
+	LastEventId() (int, error)
+	EventCursor(name string) (int, error)
+	SaveEventCursor(name string, event_id int) error
+}
+
+// WebhookStore keeps the webhooks and the deliveries of events to them.
+type WebhookStore interface {
+	CreateWebhook(hook mongogo.Webhook) (mongogo.Webhook, error)
+	Webhooks() ([]mongogo.Webhook, error)
+	DeleteWebhook(webhook_id int) error
+	EnqueueDeliveries(event mongogo.Event) error
+	DueDeliveries(now time.Time, limit int) ([]mongogo.Delivery, error)
+	SaveDelivery(delivery mongogo.Delivery) error
+	Deliveries(query mongogo.DeliveryQuery) ([]mongogo.Delivery, error)
+}
+
+// AuditStore is the append-only log of mutating requests.
+type AuditStore interface {
+	AppendAudit(record mongogo.AuditRecord) error
+	AuditLog(query mongogo.AuditQuery) ([]mongogo.AuditRecord, error)
+}
@@ -1,8 +1,14 @@
 package structs
 
+import "gin-server/internal/mongogo"
+
 type CreateUserRequest struct {
-	Name string `json:"name"`
-	Age  int    `json:"age"`
+	Name        string `json:"name"`
+	Age         int    `json:"age"`
+	Email       string `json:"email,omitempty"`
+	DisplayName string `json:"display_name,omitempty"`
+	Bio         string `json:"bio,omitempty"`
+	AvatarURL   string `json:"avatar_url,omitempty"`
 }
 
 type FriendsRequest struct {
@@ -17,3 +23,74 @@ type DeleteRequest struct {
 type EditAgeRequest struct {
 	NewAge int `json:"new_age"`
 }
+
+type CreateUserResponse struct {
+	Message string `json:"message"`
+	UserId  int    `json:"user_id"`
+}
+
+// BulkUser is a user as it can be imported: the profile and the friends, the
+// store sets the timestamps and the version.
+type BulkUser struct {
+	Id          int    `json:"id"`
+	Name        string `json:"name"`
+	Age         int    `json:"age"`
+	Friends     []int  `json:"friends"`
+	Email       string `json:"email,omitempty"`
+	DisplayName string `json:"display_name,omitempty"`
+	Bio         string `json:"bio,omitempty"`
+	AvatarURL   string `json:"avatar_url,omitempty"`
+}
+
+func (u BulkUser) User() mongogo.User {
+	return mongogo.User{
+		Id:          u.Id,
+		Name:        u.Name,
+		Age:         u.Age,
+		Friends:     u.Friends,
+		Email:       u.Email,
+		DisplayName: u.DisplayName,
+		Bio:         u.Bio,
+		AvatarURL:   u.AvatarURL,
+	}
+}
+
+type BulkResult struct {
+	Index  int    `json:"index"`
+	Id     int    `json:"id,omitempty"`
+	UserId int    `json:"user_id,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+type BulkResponse struct {
+	Inserted int          `json:"inserted"`
+	Failed   int          `json:"failed"`
+	Results  []BulkResult `json:"results"`
+}
+
+type AnswerFriendRequest struct {
+	UserId int `json:"user_id"`
+}
+
+type BlockRequest struct {
+	TargetId int `json:"target_id"`
+}
+
+type SearchResponse struct {
+	Users   []mongogo.User `json:"users"`
+	Total   int            `json:"total"`
+	Page    int            `json:"page"`
+	PerPage int            `json:"per_page"`
+}
+
+type WebhookRequest struct {
+	URL    string   `json:"url"`
+	Secret string   `json:"secret"`
+	Events []string `json:"events"`
+}
+
+type GraphQLRequest struct {
+	Query         string                 `json:"query" binding:"required"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
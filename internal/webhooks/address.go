@@ -0,0 +1,89 @@
+package webhooks
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"syscall"
+)
+
+// ParseNetworks parses addresses and CIDR networks such as "10.1.0.0/16", an
+// address stands for itself.
+func ParseNetworks(values []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+
+		if ip := net.ParseIP(value); ip != nil {
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("%q is neither an address nor a network", value)
+		}
+		networks = append(networks, network)
+	}
+
+	return networks, nil
+}
+
+// Permitted reports whether webhooks may be posted to ip: public addresses
+// are, loopback, link-local, private and unspecified ones only when one of
+// allowed contains them.
+func Permitted(ip net.IP, allowed []*net.IPNet) bool {
+	for _, network := range allowed {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return !(ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsPrivate() || ip.IsUnspecified())
+}
+
+// CheckURL refuses a webhook URL whose host resolves to an address that is
+// not Permitted.
+func CheckURL(raw string, allowed []*net.IPNet) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+
+	ips, err := net.LookupIP(u.Hostname())
+	if err != nil {
+		return err
+	}
+
+	for _, ip := range ips {
+		if !Permitted(ip, allowed) {
+			return fmt.Errorf("%s resolves to %s, which is not a public address", u.Hostname(), ip)
+		}
+	}
+
+	return nil
+}
+
+// control refuses connections to addresses that are not Permitted, a host
+// may resolve differently when the hook is posted than when it was checked.
+func (s *Sender) control(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	if ip := net.ParseIP(host); ip == nil || !Permitted(ip, s.Allowed) {
+		return fmt.Errorf("webhooks may not post to %s", host)
+	}
+
+	return nil
+}
@@ -0,0 +1,227 @@
+package webhooks
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"gin-server/internal/mongogo"
+	"gin-server/internal/storage"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	ATTEMPTS    int           = 8
+	BACKOFF     time.Duration = 10 * time.Second
+	MAX_BACKOFF time.Duration = time.Hour
+	TIMEOUT     time.Duration = 10 * time.Second
+	BATCH       int           = 100
+
+	SIGNATURE_HEADER string = "X-Webhook-Signature"
+	EVENT_HEADER     string = "X-Webhook-Event"
+	DELIVERY_HEADER  string = "X-Webhook-Delivery"
+)
+
+// Sender posts events to webhooks. A failed delivery is retried with
+// exponential backoff and dead-lettered after MaxAttempts attempts.
+type Sender struct {
+	store  storage.WebhookStore
+	client *http.Client
+
+	MaxAttempts int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+	// Allowed are the networks of addresses that are not public but may be
+	// posted to, see Permitted.
+	Allowed []*net.IPNet
+}
+
+func NewSender(store storage.WebhookStore) *Sender {
+	s := &Sender{
+		store: store,
+
+		MaxAttempts: ATTEMPTS,
+		Backoff:     BACKOFF,
+		MaxBackoff:  MAX_BACKOFF,
+	}
+
+	dialer := &net.Dialer{Timeout: TIMEOUT, Control: s.control}
+	s.client = &http.Client{
+		Timeout:   TIMEOUT,
+		Transport: &http.Transport{DialContext: dialer.DialContext, TLSHandshakeTimeout: TIMEOUT},
+	}
+
+	return s
+}
+
+// Enqueue creates the deliveries of an event, it is meant to be subscribed to
+// the events dispatcher.
+func (s *Sender) Enqueue(event mongogo.Event) error {
+	return s.store.EnqueueDeliveries(event)
+}
+
+// Send attempts up to BATCH deliveries that are due at now. Every delivery is
+// claimed right before it is posted, and Send stops before a post could run
+// past the DELIVERY_LEASE of its claim, the rest is sent on the next call.
+func (s *Sender) Send(now time.Time) error {
+	start := time.Now()
+
+	byId, err := s.hooks()
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < BATCH && time.Since(start)+TIMEOUT < mongogo.DELIVERY_LEASE; i++ {
+		due, err := s.store.DueDeliveries(now, 1)
+		if err != nil {
+			return err
+		} else if len(due) == 0 {
+			return nil
+		}
+
+		delivery := due[0]
+		hook, ok := byId[delivery.WebhookId]
+		if !ok {
+			// the hook may be newer than the ones read
+			byId, err = s.hooks()
+			if err != nil {
+				return err
+			}
+			hook, ok = byId[delivery.WebhookId]
+		}
+		if !ok {
+			delivery.State = mongogo.DELIVERY_DEAD
+			delivery.LastError = "webhook deleted"
+
+			err = s.store.SaveDelivery(delivery)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		delivery.Attempts++
+		delivery.LastStatus, err = s.post(hook, delivery)
+
+		if err == nil {
+			delivery.State = mongogo.DELIVERY_DELIVERED
+			delivery.LastError = ""
+		} else {
+			delivery.LastError = err.Error()
+
+			if delivery.Attempts >= s.MaxAttempts {
+				delivery.State = mongogo.DELIVERY_DEAD
+			} else {
+				delivery.NextAttempt = now.Add(s.backoff(delivery.Attempts))
+			}
+		}
+
+		err = s.store.SaveDelivery(delivery)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// hooks reads the webhooks by id.
+func (s *Sender) hooks() (map[int]mongogo.Webhook, error) {
+	hooks, err := s.store.Webhooks()
+	if err != nil {
+		return nil, err
+	}
+
+	byId := make(map[int]mongogo.Webhook, len(hooks))
+	for _, hook := range hooks {
+		byId[hook.Id] = hook
+	}
+
+	return byId, nil
+}
+
+// Run sends the due deliveries once per interval until stop is closed.
+func (s *Sender) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		err := s.Send(time.Now())
+		if err != nil {
+			log.Println("webhooks:", err)
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sign returns the signature of a payload sent in SIGNATURE_HEADER.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks a signature made by Sign in constant time.
+func Verify(secret string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, body)), []byte(signature))
+}
+
+// post sends the event of the delivery and returns the response status, any
+// status other than 2xx is an error.
+func (s *Sender) post(hook mongogo.Webhook, delivery mongogo.Delivery) (int, error) {
+	body, err := json.Marshal(delivery.Event)
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SIGNATURE_HEADER, Sign(hook.Secret, body))
+	req.Header.Set(EVENT_HEADER, delivery.Event.Type)
+	req.Header.Set(DELIVERY_HEADER, strconv.Itoa(delivery.Id))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook answered %s", resp.Status)
+	}
+
+	return resp.StatusCode, nil
+}
+
+func (s *Sender) backoff(attempts int) time.Duration {
+	backoff := s.Backoff
+	for i := 1; i < attempts && backoff < s.MaxBackoff; i++ {
+		backoff *= 2
+	}
+
+	if backoff > s.MaxBackoff {
+		return s.MaxBackoff
+	}
+
+	return backoff
+}
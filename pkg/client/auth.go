@@ -0,0 +1,55 @@
+package client
+
+import "net/http"
+
+// Auth adds credentials to every request of a Client, it is called again for
+// every retry.
+type Auth interface {
+	Authorize(req *http.Request) error
+}
+
+// AuthFunc lets an ordinary function be used as Auth.
+type AuthFunc func(req *http.Request) error
+
+func (f AuthFunc) Authorize(req *http.Request) error {
+	return f(req)
+}
+
+// BearerToken sends "Authorization: Bearer <token>".
+func BearerToken(token string) Auth {
+	return AuthFunc(func(req *http.Request) error {
+		req.Header.Set("Authorization", "Bearer "+token)
+		return nil
+	})
+}
+
+// BasicAuth sends the user and password with HTTP basic authentication.
+func BasicAuth(user, password string) Auth {
+	return AuthFunc(func(req *http.Request) error {
+		req.SetBasicAuth(user, password)
+		return nil
+	})
+}
+
+// Actor names the caller in the X-Actor header, which the server writes to
+// its audit log as the claimed actor next to the authenticated one.
+func Actor(name string) Auth {
+	return AuthFunc(func(req *http.Request) error {
+		req.Header.Set("X-Actor", name)
+		return nil
+	})
+}
+
+// Chain applies several Auth in order, for example a token and an actor.
+func Chain(auths ...Auth) Auth {
+	return AuthFunc(func(req *http.Request) error {
+		for _, auth := range auths {
+			err := auth.Authorize(req)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
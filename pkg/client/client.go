@@ -0,0 +1,414 @@
+// Package client is a typed Go client of the users API served by cmd/server.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const VERSION_PREFIX string = "/v1"
+
+// Retry decides how failed requests are repeated. Only requests that are safe
+// to repeat are retried: reads, PUT, PATCH and DELETE, and the POST requests
+// that carry an Idempotency-Key, which CreateUser and MakeFriends always do.
+type Retry struct {
+	// Attempts is the number of tries, one disables retries.
+	Attempts int
+	// Backoff is the wait before the first retry, it doubles up to MaxBackoff.
+	// A Retry-After header of the server takes precedence.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+var DefaultRetry = Retry{Attempts: 3, Backoff: 100 * time.Millisecond, MaxBackoff: 2 * time.Second}
+
+type User struct {
+	Id          int        `json:"id"`
+	Name        string     `json:"name"`
+	Age         int        `json:"age"`
+	Friends     []int      `json:"friends"`
+	Email       string     `json:"email,omitempty"`
+	DisplayName string     `json:"display_name,omitempty"`
+	Bio         string     `json:"bio,omitempty"`
+	AvatarURL   string     `json:"avatar_url,omitempty"`
+	Version     int        `json:"version"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	DeletedAt   *time.Time `json:"deleted_at,omitempty"`
+}
+
+// ProfileUpdate changes the fields that are not nil, an empty string clears an
+// optional field.
+type ProfileUpdate struct {
+	Name        *string `json:"name,omitempty"`
+	Age         *int    `json:"age,omitempty"`
+	Email       *string `json:"email,omitempty"`
+	DisplayName *string `json:"display_name,omitempty"`
+	Bio         *string `json:"bio,omitempty"`
+	AvatarURL   *string `json:"avatar_url,omitempty"`
+}
+
+// SearchQuery finds users by a name prefix and an age range, MaxAge 0 means
+// no upper bound. Sort is name, age, id or created_at, with a leading "-" for
+// descending order. Zero values leave the defaults of the server.
+type SearchQuery struct {
+	Text    string
+	MinAge  int
+	MaxAge  int
+	Sort    string
+	Page    int
+	PerPage int
+}
+
+type SearchResult struct {
+	Users   []User `json:"users"`
+	Total   int    `json:"total"`
+	Page    int    `json:"page"`
+	PerPage int    `json:"per_page"`
+}
+
+type ImportResult struct {
+	Index  int    `json:"index"`
+	Id     int    `json:"id,omitempty"`
+	UserId int    `json:"user_id,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+type ImportResponse struct {
+	Inserted int            `json:"inserted"`
+	Failed   int            `json:"failed"`
+	Results  []ImportResult `json:"results"`
+}
+
+type CreateUserRequest struct {
+	Name        string `json:"name"`
+	Age         int    `json:"age"`
+	Email       string `json:"email,omitempty"`
+	DisplayName string `json:"display_name,omitempty"`
+	Bio         string `json:"bio,omitempty"`
+	AvatarURL   string `json:"avatar_url,omitempty"`
+}
+
+// Client calls the API on BaseURL, for example "http://localhost:8080".
+type Client struct {
+	baseURL string
+	http    *http.Client
+	auth    Auth
+	retry   Retry
+}
+
+type Option func(*Client)
+
+// WithHTTPClient replaces http.DefaultClient.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.http = httpClient
+	}
+}
+
+func WithAuth(auth Auth) Option {
+	return func(c *Client) {
+		c.auth = auth
+	}
+}
+
+func WithRetry(retry Retry) Option {
+	return func(c *Client) {
+		c.retry = retry
+	}
+}
+
+func New(baseURL string, options ...Option) *Client {
+	c := &Client{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		http:    http.DefaultClient,
+		retry:   DefaultRetry,
+	}
+
+	for _, option := range options {
+		option(c)
+	}
+
+	return c
+}
+
+// CreateUser creates the user and returns its id.
+func (c *Client) CreateUser(ctx context.Context, user CreateUserRequest) (int, error) {
+	var response struct {
+		UserId int `json:"user_id"`
+	}
+
+	err := c.do(ctx, http.MethodPost, "/create", user, newIdempotencyKey(), nil, &response)
+	if err != nil {
+		return 0, err
+	}
+
+	return response.UserId, nil
+}
+
+func (c *Client) GetUser(ctx context.Context, userId int) (User, error) {
+	var user User
+
+	err := c.do(ctx, http.MethodGet, fmt.Sprintf("/users/%d", userId), nil, "", nil, &user)
+
+	return user, err
+}
+
+func (c *Client) SearchUsers(ctx context.Context, query SearchQuery) (SearchResult, error) {
+	params := url.Values{}
+	if query.Text != "" {
+		params.Set("q", query.Text)
+	}
+	if query.Sort != "" {
+		params.Set("sort", query.Sort)
+	}
+	for name, value := range map[string]int{"min_age": query.MinAge, "max_age": query.MaxAge, "page": query.Page, "per_page": query.PerPage} {
+		if value != 0 {
+			params.Set(name, strconv.Itoa(value))
+		}
+	}
+
+	var result SearchResult
+	err := c.do(ctx, http.MethodGet, "/users/search?"+params.Encode(), nil, "", nil, &result)
+
+	return result, err
+}
+
+// UpdateProfile changes the profile of the user, with a version other than
+// zero only if the user still has that version.
+func (c *Client) UpdateProfile(ctx context.Context, userId, version int, update ProfileUpdate) (User, error) {
+	var user User
+
+	err := c.do(ctx, http.MethodPatch, fmt.Sprintf("/users/%d", userId), update, "", ifMatch(version), &user)
+
+	return user, err
+}
+
+// ImportUsers inserts the users with their friend lists. With remap every user
+// gets a fresh id and the friend ids, which refer to ids of the imported users,
+// are rewritten, otherwise the ids are kept.
+func (c *Client) ImportUsers(ctx context.Context, users []User, remap bool) (ImportResponse, error) {
+	var response ImportResponse
+
+	path := "/users/bulk?remap=" + strconv.FormatBool(remap)
+	err := c.do(ctx, http.MethodPost, path, users, "", nil, &response)
+
+	return response, err
+}
+
+// ExportUsers calls fn for every user in id order. With remap the ids are
+// renumbered from 1.
+func (c *Client) ExportUsers(ctx context.Context, remap bool, fn func(User) error) error {
+	path := "/users/export?format=ndjson&remap=" + strconv.FormatBool(remap)
+
+	return c.do(ctx, http.MethodGet, path, nil, "", nil, func(body io.Reader) error {
+		decoder := json.NewDecoder(body)
+		for {
+			var user User
+			err := decoder.Decode(&user)
+			if err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+
+			err = fn(user)
+			if err != nil {
+				return err
+			}
+		}
+	})
+}
+
+func (c *Client) GetFriends(ctx context.Context, userId int) ([]User, error) {
+	friends := []User{}
+
+	err := c.do(ctx, http.MethodGet, fmt.Sprintf("/friends/%d", userId), nil, "", nil, &friends)
+	if friends == nil {
+		friends = []User{}
+	}
+
+	return friends, err
+}
+
+// MakeFriends adds sourceId to the friend list of targetId.
+func (c *Client) MakeFriends(ctx context.Context, sourceId, targetId int) error {
+	body := map[string]int{"source_id": sourceId, "target_id": targetId}
+
+	return c.do(ctx, http.MethodPost, "/make_friends", body, newIdempotencyKey(), nil, nil)
+}
+
+// EditAge changes the age of the user. With a version other than zero the
+// user is changed only if it still has that version, otherwise the error is
+// a *VersionMismatch.
+func (c *Client) EditAge(ctx context.Context, userId, newAge, version int) error {
+	body := map[string]int{"new_age": newAge}
+
+	return c.do(ctx, http.MethodPut, fmt.Sprintf("/%d", userId), body, "", ifMatch(version), nil)
+}
+
+func (c *Client) DeleteUser(ctx context.Context, userId int) error {
+	body := map[string]int{"target_id": userId}
+
+	return c.do(ctx, http.MethodDelete, "/user", body, "", nil, nil)
+}
+
+// do sends the request until it succeeds or may not be retried and decodes
+// the "response" field of the answer into result. A result of type
+// func(io.Reader) error reads the body of the answer itself instead.
+func (c *Client) do(ctx context.Context, method, path string, body interface{}, key string, header http.Header, result interface{}) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		payload, err = json.Marshal(body)
+		if err != nil {
+			return err
+		}
+	}
+
+	retryable := method == http.MethodGet || method == http.MethodPut || method == http.MethodPatch ||
+		method == http.MethodDelete || key != ""
+	attempts := c.retry.Attempts
+	if attempts < 1 || !retryable {
+		attempts = 1
+	}
+
+	backoff := c.retry.Backoff
+	for attempt := 1; ; attempt++ {
+		wait, err := c.send(ctx, method, path, payload, key, header, result)
+		if err == nil || wait < 0 || attempt == attempts {
+			return err
+		}
+
+		if wait == 0 {
+			wait = backoff
+			backoff *= 2
+			if c.retry.MaxBackoff > 0 && backoff > c.retry.MaxBackoff {
+				backoff = c.retry.MaxBackoff
+			}
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// send makes one attempt. The returned wait is negative when the request must
+// not be retried, zero for the default backoff or the Retry-After of the server.
+func (c *Client) send(ctx context.Context, method, path string, payload []byte, key string, header http.Header, result interface{}) (time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+VERSION_PREFIX+path, bytes.NewReader(payload))
+	if err != nil {
+		return -1, err
+	}
+
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if key != "" {
+		req.Header.Set("Idempotency-Key", key)
+	}
+	if c.auth != nil {
+		err = c.auth.Authorize(req)
+		if err != nil {
+			return -1, err
+		}
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return -1, ctx.Err()
+		}
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if read, ok := result.(func(io.Reader) error); ok && resp.StatusCode < 300 {
+		// a stream that was partly handed out can not be repeated
+		return -1, read(resp.Body)
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+
+	if resp.StatusCode >= 300 {
+		err = decodeError(resp.StatusCode, data)
+		if !retryStatus(resp.StatusCode, err) {
+			return -1, err
+		}
+
+		seconds, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+		return time.Duration(seconds) * time.Second, err
+	}
+
+	if result == nil {
+		return 0, nil
+	}
+
+	var answer struct {
+		Response json.RawMessage `json:"response"`
+	}
+	err = json.Unmarshal(data, &answer)
+	if err == nil {
+		err = json.Unmarshal(answer.Response, result)
+	}
+	if err != nil {
+		return -1, fmt.Errorf("decode answer of %s %s: %w", method, path, err)
+	}
+
+	return 0, nil
+}
+
+// retryStatus reports whether an answer is worth another try: the server or a
+// proxy in front of it is busy, or a request with the same idempotency key is
+// still running.
+func retryStatus(status int, err error) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	case http.StatusConflict:
+		var inProgress *KeyInProgress
+		return errors.As(err, &inProgress)
+	}
+
+	return false
+}
+
+// ifMatch is the If-Match header requiring the version, none for zero.
+func ifMatch(version int) http.Header {
+	header := http.Header{}
+	if version != 0 {
+		header.Set("If-Match", strconv.Quote(strconv.Itoa(version)))
+	}
+
+	return header
+}
+
+func newIdempotencyKey() string {
+	key := make([]byte, 16)
+	rand.Read(key)
+
+	return hex.EncodeToString(key)
+}
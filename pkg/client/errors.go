@@ -0,0 +1,156 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// APIError is an answer of the server that is not a success. Err holds the
+// typed error when the server described it, so errors.As finds for example
+// *UndefinedIndexes in the error returned by a call.
+type APIError struct {
+	StatusCode int
+	Message    string
+	Err        error
+}
+
+func (ae *APIError) Error() string {
+	return fmt.Sprintf("%d %s", ae.StatusCode, ae.Message)
+}
+
+func (ae *APIError) Unwrap() error {
+	return ae.Err
+}
+
+// The errors below mirror gin-server/internal/errors.
+
+type UndefinedIndexes struct {
+	Indexes []int `json:"indexes"`
+}
+
+func (ui *UndefinedIndexes) Error() string {
+	return fmt.Sprintf("Undefined indexes: %v", ui.Indexes)
+}
+
+// InternalError is a failure of the storage behind the server.
+type InternalError struct {
+	Message string
+}
+
+func (ie *InternalError) Error() string {
+	return ie.Message
+}
+
+type FriendsExists struct {
+	SourceId int `json:"source_id"`
+	TargetId int `json:"target_id"`
+}
+
+func (fe *FriendsExists) Error() string {
+	return fmt.Sprintf("User %d is already in friend list of %d", fe.SourceId, fe.TargetId)
+}
+
+type EmailExists struct {
+	Email string `json:"email"`
+}
+
+func (ee *EmailExists) Error() string {
+	return fmt.Sprintf("Email %s is already taken", ee.Email)
+}
+
+type VersionMismatch struct {
+	Id       int `json:"id"`
+	Expected int `json:"expected"`
+	Actual   int `json:"actual"`
+}
+
+func (vm *VersionMismatch) Error() string {
+	return fmt.Sprintf("User %d has version %d, not %d", vm.Id, vm.Actual, vm.Expected)
+}
+
+type KeyInProgress struct {
+	Key string `json:"key"`
+}
+
+func (kp *KeyInProgress) Error() string {
+	return fmt.Sprintf("Request with idempotency key %s is still in progress", kp.Key)
+}
+
+type KeyConflict struct {
+	Key string `json:"key"`
+}
+
+func (kc *KeyConflict) Error() string {
+	return fmt.Sprintf("Idempotency key %s was used with a different request", kc.Key)
+}
+
+// errorAnswer is the body of a failed request.
+type errorAnswer struct {
+	Response string `json:"response"`
+	Error    *struct {
+		Type    string          `json:"type"`
+		Details json.RawMessage `json:"details"`
+	} `json:"error"`
+}
+
+// decodeError builds the error of a failed answer. Older routes answer with
+// "Error: <message>" in plain text, the message is all there is then.
+func decodeError(status int, body []byte) error {
+	apiErr := &APIError{StatusCode: status}
+
+	var answer errorAnswer
+	if json.Unmarshal(body, &answer) != nil {
+		apiErr.Message = strings.TrimPrefix(strings.TrimSpace(string(body)), "Error: ")
+		apiErr.Err = textError(apiErr.Message)
+		return apiErr
+	}
+
+	apiErr.Message = answer.Response
+	if answer.Error == nil {
+		return apiErr
+	}
+
+	var typed error
+	switch answer.Error.Type {
+	case "UndefinedIndexes":
+		typed = &UndefinedIndexes{}
+	case "FriendsExists":
+		typed = &FriendsExists{}
+	case "EmailExists":
+		typed = &EmailExists{}
+	case "VersionMismatch":
+		typed = &VersionMismatch{}
+	case "KeyInProgress":
+		typed = &KeyInProgress{}
+	case "KeyConflict":
+		typed = &KeyConflict{}
+	case "InternarMongoError":
+		apiErr.Err = &InternalError{Message: answer.Response}
+		return apiErr
+	default:
+		return apiErr
+	}
+
+	if json.Unmarshal(answer.Error.Details, typed) == nil {
+		apiErr.Err = typed
+	}
+
+	return apiErr
+}
+
+func textError(message string) error {
+	var indexes []int
+	if strings.HasPrefix(message, "Undefined indexes: ") {
+		for _, field := range strings.Fields(strings.Trim(strings.TrimPrefix(message, "Undefined indexes: "), "[]")) {
+			var index int
+			if _, err := fmt.Sscan(field, &index); err == nil {
+				indexes = append(indexes, index)
+			}
+		}
+
+		return &UndefinedIndexes{Indexes: indexes}
+	}
+
+	return nil
+}
@@ -0,0 +1,130 @@
+package server_test
+
+import (
+	"encoding/json"
+	"gin-server/internal/api"
+	"gin-server/internal/memstore"
+	"gin-server/internal/mongogo"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+type AnswerAudit struct {
+	Ok       bool                  `json:"ok"`
+	Response []mongogo.AuditRecord `json:"response"`
+}
+
+func auditAnswer(t *testing.T, router *gin.Engine, url string) []mongogo.AuditRecord {
+	w := serveAdmin(router, "GET", url, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var answer AnswerAudit
+	err := json.Unmarshal(w.Body.Bytes(), &answer)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+	}
+
+	return answer.Response
+}
+
+func auditActions(records []mongogo.AuditRecord) []string {
+	result := []string{}
+	for _, record := range records {
+		result = append(result, record.Action)
+	}
+
+	return result
+}
+
+func TestAuditLog(t *testing.T) {
+	router := gin.New()
+	api.RegisterRoutes(router, api.Deps{Store: memstore.New(), AdminToken: ADMIN_TOKEN})
+
+	start := time.Now().UTC().Add(-time.Second)
+	admin := map[string]string{"X-Actor": "admin"}
+
+	w := serveWith(router, "POST", "/v1/create", `{"name": "Anna", "age": 20}`, admin)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Len(t, w.Header().Get("X-Request-ID"), 32)
+
+	serveWith(router, "POST", "/create", `{"name": "Boris", "age": 25}`, admin)
+
+	// httptest requests come from 192.0.2.1
+	req := httptest.NewRequest("POST", "/v1/make_friends", strings.NewReader(`{"source_id": 1, "target_id": 2}`))
+	req.Header.Set("X-Actor", "anna")
+	req.Header.Set("X-Request-ID", "req-1")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	serve(router, "PUT", "/v1/1", `{"new_age": 21}`)
+	serve(router, "PUT", "/v1/7", `{"new_age": 21}`)
+	serve(router, "GET", "/v1/users/1", "")
+
+	records := auditAnswer(t, router, "/v1/admin/audit")
+	assert.Equal(t, []string{"EditAge", "EditAge", "MakeFriends", "CreateUser", "CreateUser"}, auditActions(records))
+
+	failed := records[0]
+	assert.Equal(t, "anonymous", failed.Actor)
+	assert.Empty(t, failed.ClaimedActor)
+	assert.Equal(t, http.StatusBadRequest, failed.Status)
+	assert.Equal(t, "/:user_id", failed.Path)
+	assert.Equal(t, map[string]string{"user_id": "7"}, failed.Params)
+	assert.Empty(t, failed.Changes)
+
+	edit := records[1]
+	assert.Equal(t, []int{1}, edit.Targets)
+	assert.Equal(t, []mongogo.UserChange{{
+		UserId: 1,
+		Fields: map[string]mongogo.FieldChange{"age": {Before: 20.0, After: 21.0}},
+	}}, edit.Changes)
+
+	friends := records[2]
+	assert.Equal(t, "anonymous", friends.Actor)
+	assert.Equal(t, "anna", friends.ClaimedActor)
+	assert.Equal(t, "req-1", friends.RequestId)
+	assert.Equal(t, "192.0.2.1", friends.IP)
+	assert.Equal(t, []int{2, 1}, friends.Targets)
+	assert.Equal(t, []mongogo.UserChange{{
+		UserId: 2,
+		Fields: map[string]mongogo.FieldChange{"friends": {Before: []interface{}{}, After: []interface{}{1.0}}},
+	}}, friends.Changes)
+
+	created := records[4]
+	assert.Equal(t, http.StatusCreated, created.Status)
+	assert.Equal(t, []int{1}, created.Targets)
+	assert.Nil(t, created.Changes[0].Fields["name"].Before)
+	assert.Equal(t, "Anna", created.Changes[0].Fields["name"].After)
+	assert.Equal(t, "/create", records[3].Path)
+
+	records = auditAnswer(t, router, "/v1/admin/audit?claimed_actor=admin")
+	assert.Equal(t, []string{"CreateUser", "CreateUser"}, auditActions(records))
+	records = auditAnswer(t, router, "/v1/admin/audit?actor=admin")
+	assert.Empty(t, records)
+
+	// the admin token authenticates its requests as the admin
+	serveAdmin(router, "POST", "/v1/admin/users/7/restore", "")
+	records = auditAnswer(t, router, "/v1/admin/audit?actor=admin")
+	assert.Equal(t, []string{"RestoreUser"}, auditActions(records))
+
+	records = auditAnswer(t, router, "/v1/admin/audit?target_id=2&limit=1")
+	assert.Equal(t, []string{"MakeFriends"}, auditActions(records))
+
+	query := url.Values{}
+	query.Set("from", start.Format(time.RFC3339))
+	records = auditAnswer(t, router, "/v1/admin/audit?"+query.Encode())
+	assert.Len(t, records, 6)
+
+	query.Set("to", start.Format(time.RFC3339))
+	records = auditAnswer(t, router, "/v1/admin/audit?"+query.Encode())
+	assert.Empty(t, records)
+
+	w = serveAdmin(router, "GET", "/v1/admin/audit?from=yesterday", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -0,0 +1,99 @@
+package server_test
+
+import (
+	"gin-server/internal/api"
+	"gin-server/internal/memstore"
+	"gin-server/internal/mongogo"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBulkRemap(t *testing.T) {
+	router := newGraphRouter(t)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/v1/users/bulk", strings.NewReader(`[
+		{"id": 1, "name": "Gleb", "age": 30, "friends": [2]},
+		{"id": 2, "name": "Hanna", "age": 31, "friends": [1]},
+		{"id": 3, "name": "Igor", "age": 32, "friends": [9]}
+	]`))
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"inserted":2,"failed":1`)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/v1/users/export?format=ndjson", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Body.String(), `{"id":7,"name":"Gleb","age":30,"friends":[8],`)
+	assert.Contains(t, w.Body.String(), `{"id":8,"name":"Hanna","age":31,"friends":[7],`)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/v1/users/export?format=csv&remap=true", nil)
+	router.ServeHTTP(w, req)
+
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	assert.Equal(t, "id,name,age,friends", lines[0])
+	assert.Equal(t, "1,Anna,20,2;3", lines[1])
+	assert.Equal(t, "7,Gleb,30,8", lines[7])
+}
+
+func TestBulkImportFields(t *testing.T) {
+	router := newGraphRouter(t)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/v1/users/bulk?remap=false", strings.NewReader(`
+{"id": 10, "name": "Gleb", "age": 30, "friends": [1], "version": 9, "created_at": "2001-01-01T00:00:00Z", "deleted_at": "2001-01-01T00:00:00Z"}
+{"id": 11, "name": "Hanna", "age": 31, "friends": [99]}
+{"id": 12, "name": "Igor", "age": 32, "friends": [11]}
+`))
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"inserted":1,"failed":2`)
+	assert.Contains(t, w.Body.String(), `"index":1,"id":11,"error"`)
+	assert.Contains(t, w.Body.String(), `"index":2,"id":12,"error"`)
+
+	// only the profile and the friends are taken from the payload
+	w = serve(router, "GET", "/v1/users/10", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"version":1`)
+	assert.NotContains(t, w.Body.String(), "2001")
+	assert.NotContains(t, w.Body.String(), "deleted_at")
+}
+
+// growingStore creates a user after every export, as a concurrent request
+// would between the passes of a remapped export.
+type growingStore struct {
+	*memstore.Store
+}
+
+func (gs growingStore) ExportUsers(fn func(mongogo.User) error) error {
+	err := gs.Store.ExportUsers(fn)
+	if err == nil {
+		_, err = gs.Store.NewUser("Late", 40)
+	}
+
+	return err
+}
+
+func TestBulkRemapGrowing(t *testing.T) {
+	store := growingStore{memstore.New()}
+	router := gin.New()
+	api.RegisterRoutes(router, api.Deps{Store: store})
+
+	serve(router, "POST", "/v1/create", `{"name": "Anna", "age": 20}`)
+	serve(router, "POST", "/v1/create", `{"name": "Boris", "age": 25}`)
+
+	w := serve(router, "GET", "/v1/users/export?format=csv&remap=true", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "id,name,age,friends\n1,Anna,20,\n2,Boris,25,\n", w.Body.String())
+}
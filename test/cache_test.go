@@ -0,0 +1,130 @@
+package server_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"gin-server/internal/api"
+	"gin-server/internal/cache"
+	"gin-server/internal/memstore"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func friendNames(t *testing.T, router *gin.Engine, user_id int) []string {
+	t.Helper()
+
+	w := serve(router, "GET", fmt.Sprintf("/v1/friends/%d", user_id), "")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var answer AnswerFriends
+	err := json.Unmarshal(w.Body.Bytes(), &answer)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+	}
+
+	names := []string{}
+	for _, friend := range answer.Response {
+		names = append(names, fmt.Sprintf("%s %d", friend.Name, friend.Age))
+	}
+
+	return names
+}
+
+func cacheStats(t *testing.T, router *gin.Engine) cache.Stats {
+	t.Helper()
+
+	w := serveAdmin(router, "GET", "/v1/admin/cache", "")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var answer struct {
+		Response cache.Stats `json:"response"`
+	}
+	err := json.Unmarshal(w.Body.Bytes(), &answer)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+	}
+
+	return answer.Response
+}
+
+func TestCache(t *testing.T) {
+	store := &countingStore{Store: memstore.New()}
+	router := gin.New()
+	api.RegisterRoutes(router, api.Deps{Store: cache.New(store, cache.Config{Size: 100, TTL: time.Minute}), AdminToken: ADMIN_TOKEN})
+
+	for _, name := range []string{"Anna", "Boris", "Clara"} {
+		serve(router, "POST", "/v1/create", fmt.Sprintf(`{"name": "%s", "age": 20}`, name))
+	}
+	serve(router, "POST", "/v1/make_friends", `{"source_id": 2, "target_id": 1}`)
+
+	assert.Equal(t, []string{"Boris 20"}, friendNames(t, router, 1))
+	batches := len(store.batches)
+	before := cacheStats(t, router)
+	assert.Equal(t, []string{"Boris 20"}, friendNames(t, router, 1))
+	assert.Equal(t, batches, len(store.batches), "friends are read from the cache")
+
+	stats := cacheStats(t, router)
+	assert.Equal(t, before.Hits+2, stats.Hits)
+	assert.Equal(t, before.Misses, stats.Misses)
+
+	// every change is seen by the next read
+	serve(router, "PUT", "/v1/2", `{"new_age": 30}`)
+	assert.Equal(t, []string{"Boris 30"}, friendNames(t, router, 1))
+
+	serve(router, "PATCH", "/v1/users/2", `{"name": "Bob"}`)
+	assert.Equal(t, []string{"Bob 30"}, friendNames(t, router, 1))
+
+	serve(router, "POST", "/v1/make_friends", `{"source_id": 3, "target_id": 1}`)
+	assert.Equal(t, []string{"Bob 30", "Clara 20"}, friendNames(t, router, 1))
+
+	serve(router, "DELETE", "/v1/user", `{"target_id": 3}`)
+	assert.Equal(t, []string{"Bob 30"}, friendNames(t, router, 1))
+	assert.Equal(t, http.StatusNotFound, serve(router, "GET", "/v1/users/3", "").Code)
+
+	serveAdmin(router, "POST", "/v1/admin/users/3/restore", "")
+	assert.Equal(t, []string{"Bob 30", "Clara 20"}, friendNames(t, router, 1))
+	assert.Equal(t, http.StatusOK, serve(router, "GET", "/v1/users/3", "").Code)
+
+	// a missing user is cached as missing until it is created
+	assert.Equal(t, http.StatusNotFound, serve(router, "GET", "/v1/users/4", "").Code)
+	serve(router, "POST", "/v1/create", `{"name": "Dan", "age": 20}`)
+	assert.Equal(t, http.StatusOK, serve(router, "GET", "/v1/users/4", "").Code)
+
+	stats = cacheStats(t, router)
+	assert.True(t, stats.Misses > 0)
+	assert.True(t, stats.Invalidations > 0)
+
+	router = gin.New()
+	api.RegisterRoutes(router, api.Deps{Store: memstore.New(), AdminToken: ADMIN_TOKEN})
+	assert.Equal(t, http.StatusNotFound, serveAdmin(router, "GET", "/v1/admin/cache", "").Code)
+}
+
+func TestCacheLRU(t *testing.T) {
+	lru := cache.NewLRU(2, 50*time.Millisecond)
+
+	lru.Add("a", 1, lru.Epoch())
+	lru.Add("b", 2, lru.Epoch())
+	lru.Get("a")
+	lru.Add("c", 3, lru.Epoch())
+
+	_, ok := lru.Get("b")
+	assert.False(t, ok, "least recently used is evicted")
+	value, ok := lru.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, 1, value)
+	assert.Equal(t, 1, lru.Evictions())
+
+	epoch := lru.Epoch()
+	lru.Remove("a")
+	assert.False(t, lru.Add("a", 4, epoch), "a value read before a removal is not added")
+
+	time.Sleep(60 * time.Millisecond)
+	_, ok = lru.Get("c")
+	assert.False(t, ok, "expired")
+}
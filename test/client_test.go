@@ -0,0 +1,150 @@
+package server_test
+
+import (
+	"context"
+	"errors"
+	"gin-server/internal/api"
+	"gin-server/internal/memstore"
+	"gin-server/pkg/client"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// newClientServer serves the router on memstore, the first failures requests
+// are answered with 503.
+func newClientServer(t *testing.T, failures int32) (*httptest.Server, *gin.Engine) {
+	router := gin.New()
+	api.RegisterRoutes(router, api.Deps{Store: memstore.New(), AdminToken: ADMIN_TOKEN})
+
+	var failed int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&failed, 1) <= failures {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		router.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	return server, router
+}
+
+func TestClient(t *testing.T) {
+	server, router := newClientServer(t, 0)
+	ctx := context.Background()
+	c := client.New(server.URL, client.WithAuth(client.Chain(client.BearerToken("secret"), client.Actor("tester"))))
+
+	anna, err := c.CreateUser(ctx, client.CreateUserRequest{Name: "Anna", Age: 20, Email: "anna@example.com"})
+	assert.NoError(t, err)
+	boris, err := c.CreateUser(ctx, client.CreateUserRequest{Name: "Boris", Age: 30})
+	assert.NoError(t, err)
+
+	_, err = c.CreateUser(ctx, client.CreateUserRequest{Name: "Clara", Age: 40, Email: "anna@example.com"})
+	var emailExists *client.EmailExists
+	assert.True(t, errors.As(err, &emailExists), err)
+	assert.Equal(t, "anna@example.com", emailExists.Email)
+
+	assert.NoError(t, c.MakeFriends(ctx, anna, boris))
+
+	err = c.MakeFriends(ctx, anna, boris)
+	var friendsExists *client.FriendsExists
+	assert.True(t, errors.As(err, &friendsExists), err)
+	assert.Equal(t, client.FriendsExists{SourceId: anna, TargetId: boris}, *friendsExists)
+
+	friends, err := c.GetFriends(ctx, boris)
+	assert.NoError(t, err)
+	assert.Len(t, friends, 1)
+	assert.Equal(t, "Anna", friends[0].Name)
+
+	assert.NoError(t, c.EditAge(ctx, anna, 21, 0))
+	user, err := c.GetUser(ctx, anna)
+	assert.NoError(t, err)
+	assert.Equal(t, 21, user.Age)
+
+	err = c.EditAge(ctx, anna, 22, user.Version-1)
+	var versionMismatch *client.VersionMismatch
+	assert.True(t, errors.As(err, &versionMismatch), err)
+	assert.Equal(t, user.Version, versionMismatch.Actual)
+
+	assert.NoError(t, c.DeleteUser(ctx, boris))
+
+	_, err = c.GetFriends(ctx, boris)
+	var undefined *client.UndefinedIndexes
+	assert.True(t, errors.As(err, &undefined), err)
+	assert.Equal(t, []int{boris}, undefined.Indexes)
+
+	var apiErr *client.APIError
+	assert.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusBadRequest, apiErr.StatusCode)
+
+	records := auditAnswer(t, router, "/v1/admin/audit?claimed_actor=tester")
+	assert.Equal(t, []string{"DeleteUser", "EditAge", "EditAge", "MakeFriends", "MakeFriends", "CreateUser", "CreateUser", "CreateUser"},
+		auditActions(records))
+	assert.Equal(t, http.StatusPreconditionFailed, records[1].Status)
+}
+
+func TestClientRetry(t *testing.T) {
+	ctx := context.Background()
+	retry := client.Retry{Attempts: 3, Backoff: time.Millisecond}
+
+	server, _ := newClientServer(t, 2)
+	c := client.New(server.URL, client.WithRetry(retry))
+	id, err := c.CreateUser(ctx, client.CreateUserRequest{Name: "Anna", Age: 20})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, id)
+
+	server, _ = newClientServer(t, 3)
+	c = client.New(server.URL, client.WithRetry(retry))
+	_, err = c.GetFriends(ctx, 1)
+	var apiErr *client.APIError
+	assert.True(t, errors.As(err, &apiErr), err)
+	assert.Equal(t, http.StatusServiceUnavailable, apiErr.StatusCode)
+
+	server, _ = newClientServer(t, 1)
+	c = client.New(server.URL, client.WithRetry(client.Retry{Attempts: 3, Backoff: time.Minute}))
+	ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, err = c.GetFriends(ctx, 1)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestClientImportExport(t *testing.T) {
+	server, _ := newClientServer(t, 0)
+	ctx := context.Background()
+	c := client.New(server.URL)
+
+	response, err := c.ImportUsers(ctx, []client.User{
+		{Id: 10, Name: "Anna", Age: 20, Friends: []int{11}},
+		{Id: 11, Name: "Boris", Age: 30, Friends: []int{10}},
+	}, true)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, response.Inserted)
+
+	bio := "hi"
+	user, err := c.UpdateProfile(ctx, 1, 1, client.ProfileUpdate{Bio: &bio})
+	assert.NoError(t, err)
+	assert.Equal(t, "hi", user.Bio)
+	assert.Equal(t, 2, user.Version)
+
+	result, err := c.SearchUsers(ctx, client.SearchQuery{MinAge: 25})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result.Total)
+	assert.Equal(t, "Boris", result.Users[0].Name)
+
+	var exported []client.User
+	err = c.ExportUsers(ctx, false, func(user client.User) error {
+		exported = append(exported, user)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Len(t, exported, 2)
+	assert.Equal(t, []int{2}, exported[0].Friends)
+	assert.Equal(t, "hi", exported[0].Bio)
+}
@@ -0,0 +1,73 @@
+package server_test
+
+import (
+	"gin-server/internal/api"
+	"gin-server/internal/memstore"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func serveWith(router *gin.Engine, method, url, body string, header map[string]string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, url, strings.NewReader(body))
+	for key, value := range header {
+		req.Header.Set(key, value)
+	}
+
+	router.ServeHTTP(w, req)
+
+	return w
+}
+
+func TestETag(t *testing.T) {
+	router := gin.New()
+	api.RegisterRoutes(router, api.Deps{Store: memstore.New()})
+
+	serve(router, "POST", "/v1/create", `{"name": "Anna", "age": 20}`)
+	serve(router, "POST", "/v1/create", `{"name": "Boris", "age": 25}`)
+
+	w := serve(router, "GET", "/v1/users/1", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"1"`, w.Header().Get("ETag"))
+	assert.Contains(t, w.Body.String(), `"version":1`)
+
+	w = serveWith(router, "GET", "/v1/users/1", "", map[string]string{"If-None-Match": `"1"`})
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Empty(t, w.Body.String())
+
+	w = serveWith(router, "PATCH", "/v1/users/1", `{"bio": "hi"}`, map[string]string{"If-Match": `"1"`})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"2"`, w.Header().Get("ETag"))
+
+	w = serveWith(router, "PATCH", "/v1/users/1", `{"bio": "lost update"}`, map[string]string{"If-Match": `"1"`})
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+
+	w = serveWith(router, "PUT", "/v1/1", `{"new_age": 21}`, map[string]string{"If-Match": `"1"`})
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+
+	w = serveWith(router, "PUT", "/v1/1", `{"new_age": 21}`, map[string]string{"If-Match": `"2"`})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"3"`, w.Header().Get("ETag"))
+
+	w = serveWith(router, "PUT", "/v1/1", `{"new_age": 22}`, map[string]string{"If-Match": "3"})
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+
+	w = serveWith(router, "PUT", "/v1/1", `{"new_age": 22}`, map[string]string{"If-Match": "*"})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = serve(router, "POST", "/v1/make_friends", `{"source_id": 2, "target_id": 1}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	user := userAnswer(t, router, "GET", "/v1/users/1", "", http.StatusOK)
+	assert.Equal(t, 5, user.Version)
+	assert.Equal(t, 22, user.Age)
+	assert.Equal(t, "hi", user.Bio)
+
+	w = serveWith(router, "GET", "/v1/users/1", "", map[string]string{"If-None-Match": `"4"`})
+	assert.Equal(t, http.StatusOK, w.Code)
+}
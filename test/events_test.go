@@ -0,0 +1,118 @@
+package server_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"gin-server/internal/api"
+	"gin-server/internal/events"
+	"gin-server/internal/memstore"
+	"gin-server/internal/mongogo"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+type AnswerEvents struct {
+	Ok       bool            `json:"ok"`
+	Response []mongogo.Event `json:"response"`
+}
+
+func eventTypes(events []mongogo.Event) []string {
+	result := []string{}
+	for _, event := range events {
+		result = append(result, fmt.Sprintf("%d %s %d %d", event.Id, event.Type, event.UserId, event.FriendId))
+	}
+
+	return result
+}
+
+func TestEventsOutbox(t *testing.T) {
+	router := gin.New()
+	api.RegisterRoutes(router, api.Deps{Store: memstore.New(), AdminToken: ADMIN_TOKEN})
+
+	serve(router, "POST", "/v1/create", `{"name": "Anna", "age": 20}`)
+	serve(router, "POST", "/v1/create", `{"name": "Boris", "age": 25}`)
+	serve(router, "POST", "/v1/make_friends", `{"source_id": 1, "target_id": 2}`)
+	serve(router, "PUT", "/v1/1", `{"new_age": 21}`)
+	serve(router, "DELETE", "/v1/user", `{"target_id": 2}`)
+
+	w := serveAdmin(router, "GET", "/v1/admin/events", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var answer AnswerEvents
+	err := json.Unmarshal(w.Body.Bytes(), &answer)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+	}
+
+	assert.Equal(t, []string{
+		"1 UserCreated 1 0",
+		"2 UserCreated 2 0",
+		"3 FriendAdded 2 1",
+		"4 UserUpdated 1 0",
+		"5 UserDeleted 2 0",
+	}, eventTypes(answer.Response))
+	assert.Equal(t, 21, answer.Response[3].User.Age)
+
+	w = serveAdmin(router, "GET", "/v1/admin/events?after=3&limit=1", "")
+	assert.Contains(t, w.Body.String(), `"id":4,"type":"UserUpdated"`)
+	assert.NotContains(t, w.Body.String(), `"id":5`)
+
+	w = serveAdmin(router, "GET", "/v1/admin/events?limit=0", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestEventsDispatcher(t *testing.T) {
+	store := memstore.New()
+	dispatcher := events.NewDispatcher(store)
+
+	var delivered []int
+	fail := 3
+	dispatcher.Subscribe("test", func(event mongogo.Event) error {
+		if event.Id == fail {
+			return fmt.Errorf("subscriber is down")
+		}
+		delivered = append(delivered, event.Id)
+		return nil
+	})
+
+	for _, name := range []string{"Anna", "Boris", "Clara", "Dmitry"} {
+		store.NewUser(name, 30)
+	}
+
+	assert.Error(t, dispatcher.Dispatch())
+	assert.Equal(t, []int{1, 2}, delivered)
+
+	fail = 0
+	assert.NoError(t, dispatcher.Dispatch())
+	assert.Equal(t, []int{1, 2, 3, 4}, delivered)
+
+	assert.NoError(t, dispatcher.Dispatch())
+	assert.Equal(t, []int{1, 2, 3, 4}, delivered)
+
+	cursor, _ := store.EventCursor("test")
+	assert.Equal(t, 4, cursor)
+
+	assert.NoError(t, dispatcher.Replay("test", 2))
+	assert.NoError(t, dispatcher.Dispatch())
+	assert.Equal(t, []int{1, 2, 3, 4, 3, 4}, delivered)
+}
+
+func TestEventsSettled(t *testing.T) {
+	now := time.Now()
+	fresh := now.Add(-time.Second)
+	old := now.Add(-events.GAP_SETTLE - time.Second)
+
+	outbox := []mongogo.Event{{Id: 3, CreatedAt: old}, {Id: 5, CreatedAt: fresh}, {Id: 6, CreatedAt: fresh}}
+
+	// 4 may still commit, 2 had long enough
+	assert.Equal(t, []string{"3  0 0"}, eventTypes(events.Settled(1, outbox, now)))
+	assert.Equal(t, []string{}, eventTypes(events.Settled(3, outbox[1:], now)))
+
+	outbox[1].CreatedAt = old
+	assert.Equal(t, []string{"3  0 0", "5  0 0", "6  0 0"}, eventTypes(events.Settled(1, outbox, now)))
+}
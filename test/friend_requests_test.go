@@ -0,0 +1,123 @@
+package server_test
+
+import (
+	"encoding/json"
+	"gin-server/internal/mongogo"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+type AnswerFriendRequests struct {
+	Ok       bool                    `json:"ok"`
+	Response []mongogo.FriendRequest `json:"response"`
+}
+
+func serve(router *gin.Engine, method, url, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, url, strings.NewReader(body))
+
+	router.ServeHTTP(w, req)
+
+	return w
+}
+
+// ADMIN_TOKEN is the admin token of the test routers.
+const ADMIN_TOKEN string = "admin-secret"
+
+func serveAdmin(router *gin.Engine, method, url, body string) *httptest.ResponseRecorder {
+	return serveWith(router, method, url, body, map[string]string{"Authorization": "Bearer " + ADMIN_TOKEN})
+}
+
+func TestFriendRequests(t *testing.T) {
+	router := newGraphRouter(t)
+
+	w := serve(router, "POST", "/v1/friend_requests", `{"source_id": 5, "target_id": 6}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Contains(t, w.Body.String(), `"state":"pending"`)
+
+	w = serve(router, "POST", "/v1/friend_requests", `{"source_id": 5, "target_id": 6}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	w = serve(router, "POST", "/v1/friend_requests", `{"source_id": 1, "target_id": 2}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = serve(router, "GET", "/v1/users/6/friend_requests/incoming?state=pending", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var answer AnswerFriendRequests
+	err := json.Unmarshal(w.Body.Bytes(), &answer)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+	}
+	if assert.Len(t, answer.Response, 1) {
+		assert.Equal(t, 5, answer.Response[0].SourceId)
+	}
+
+	w = serve(router, "POST", "/v1/friend_requests/1/accept", `{"user_id": 5}`)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = serve(router, "POST", "/v1/friend_requests/1/accept", `{"user_id": 6}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"state":"accepted"`)
+
+	w = serve(router, "POST", "/v1/friend_requests/1/cancel", `{"user_id": 5}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	assert.Equal(t, []int{4, 5}, userIds(friendsAnswer(t, router, "/v1/friends/6", http.StatusOK)))
+
+	w = serve(router, "POST", "/v1/friend_requests/100/reject", `{"user_id": 6}`)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// a request crossing a pending one is refused
+	w = serve(router, "POST", "/v1/friend_requests", `{"source_id": 5, "target_id": 1}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	w = serve(router, "POST", "/v1/friend_requests", `{"source_id": 1, "target_id": 5}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	// a friendship made meanwhile accepts the request
+	w = serve(router, "POST", "/v1/make_friends", `{"source_id": 5, "target_id": 1}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	w = serve(router, "POST", "/v1/friend_requests/2/accept", `{"user_id": 1}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"state":"accepted"`)
+}
+
+func TestBlockUser(t *testing.T) {
+	router := newGraphRouter(t)
+
+	w := serve(router, "POST", "/v1/friend_requests", `{"source_id": 6, "target_id": 2}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	w = serve(router, "POST", "/v1/users/2/blocks", `{"target_id": 6}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	w = serve(router, "GET", "/v1/users/6/friend_requests/outgoing", "")
+	assert.Contains(t, w.Body.String(), `"state":"rejected"`)
+
+	w = serve(router, "POST", "/v1/friend_requests", `{"source_id": 6, "target_id": 2}`)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = serve(router, "POST", "/v1/make_friends", `{"source_id": 6, "target_id": 2}`)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = serve(router, "POST", "/v1/make_friends", `{"source_id": 2, "target_id": 6}`)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = serve(router, "GET", "/v1/users/2/blocks", "")
+	assert.JSONEq(t, `{"ok": true, "response": [6]}`, w.Body.String())
+
+	w = serve(router, "DELETE", "/v1/users/2/blocks/6", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = serve(router, "POST", "/v1/friend_requests", `{"source_id": 6, "target_id": 2}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	w = serve(router, "POST", "/v1/friend_requests/2/cancel", `{"user_id": 6}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"state":"cancelled"`)
+}
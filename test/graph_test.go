@@ -0,0 +1,154 @@
+package server_test
+
+import (
+	"encoding/json"
+	"gin-server/internal/api"
+	"gin-server/internal/memstore"
+	"gin-server/internal/mongogo"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+type AnswerSuggestions struct {
+	Ok       bool                 `json:"ok"`
+	Response []mongogo.Suggestion `json:"response"`
+}
+
+// graph is an undirected friend graph:
+//
+//	1 - 2 - 4 - 6
+//	 \     /
+//	  3 ---
+//	   \
+//	    5
+const graph = `{"id": 1, "name": "Anna", "age": 20, "friends": [2, 3]}
+{"id": 2, "name": "Boris", "age": 25, "friends": [1, 4]}
+{"id": 3, "name": "Clara", "age": 30, "friends": [1, 4, 5]}
+{"id": 4, "name": "Denis", "age": 40, "friends": [2, 3, 6]}
+{"id": 5, "name": "Elena", "age": 22, "friends": [3]}
+{"id": 6, "name": "Fedor", "age": 35, "friends": [4]}
+`
+
+func newGraphRouter(t *testing.T) *gin.Engine {
+	return newGraphRouterWith(t, memstore.New())
+}
+
+func newGraphRouterWith(t *testing.T, store *memstore.Store) *gin.Engine {
+	router := gin.New()
+	api.RegisterRoutes(router, api.Deps{Store: store, AdminToken: ADMIN_TOKEN})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/v1/users/bulk?remap=false", strings.NewReader(graph))
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"inserted":6,"failed":0`)
+
+	return router
+}
+
+func suggestions(t *testing.T, router *gin.Engine, url string) []mongogo.Suggestion {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", url, nil)
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var answer AnswerSuggestions
+
+	err := json.Unmarshal(w.Body.Bytes(), &answer)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+	}
+
+	return answer.Response
+}
+
+func TestSuggestions(t *testing.T) {
+	router := newGraphRouter(t)
+
+	result := suggestions(t, router, "/v1/users/1/suggestions")
+	if assert.Len(t, result, 2) {
+		assert.Equal(t, 4, result[0].User.Id)
+		assert.Equal(t, 2, result[0].MutualFriends)
+		assert.Equal(t, 5, result[1].User.Id)
+		assert.Equal(t, 1, result[1].MutualFriends)
+	}
+
+	result = suggestions(t, router, "/v1/users/1/suggestions?depth=3")
+	if assert.Len(t, result, 3) {
+		assert.Equal(t, 6, result[2].User.Id)
+		assert.Equal(t, 3, result[2].Hops)
+	}
+
+	result = suggestions(t, router, "/v1/users/1/suggestions?depth=3&min_age=30&max_age=39")
+	if assert.Len(t, result, 1) {
+		assert.Equal(t, 6, result[0].User.Id)
+	}
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/v1/users/1/suggestions?depth=4", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/v1/users/100/suggestions", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func friendsAnswer(t *testing.T, router *gin.Engine, url string, code int) []mongogo.User {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", url, nil)
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, code, w.Code)
+
+	var answer AnswerFriends
+
+	err := json.Unmarshal(w.Body.Bytes(), &answer)
+	if err != nil && code == http.StatusOK {
+		t.Log(err)
+		t.Fail()
+	}
+
+	return answer.Response
+}
+
+func userIds(users []mongogo.User) []int {
+	ids := []int{}
+	for _, user := range users {
+		ids = append(ids, user.Id)
+	}
+
+	return ids
+}
+
+func TestMutualFriends(t *testing.T) {
+	router := newGraphRouter(t)
+
+	assert.Equal(t, []int{2, 3}, userIds(friendsAnswer(t, router, "/v1/users/1/mutual/4", http.StatusOK)))
+	assert.Equal(t, []int{}, userIds(friendsAnswer(t, router, "/v1/users/1/mutual/2", http.StatusOK)))
+
+	friendsAnswer(t, router, "/v1/users/1/mutual/100", http.StatusBadRequest)
+}
+
+func TestFriendPath(t *testing.T) {
+	router := newGraphRouter(t)
+
+	assert.Equal(t, []int{1, 2, 4, 6}, userIds(friendsAnswer(t, router, "/v1/users/1/path/6", http.StatusOK)))
+	assert.Equal(t, []int{5, 3, 1}, userIds(friendsAnswer(t, router, "/v1/users/5/path/1", http.StatusOK)))
+	assert.Equal(t, []int{3}, userIds(friendsAnswer(t, router, "/v1/users/3/path/3", http.StatusOK)))
+
+	friendsAnswer(t, router, "/v1/users/1/path/6?max_depth=2", http.StatusNotFound)
+	friendsAnswer(t, router, "/v1/users/1/path/6?max_depth=7", http.StatusBadRequest)
+}
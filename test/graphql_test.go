@@ -0,0 +1,210 @@
+package server_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"gin-server/internal/api"
+	"gin-server/internal/memstore"
+	"gin-server/internal/mongogo"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// countingStore counts the batched user lookups of the GraphQL dataloader.
+type countingStore struct {
+	*memstore.Store
+
+	mu      sync.Mutex
+	batches [][]int
+}
+
+func (cs *countingStore) GetUsers(user_ids []int) ([]mongogo.User, error) {
+	cs.mu.Lock()
+	cs.batches = append(cs.batches, append([]int{}, user_ids...))
+	cs.mu.Unlock()
+
+	return cs.Store.GetUsers(user_ids)
+}
+
+type AnswerGraphQL struct {
+	Data   json.RawMessage `json:"data"`
+	Errors []struct {
+		Message    string                 `json:"message"`
+		Extensions map[string]interface{} `json:"extensions"`
+	} `json:"errors"`
+}
+
+func graphqlAnswer(t *testing.T, router *gin.Engine, query string, code int) AnswerGraphQL {
+	t.Helper()
+
+	body, _ := json.Marshal(map[string]interface{}{"query": query})
+	w := serve(router, "POST", "/v1/graphql", string(body))
+	assert.Equal(t, code, w.Code, w.Body.String())
+
+	var answer AnswerGraphQL
+	err := json.Unmarshal(w.Body.Bytes(), &answer)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+	}
+
+	return answer
+}
+
+// newFriendsRouter creates Anna (1), Boris (2), Clara (3) and Dan (4) with the
+// friend lists 1: [2 3], 2: [1 3 4] and 3: [1 2].
+func newFriendsRouter(store *countingStore) *gin.Engine {
+	router := gin.New()
+	api.RegisterRoutes(router, api.Deps{Store: store, AdminToken: ADMIN_TOKEN})
+
+	for _, name := range []string{"Anna", "Boris", "Clara", "Dan"} {
+		serve(router, "POST", "/v1/create", fmt.Sprintf(`{"name": "%s", "age": 20}`, name))
+	}
+	for _, pair := range [][2]int{{2, 1}, {3, 1}, {1, 2}, {3, 2}, {4, 2}, {1, 3}, {2, 3}} {
+		serve(router, "POST", "/v1/make_friends", fmt.Sprintf(`{"source_id": %d, "target_id": %d}`, pair[0], pair[1]))
+	}
+
+	return router
+}
+
+func TestGraphQLQuery(t *testing.T) {
+	store := &countingStore{Store: memstore.New()}
+	router := newFriendsRouter(store)
+
+	answer := graphqlAnswer(t, router, `{
+		user(id: 1) {
+			name
+			friends { name friends { id } }
+			mutualFriends(with: 3) { name }
+		}
+	}`, http.StatusOK)
+	assert.Empty(t, answer.Errors)
+	assert.JSONEq(t, `{"user": {
+		"name": "Anna",
+		"friends": [
+			{"name": "Boris", "friends": [{"id": 1}, {"id": 3}, {"id": 4}]},
+			{"name": "Clara", "friends": [{"id": 1}, {"id": 2}]}
+		],
+		"mutualFriends": [{"name": "Boris"}]
+	}}`, string(answer.Data))
+
+	// one lookup per level, users already loaded are not asked for again
+	assert.Equal(t, [][]int{{1}, {2, 3}, {4}}, store.batches)
+
+	answer = graphqlAnswer(t, router, `{
+		first: user(id: 2) { friends(first: 1) { id } }
+		next: user(id: 2) { friends(first: 5, after: 1) { id } }
+		missing: user(id: 7) { id }
+	}`, http.StatusOK)
+	assert.Empty(t, answer.Errors)
+	assert.JSONEq(t, `{
+		"first": {"friends": [{"id": 1}]},
+		"next": {"friends": [{"id": 3}, {"id": 4}]},
+		"missing": null
+	}`, string(answer.Data))
+
+	answer = graphqlAnswer(t, router, `{
+		first: user(id: 1) { mutualFriends(with: 2, first: 1) { id } }
+		next: user(id: 1) { mutualFriends(with: 2, after: 3) { id } }
+	}`, http.StatusOK)
+	assert.Empty(t, answer.Errors)
+	assert.JSONEq(t, `{"first": {"mutualFriends": [{"id": 3}]}, "next": {"mutualFriends": []}}`, string(answer.Data))
+
+	answer = graphqlAnswer(t, router, `{ user(id: 1) { mutualFriends(with: 7) { id } } }`, http.StatusOK)
+	assert.Equal(t, "Undefined indexes: [7]", answer.Errors[0].Message)
+
+	answer = graphqlAnswer(t, router, `{ user(id: 1) { nickname } }`, http.StatusBadRequest)
+	assert.Contains(t, answer.Errors[0].Message, "nickname")
+
+	w := serveAdmin(router, "GET", "/v1/admin/audit", "")
+	assert.NotContains(t, w.Body.String(), "GraphQL")
+}
+
+func TestGraphQLLimits(t *testing.T) {
+	router := newFriendsRouter(&countingStore{Store: memstore.New()})
+
+	answer := graphqlAnswer(t, router, `{ user(id: 1) { friends { friends { friends { friends { id } } } } } }`,
+		http.StatusBadRequest)
+	assert.Equal(t, "query depth 6 exceeds the limit of 5", answer.Errors[0].Message)
+
+	answer = graphqlAnswer(t, router, `{ user(id: 1) { ...deep } } fragment deep on User { friends(first: 100) { friends(first: 100) { id } } }`,
+		http.StatusBadRequest)
+	assert.Equal(t, "query complexity 10102 exceeds the limit of 1000", answer.Errors[0].Message)
+
+	// mutual friends are as long as first says, not the assumed length of a list
+	answer = graphqlAnswer(t, router, `{ user(id: 1) { mutualFriends(with: 2, first: 100) { friends(first: 100) { id } } } }`,
+		http.StatusBadRequest)
+	assert.Equal(t, "query complexity 10102 exceeds the limit of 1000", answer.Errors[0].Message)
+
+	// every user of a list is loaded, even with nothing but __typename selected
+	ids := make([]string, 100)
+	for i := range ids {
+		ids[i] = strconv.Itoa(i + 1)
+	}
+	answer = graphqlAnswer(t, router, `{ users(ids: [`+strings.Join(ids, ",")+`]) { friends(first: 100) { __typename } } }`,
+		http.StatusBadRequest)
+	assert.Equal(t, "query complexity 10101 exceeds the limit of 1000", answer.Errors[0].Message)
+
+	answer = graphqlAnswer(t, router, `{ users(ids: [`+strings.Join(ids, ",")+`, 101]) { __typename } }`, http.StatusBadRequest)
+	assert.Equal(t, "ids must hold at most 100 ids", answer.Errors[0].Message)
+
+	// a negative first must not make the lists below it cheap
+	for _, first := range []string{"0", "-100", "101"} {
+		answer = graphqlAnswer(t, router, `{ user(id: 1) { friends(first: `+first+`) { friends(first: 100) { friends(first: 100) { id } } } } }`,
+			http.StatusBadRequest)
+		assert.Equal(t, "first must be in range 1..100", answer.Errors[0].Message)
+	}
+
+	answer = graphqlAnswer(t, router, `{ __schema { types { name fields { name type { name ofType { name ofType { name } } } } } } }`,
+		http.StatusOK)
+	assert.Empty(t, answer.Errors)
+}
+
+func TestGraphQLMutations(t *testing.T) {
+	router := newFriendsRouter(&countingStore{Store: memstore.New()})
+
+	answer := graphqlAnswer(t, router, `mutation {
+		createUser(name: "Eva", age: 30, email: "eva@example.com") { id name age email version }
+	}`, http.StatusOK)
+	assert.Empty(t, answer.Errors)
+	assert.JSONEq(t, `{"createUser": {"id": 5, "name": "Eva", "age": 30, "email": "eva@example.com", "version": 1}}`,
+		string(answer.Data))
+
+	answer = graphqlAnswer(t, router, `mutation {
+		addFriend(sourceId: 1, targetId: 5) { id friends { name } }
+	}`, http.StatusOK)
+	assert.Empty(t, answer.Errors)
+	assert.JSONEq(t, `{"addFriend": {"id": 5, "friends": [{"name": "Anna"}]}}`, string(answer.Data))
+
+	answer = graphqlAnswer(t, router, `mutation { addFriend(sourceId: 1, targetId: 5) { id } }`, http.StatusOK)
+	assert.Equal(t, "CONFLICT", answer.Errors[0].Extensions["code"])
+
+	answer = graphqlAnswer(t, router, `mutation { updateUser(id: 5, version: 2, age: 31, bio: "hi") { age bio version } }`,
+		http.StatusOK)
+	assert.Empty(t, answer.Errors)
+	assert.JSONEq(t, `{"updateUser": {"age": 31, "bio": "hi", "version": 3}}`, string(answer.Data))
+
+	answer = graphqlAnswer(t, router, `mutation { updateUser(id: 5, version: 2, age: 32) { age } }`, http.StatusOK)
+	assert.Equal(t, "VERSION_MISMATCH", answer.Errors[0].Extensions["code"])
+
+	for _, args := range []string{`email: "eva"`, `name: ""`, `age: -1`} {
+		answer = graphqlAnswer(t, router, `mutation { updateUser(id: 5, `+args+`) { age } }`, http.StatusOK)
+		assert.Equal(t, "BAD_REQUEST", answer.Errors[0].Extensions["code"], args)
+	}
+
+	answer = graphqlAnswer(t, router, `mutation { deleteUser(id: 5) }`, http.StatusOK)
+	assert.JSONEq(t, `{"deleteUser": true}`, string(answer.Data))
+
+	answer = graphqlAnswer(t, router, `mutation { deleteUser(id: 5) }`, http.StatusOK)
+	assert.Equal(t, "NOT_FOUND", answer.Errors[0].Extensions["code"])
+
+	records := auditAnswer(t, router, "/v1/admin/audit?target_id=5")
+	assert.Equal(t, []string{"GraphQL", "GraphQL", "GraphQL", "GraphQL", "GraphQL", "GraphQL", "GraphQL"}, auditActions(records))
+	assert.Equal(t, []int{5, 1}, records[5].Targets)
+}
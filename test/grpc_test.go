@@ -0,0 +1,217 @@
+package server_test
+
+import (
+	"context"
+	"gin-server/internal/api"
+	"gin-server/internal/memstore"
+	"gin-server/internal/mongogo"
+	"gin-server/internal/rpc"
+	"gin-server/internal/rpc/userspb"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+)
+
+// newUserClient serves the UserService on an in-process listener.
+func newUserClient(t *testing.T) userspb.UserServiceClient {
+	return newUserClientWith(t, memstore.New())
+}
+
+func newUserClientWith(t *testing.T, store *memstore.Store) userspb.UserServiceClient {
+	listener := bufconn.Listen(1024 * 1024)
+
+	server := grpc.NewServer(grpc.UnaryInterceptor(rpc.Audit(store)))
+	rpc.Register(server, store)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return userspb.NewUserServiceClient(conn)
+}
+
+func assertCode(t *testing.T, code codes.Code, err error) {
+	t.Helper()
+
+	assert.Equal(t, code, status.Code(err), "%v", err)
+}
+
+func TestGRPCUsers(t *testing.T) {
+	client := newUserClient(t)
+	ctx := context.Background()
+
+	anna, err := client.CreateUser(ctx, &userspb.CreateUserRequest{Name: "Anna", Age: 20, Email: "anna@example.com"})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), anna.Id)
+	assert.Equal(t, "Anna", anna.DisplayName)
+	assert.Equal(t, int64(1), anna.Version)
+	assert.False(t, anna.CreatedAt.AsTime().IsZero())
+
+	_, err = client.CreateUser(ctx, &userspb.CreateUserRequest{Name: "Anna", Email: "anna@example.com"})
+	assertCode(t, codes.AlreadyExists, err)
+
+	_, err = client.CreateUser(ctx, &userspb.CreateUserRequest{Name: "Boris", Email: "boris"})
+	assertCode(t, codes.InvalidArgument, err)
+
+	_, err = client.CreateUser(ctx, &userspb.CreateUserRequest{Name: "Boris", Age: 25})
+	assert.Nil(t, err)
+
+	user, err := client.GetUser(ctx, &userspb.GetUserRequest{UserId: anna.Id})
+	assert.Nil(t, err)
+	assert.Equal(t, "anna@example.com", user.Email)
+
+	_, err = client.GetUser(ctx, &userspb.GetUserRequest{UserId: 7})
+	assertCode(t, codes.NotFound, err)
+
+	user, err = client.UpdateUser(ctx, &userspb.UpdateUserRequest{
+		UserId:  anna.Id,
+		Version: anna.Version,
+		Age:     proto.Int32(21),
+		Bio:     proto.String("hi"),
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, int32(21), user.Age)
+	assert.Equal(t, "hi", user.Bio)
+	assert.Equal(t, "anna@example.com", user.Email)
+	assert.Equal(t, int64(2), user.Version)
+
+	_, err = client.UpdateUser(ctx, &userspb.UpdateUserRequest{UserId: anna.Id, Version: 1, Bio: proto.String("stale")})
+	assertCode(t, codes.Aborted, err)
+
+	_, err = client.UpdateUser(ctx, &userspb.UpdateUserRequest{UserId: anna.Id, AvatarUrl: proto.String("ftp://avatar")})
+	assertCode(t, codes.InvalidArgument, err)
+
+	_, err = client.UpdateUser(ctx, &userspb.UpdateUserRequest{UserId: anna.Id, Name: proto.String("")})
+	assertCode(t, codes.InvalidArgument, err)
+
+	_, err = client.UpdateUser(ctx, &userspb.UpdateUserRequest{UserId: anna.Id, Age: proto.Int32(-1)})
+	assertCode(t, codes.InvalidArgument, err)
+}
+
+func TestGRPCFriends(t *testing.T) {
+	client := newUserClient(t)
+	ctx := context.Background()
+
+	for _, name := range []string{"Anna", "Boris"} {
+		_, err := client.CreateUser(ctx, &userspb.CreateUserRequest{Name: name})
+		assert.Nil(t, err)
+	}
+
+	_, err := client.AddFriend(ctx, &userspb.AddFriendRequest{SourceId: 1, TargetId: 2})
+	assert.Nil(t, err)
+
+	_, err = client.AddFriend(ctx, &userspb.AddFriendRequest{SourceId: 1, TargetId: 2})
+	assertCode(t, codes.AlreadyExists, err)
+
+	_, err = client.AddFriend(ctx, &userspb.AddFriendRequest{SourceId: 1, TargetId: 7})
+	assertCode(t, codes.NotFound, err)
+
+	friends, err := client.ListFriends(ctx, &userspb.ListFriendsRequest{UserId: 2})
+	assert.Nil(t, err)
+	assert.Len(t, friends.Friends, 1)
+	assert.Equal(t, "Anna", friends.Friends[0].Name)
+
+	user, err := client.GetUser(ctx, &userspb.GetUserRequest{UserId: 2})
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{1}, user.Friends)
+
+	_, err = client.RemoveFriend(ctx, &userspb.RemoveFriendRequest{UserId: 2, FriendId: 1})
+	assert.Nil(t, err)
+
+	friends, err = client.ListFriends(ctx, &userspb.ListFriendsRequest{UserId: 2})
+	assert.Nil(t, err)
+	assert.Empty(t, friends.Friends)
+
+	_, err = client.RemoveFriend(ctx, &userspb.RemoveFriendRequest{UserId: 2, FriendId: 7})
+	assertCode(t, codes.NotFound, err)
+
+	_, err = client.DeleteUser(ctx, &userspb.DeleteUserRequest{UserId: 1})
+	assert.Nil(t, err)
+
+	_, err = client.GetUser(ctx, &userspb.GetUserRequest{UserId: 1})
+	assertCode(t, codes.NotFound, err)
+
+	_, err = client.DeleteUser(ctx, &userspb.DeleteUserRequest{UserId: 1})
+	assertCode(t, codes.NotFound, err)
+}
+
+func TestGRPCAudit(t *testing.T) {
+	store := memstore.New()
+	client := newUserClientWith(t, store)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-actor", "admin", "x-request-id", "req-1")
+
+	anna, err := client.CreateUser(ctx, &userspb.CreateUserRequest{Name: "Anna", Age: 20})
+	assert.Nil(t, err)
+	boris, err := client.CreateUser(ctx, &userspb.CreateUserRequest{Name: "Boris", Age: 25})
+	assert.Nil(t, err)
+
+	_, err = client.AddFriend(ctx, &userspb.AddFriendRequest{SourceId: anna.Id, TargetId: boris.Id})
+	assert.Nil(t, err)
+
+	var header metadata.MD
+	_, err = client.UpdateUser(context.Background(), &userspb.UpdateUserRequest{
+		UserId: anna.Id, Version: anna.Version, Age: proto.Int32(21),
+	}, grpc.Header(&header))
+	assert.Nil(t, err)
+	assert.Len(t, header.Get("x-request-id"), 1)
+
+	_, err = client.DeleteUser(ctx, &userspb.DeleteUserRequest{UserId: 7})
+	assertCode(t, codes.NotFound, err)
+
+	_, err = client.GetUser(ctx, &userspb.GetUserRequest{UserId: anna.Id})
+	assert.Nil(t, err)
+
+	records, err := store.AuditLog(mongogo.AuditQuery{Limit: 10})
+	assert.Nil(t, err)
+	if !assert.Len(t, records, 5) {
+		return
+	}
+
+	// newest first
+	assert.Equal(t, "DeleteUser", records[0].Action)
+	assert.Equal(t, http.StatusNotFound, records[0].Status)
+
+	update := records[1]
+	assert.Equal(t, "UpdateUser", update.Action)
+	assert.Equal(t, api.AUDIT_ANONYMOUS, update.Actor)
+	assert.Empty(t, update.ClaimedActor)
+	assert.Equal(t, "/users.v1.UserService/UpdateUser", update.Path)
+	assert.Equal(t, rpc.AUDIT_METHOD, update.Method)
+	if assert.Len(t, update.Changes, 1) {
+		assert.Equal(t, mongogo.FieldChange{Before: float64(20), After: float64(21)}, update.Changes[0].Fields["age"])
+	}
+
+	assert.Equal(t, "AddFriend", records[2].Action)
+	assert.ElementsMatch(t, []int{1, 2}, records[2].Targets)
+	assert.Len(t, records[2].Changes, 1)
+
+	create := records[4]
+	assert.Equal(t, "CreateUser", create.Action)
+	// the metadata is only a claim, the connection carries no certificate
+	assert.Equal(t, api.AUDIT_ANONYMOUS, create.Actor)
+	assert.Equal(t, "admin", create.ClaimedActor)
+	assert.Equal(t, "req-1", create.RequestId)
+	assert.Equal(t, []int{1}, create.Targets)
+	assert.Equal(t, http.StatusOK, create.Status)
+	if assert.Len(t, create.Changes, 1) {
+		assert.Equal(t, mongogo.FieldChange{Before: nil, After: "Anna"}, create.Changes[0].Fields["name"])
+	}
+}
@@ -0,0 +1,126 @@
+package server_test
+
+import (
+	"crypto/x509"
+	"gin-server/internal/h2"
+	"gin-server/internal/tlsconfig"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// protoHandler answers with the protocol of the request and remembers the
+// connections the requests came over.
+type protoHandler struct {
+	mu    sync.Mutex
+	conns map[string]bool
+}
+
+func (ph *protoHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ph.mu.Lock()
+	ph.conns[r.RemoteAddr] = true
+	ph.mu.Unlock()
+
+	w.Write([]byte(r.Proto))
+}
+
+func getProto(t *testing.T, client *http.Client, url string, headers map[string]string) string {
+	req, err := http.NewRequest("GET", url, nil)
+	assert.NoError(t, err)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := client.Do(req)
+	if !assert.NoError(t, err) {
+		return ""
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err)
+
+	return string(body)
+}
+
+func TestH2C(t *testing.T) {
+	backend := &protoHandler{conns: make(map[string]bool)}
+	server := httptest.NewServer(h2.Handler(backend))
+	defer server.Close()
+
+	// HTTP/1 clients are still served
+	assert.Equal(t, "HTTP/1.1", getProto(t, server.Client(), server.URL, nil))
+
+	transport, err := h2.NewTransport(nil, true)
+	assert.NoError(t, err)
+	defer transport.CloseIdleConnections()
+	client := &http.Client{Transport: transport}
+
+	backend.conns = make(map[string]bool)
+
+	var wg sync.WaitGroup
+	protos := make([]string, 20)
+	for i := range protos {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			protos[i] = getProto(t, client, server.URL, nil)
+		}(i)
+	}
+	wg.Wait()
+
+	for _, proto := range protos {
+		assert.Equal(t, "HTTP/2.0", proto)
+	}
+	assert.Len(t, backend.conns, 1, "the requests share one connection")
+
+	// upgrades cannot be multiplexed
+	headers := map[string]string{"Connection": "Upgrade", "Upgrade": "websocket"}
+	assert.Equal(t, "HTTP/1.1", getProto(t, client, server.URL, headers))
+
+	// without h2c the transport speaks HTTP/1 to http backends
+	transport, err = h2.NewTransport(nil, false)
+	assert.NoError(t, err)
+	client = &http.Client{Transport: transport}
+	assert.Equal(t, "HTTP/1.1", getProto(t, client, server.URL, nil))
+}
+
+func TestH2TLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, dir)
+	certFile, keyFile := ca.issue(t, dir, "server", 2, x509.ExtKeyUsageServerAuth)
+
+	reloader, err := tlsconfig.NewReloader(certFile, keyFile)
+	assert.NoError(t, err)
+	serverConfig, err := tlsconfig.Server(reloader, "")
+	assert.NoError(t, err)
+
+	backend := &protoHandler{conns: make(map[string]bool)}
+	server := &http.Server{Handler: backend, TLSConfig: serverConfig}
+	assert.NoError(t, h2.ConfigureServer(server))
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	go server.ServeTLS(listener, "", "")
+	defer server.Close()
+	url := "https://" + listener.Addr().String()
+
+	clientConfig, err := tlsconfig.Client(ca.file, "", "")
+	assert.NoError(t, err)
+	transport, err := h2.NewTransport(clientConfig, false)
+	assert.NoError(t, err)
+	defer transport.CloseIdleConnections()
+	client := &http.Client{Transport: transport}
+
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, "HTTP/2.0", getProto(t, client, url, nil))
+	}
+	assert.Len(t, backend.conns, 1)
+}
@@ -0,0 +1,187 @@
+package server_test
+
+import (
+	"gin-server/internal/api"
+	"gin-server/internal/httpcache"
+	"gin-server/internal/memstore"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// countingHandler counts the requests that reach the backend and the
+// conditional ones among them.
+type countingHandler struct {
+	next        http.Handler
+	requests    int32
+	conditional int32
+}
+
+func (ch *countingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	atomic.AddInt32(&ch.requests, 1)
+	if r.Header.Get("If-None-Match") != "" {
+		atomic.AddInt32(&ch.conditional, 1)
+	}
+
+	ch.next.ServeHTTP(w, r)
+}
+
+func serveCache(handler http.Handler, method, url, body string, headers map[string]string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(method, url, strings.NewReader(body))
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	handler.ServeHTTP(w, req)
+
+	return w
+}
+
+func TestHTTPCache(t *testing.T) {
+	router := gin.New()
+	api.RegisterRoutes(router, api.Deps{Store: memstore.New()})
+	serve(router, "POST", "/v1/create", `{"name": "Anna", "age": 20}`)
+
+	backend := &countingHandler{next: router}
+	responses := httpcache.New(backend, httpcache.Config{Size: 10})
+
+	// a user may be kept but is revalidated every time
+	w := serveCache(responses, "GET", "/v1/users/1", "", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, httpcache.MISS, w.Header().Get("X-Cache"))
+	assert.Equal(t, `"1"`, w.Header().Get("ETag"))
+
+	w = serveCache(responses, "GET", "/v1/users/1", "", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, httpcache.REVALIDATED, w.Header().Get("X-Cache"))
+	assert.Contains(t, w.Body.String(), "Anna")
+	assert.Equal(t, int32(1), backend.conditional)
+
+	w = serveCache(responses, "GET", "/v1/users/1", "", map[string]string{"If-None-Match": `"1"`})
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Empty(t, w.Body.String())
+
+	// a change through the proxy drops the kept answer of its path
+	serveCache(responses, "PATCH", "/v1/users/1", `{"name": "Anne"}`, nil)
+	w = serveCache(responses, "GET", "/v1/users/1", "", nil)
+	assert.Equal(t, httpcache.MISS, w.Header().Get("X-Cache"))
+	assert.Equal(t, `"2"`, w.Header().Get("ETag"))
+	assert.Contains(t, w.Body.String(), "Anne")
+
+	// the documentation is fresh for a while
+	requests := atomic.LoadInt32(&backend.requests)
+	w = serveCache(responses, "GET", "/v1/openapi.json", "", nil)
+	assert.Equal(t, httpcache.MISS, w.Header().Get("X-Cache"))
+	w = serveCache(responses, "GET", "/v1/openapi.json", "", nil)
+	assert.Equal(t, httpcache.HIT, w.Header().Get("X-Cache"))
+	assert.Equal(t, requests+1, atomic.LoadInt32(&backend.requests))
+
+	w = serveCache(responses, "GET", "/v1/openapi.json", "", map[string]string{"Cache-Control": "no-cache"})
+	assert.Equal(t, requests+2, atomic.LoadInt32(&backend.requests))
+
+	// answers without validators or lifetime are not kept
+	serveCache(responses, "GET", "/v1/friends/1", "", nil)
+	w = serveCache(responses, "GET", "/v1/friends/1", "", nil)
+	assert.Equal(t, httpcache.MISS, w.Header().Get("X-Cache"))
+
+	w = serveCache(responses, "GET", "/v1/openapi.json", "", map[string]string{"Authorization": "Bearer secret"})
+	assert.Equal(t, httpcache.BYPASS, w.Header().Get("X-Cache"))
+
+	assert.Equal(t, 2, responses.Purge("/v1/"))
+	assert.Equal(t, 0, responses.Stats().Entries)
+}
+
+func TestHTTPCacheCoalescing(t *testing.T) {
+	release := make(chan struct{})
+	backend := &countingHandler{next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+
+		w.Header().Set("Cache-Control", "max-age=60")
+		if r.URL.Path == "/private" {
+			w.Header().Set("Cache-Control", "private")
+		}
+		w.Write([]byte("answer"))
+	})}
+	responses := httpcache.New(backend, httpcache.Config{})
+
+	for _, path := range []string{"/shared", "/private"} {
+		var wg sync.WaitGroup
+		bodies := make([]string, 10)
+		for i := range bodies {
+			wg.Add(1)
+
+			go func(i int) {
+				defer wg.Done()
+
+				bodies[i] = serveCache(responses, "GET", path, "", nil).Body.String()
+			}(i)
+		}
+
+		time.Sleep(50 * time.Millisecond)
+		release <- struct{}{}
+		if path == "/private" {
+			// every request asks the backend on its own
+			for i := 1; i < len(bodies); i++ {
+				release <- struct{}{}
+			}
+		}
+		wg.Wait()
+
+		for _, body := range bodies {
+			assert.Equal(t, "answer", body)
+		}
+	}
+
+	assert.Equal(t, int32(11), backend.requests)
+	assert.Equal(t, int64(9), responses.Stats().Coalesced)
+}
+
+func TestHTTPCacheVariants(t *testing.T) {
+	backend := &countingHandler{next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte(r.Header.Get("X-Pool") + " " + r.URL.Path))
+	})}
+	responses := httpcache.New(backend, httpcache.Config{Variant: func(r *http.Request) string {
+		return r.Header.Get("X-Pool")
+	}})
+
+	// the pools do not share their answers
+	for _, pool := range []string{"stable", "canary"} {
+		w := serveCache(responses, "GET", "/v1/users/5", "", map[string]string{"X-Pool": pool})
+		assert.Equal(t, httpcache.MISS, w.Header().Get("X-Cache"))
+		assert.Equal(t, pool+" /v1/users/5", w.Body.String())
+	}
+
+	w := serveCache(responses, "GET", "/v1/users/5", "", map[string]string{"X-Pool": "canary"})
+	assert.Equal(t, httpcache.HIT, w.Header().Get("X-Cache"))
+	assert.Equal(t, "canary /v1/users/5", w.Body.String())
+
+	for _, path := range []string{"/v1/friends/5", "/v1/users/5/suggestions?limit=3", "/v1/users/6", "/v1/users/50"} {
+		serveCache(responses, "GET", path, "", map[string]string{"X-Pool": "stable"})
+	}
+	assert.Equal(t, 6, responses.Stats().Entries)
+
+	// a change of user 5 drops every answer about it, in every pool
+	serveCache(responses, "PUT", "/v1/5", `{"age": 30}`, nil)
+	assert.Equal(t, 2, responses.Stats().Entries)
+
+	for path, state := range map[string]string{
+		"/v1/users/5":   httpcache.MISS,
+		"/v1/friends/5": httpcache.MISS,
+		"/v1/users/6":   httpcache.HIT,
+		"/v1/users/50":  httpcache.HIT,
+	} {
+		w := serveCache(responses, "GET", path, "", map[string]string{"X-Pool": "stable"})
+		assert.Equal(t, state, w.Header().Get("X-Cache"), path)
+	}
+
+	assert.Equal(t, 3, responses.Purge("/v1/users/"))
+}
@@ -0,0 +1,77 @@
+package server_test
+
+import (
+	"gin-server/internal/api"
+	"gin-server/internal/memstore"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdempotencyKey(t *testing.T) {
+	router := gin.New()
+	api.RegisterRoutes(router, api.Deps{Store: memstore.New()})
+
+	key := map[string]string{"Idempotency-Key": "create-anna"}
+
+	w := serveWith(router, "POST", "/v1/create", `{"name": "Anna", "age": 20}`, key)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	created := w.Body.String()
+
+	w = serveWith(router, "POST", "/v1/create", `{"name": "Anna", "age": 20}`, key)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, created, w.Body.String())
+	assert.Equal(t, "true", w.Header().Get("Idempotent-Replayed"))
+
+	w = serveWith(router, "POST", "/create", `{"name": "Anna", "age": 20}`, key)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, created, w.Body.String())
+
+	w = serveWith(router, "POST", "/v1/create", `{"name": "Anna", "age": 21}`, key)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	w = serve(router, "GET", "/v1/users/2", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = serveWith(router, "POST", "/v1/create", `{"name": "Boris", "age": 25}`, map[string]string{"Idempotency-Key": "create-boris"})
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Contains(t, w.Body.String(), `"user_id":2`)
+
+	key = map[string]string{"Idempotency-Key": "friends"}
+
+	w = serveWith(router, "POST", "/v1/make_friends", `{"source_id": 1, "target_id": 2}`, key)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	w = serveWith(router, "POST", "/v1/make_friends", `{"source_id": 1, "target_id": 2}`, key)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "true", w.Header().Get("Idempotent-Replayed"))
+
+	w = serve(router, "POST", "/v1/make_friends", `{"source_id": 1, "target_id": 2}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestIdempotencyPanic(t *testing.T) {
+	h := api.NewHandler(api.Deps{Store: memstore.New()})
+	router := gin.New()
+	router.Use(gin.Recovery())
+
+	calls := 0
+	router.POST("/v1/create", h.Idempotent, func(c *gin.Context) {
+		calls++
+		if calls == 1 {
+			panic("handler failed")
+		}
+		c.JSON(http.StatusCreated, gin.H{"ok": true})
+	})
+
+	key := map[string]string{"Idempotency-Key": "panics"}
+
+	w := serveWith(router, "POST", "/v1/create", `{}`, key)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+
+	w = serveWith(router, "POST", "/v1/create", `{}`, key)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, 2, calls)
+}
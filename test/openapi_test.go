@@ -0,0 +1,53 @@
+package server_test
+
+import (
+	"encoding/json"
+	"gin-server/internal/api"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+var pathParam = regexp.MustCompile(`:(\w+)`)
+
+func TestRoutesDocumented(t *testing.T) {
+	assert.Empty(t, api.Undocumented(Router.Routes()))
+
+	router := gin.New()
+	router.GET("/undocumented", api.MethodsList)
+
+	assert.Equal(t, []string{"GET /undocumented"}, api.Undocumented(router.Routes()))
+}
+
+func TestOpenAPI(t *testing.T) {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/v1/openapi.json", nil)
+
+	Router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var spec struct {
+		OpenAPI string                            `json:"openapi"`
+		Paths   map[string]map[string]interface{} `json:"paths"`
+	}
+
+	err := json.Unmarshal(w.Body.Bytes(), &spec)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+	}
+
+	assert.Equal(t, "3.0.3", spec.OpenAPI)
+
+	for _, route := range Router.Routes() {
+		path := pathParam.ReplaceAllString(route.Path, "{$1}")
+
+		assert.Contains(t, spec.Paths[path], strings.ToLower(route.Method))
+	}
+}
@@ -0,0 +1,74 @@
+package server_test
+
+import (
+	"encoding/json"
+	"gin-server/internal/api"
+	"gin-server/internal/memstore"
+	"gin-server/internal/mongogo"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+type AnswerUser struct {
+	Ok       bool         `json:"ok"`
+	Response mongogo.User `json:"response"`
+}
+
+func userAnswer(t *testing.T, router *gin.Engine, method, url, body string, code int) mongogo.User {
+	w := serve(router, method, url, body)
+
+	assert.Equal(t, code, w.Code)
+
+	var answer AnswerUser
+
+	err := json.Unmarshal(w.Body.Bytes(), &answer)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+	}
+
+	return answer.Response
+}
+
+func TestProfile(t *testing.T) {
+	router := gin.New()
+	api.RegisterRoutes(router, api.Deps{Store: memstore.New()})
+
+	w := serve(router, "POST", "/v1/create", `{"name": "Anna", "age": 20, "email": "anna@example.com", "bio": "hi"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	user := userAnswer(t, router, "GET", "/v1/users/1", "", http.StatusOK)
+	assert.Equal(t, "anna@example.com", user.Email)
+	assert.Equal(t, "Anna", user.DisplayName)
+	assert.Equal(t, "hi", user.Bio)
+	assert.False(t, user.CreatedAt.IsZero())
+	assert.Equal(t, user.CreatedAt, user.UpdatedAt)
+
+	w = serve(router, "POST", "/v1/create", `{"name": "Other Anna", "age": 21, "email": "anna@example.com"}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	w = serve(router, "POST", "/v1/create", `{"name": "Boris", "age": 25, "email": "not an email"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = serve(router, "POST", "/v1/create", `{"name": "Boris", "age": 25, "email": "boris@example.com"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	user = userAnswer(t, router, "PATCH", "/v1/users/1",
+		`{"display_name": "Anna K.", "avatar_url": "https://example.com/anna.png", "bio": ""}`, http.StatusOK)
+	assert.Equal(t, "Anna K.", user.DisplayName)
+	assert.Equal(t, "https://example.com/anna.png", user.AvatarURL)
+	assert.Equal(t, "", user.Bio)
+	assert.Equal(t, 20, user.Age)
+
+	w = serve(router, "PATCH", "/v1/users/1", `{"email": "boris@example.com"}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	w = serve(router, "PATCH", "/v1/users/1", `{"avatar_url": "ftp://example.com/anna.png"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = serve(router, "PATCH", "/v1/users/100", `{"bio": "nobody"}`)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,203 @@
+package server_test
+
+import (
+	"fmt"
+	"gin-server/internal/routing"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const ROUTES = `
+pools:
+  canary: [http://canary:8000]
+  writes: [http://writes:8000, http://writes:8001]
+  api: [http://api:8000]
+rules:
+  - name: canary
+    prefix: /v2
+    pool: canary
+    rewrite: /v1/
+  - name: testers
+    headers: {X-Canary: "yes"}
+    pool: canary
+  - name: writes
+    methods: [post, PUT, PATCH, DELETE]
+    pool: writes
+  - prefix: /api/
+    query: {debug: ""}
+    pool: api
+    strip_prefix: true
+`
+
+func TestRouting(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "routes.yaml")
+	assert.NoError(t, ioutil.WriteFile(file, []byte(ROUTES), 0600))
+
+	config, err := routing.Load(file)
+	assert.NoError(t, err)
+
+	table, err := routing.New(config, []string{"http://default:8000"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"api", "canary", "default", "writes"}, table.PoolNames())
+	assert.Equal(t, []string{"http://writes:8000", "http://writes:8001"}, table.Pools()["writes"])
+
+	cases := []struct {
+		method  string
+		url     string
+		headers map[string]string
+		pool    string
+		path    string
+	}{
+		{"GET", "/v1/users/1", nil, routing.DEFAULT_POOL, "/v1/users/1"},
+		{"GET", "/v2/users/1", nil, "canary", "/v1/users/1"},
+		{"POST", "/v2/create", nil, "canary", "/v1/create"},
+		{"GET", "/v2", nil, "canary", "/v1/"},
+		{"GET", "/v20/users", nil, routing.DEFAULT_POOL, "/v20/users"},
+		{"GET", "/v1/users/1", map[string]string{"X-Canary": "yes"}, "canary", "/v1/users/1"},
+		{"GET", "/v1/users/1", map[string]string{"X-Canary": "no"}, routing.DEFAULT_POOL, "/v1/users/1"},
+		{"POST", "/v1/create", nil, "writes", "/v1/create"},
+		{"DELETE", "/v1/user", nil, "writes", "/v1/user"},
+		{"GET", "/api/v1/users?debug", nil, "api", "/v1/users"},
+		{"GET", "/api/v1/users", nil, routing.DEFAULT_POOL, "/api/v1/users"},
+	}
+
+	for _, c := range cases {
+		req := httptest.NewRequest(c.method, c.url, nil)
+		for name, value := range c.headers {
+			req.Header.Set(name, value)
+		}
+
+		route := table.Match(req)
+		assert.Equal(t, c.pool, route.Pool, "%s %s", c.method, c.url)
+		assert.Equal(t, c.path, route.Path(req.URL.Path), "%s %s", c.method, c.url)
+	}
+
+	// the hosts of a pool take turns
+	req := httptest.NewRequest("POST", "/v1/create", nil)
+	assert.Equal(t, "http://writes:8000", table.Match(req).Host())
+	assert.Equal(t, "http://writes:8001", table.Match(req).Host())
+	assert.Equal(t, "http://writes:8000", table.Match(req).Host())
+
+	// broken tables are refused
+	_, err = routing.New(routing.Config{Rules: []routing.Rule{{Pool: "missing"}}}, []string{"http://default:8000"})
+	assert.Error(t, err)
+
+	_, err = routing.New(routing.Config{Rules: []routing.Rule{{Pool: "default", StripPrefix: true}}}, []string{"http://default:8000"})
+	assert.Error(t, err)
+
+	_, err = routing.New(routing.Config{}, nil)
+	assert.Error(t, err)
+
+	for _, host := range []string{"localhost:8000", "http://", "ftp://default:21", "http://default:port"} {
+		_, err = routing.New(routing.Config{}, []string{host})
+		assert.Error(t, err, host)
+	}
+
+	assert.NoError(t, ioutil.WriteFile(file, []byte("rules:\n  - prefx: /v2\n"), 0600))
+	_, err = routing.Load(file)
+	assert.Error(t, err)
+}
+
+func TestRoutingSplit(t *testing.T) {
+	config := routing.Config{
+		Pools: map[string][]string{"canary": {"http://canary:8000"}},
+		Splits: map[string]routing.Split{
+			"rollout": {
+				Weights:  map[string]int{routing.DEFAULT_POOL: 80, "canary": 20},
+				Sticky:   routing.Sticky{Header: "X-Session", Cookie: "session", UserId: true},
+				Rollback: &routing.Rollback{Pool: "canary", ErrorRate: 0.5, MinRequests: 10},
+			},
+		},
+		Default: "rollout",
+	}
+	table, err := routing.New(config, []string{"http://default:8000"})
+	assert.NoError(t, err)
+
+	poolOf := func(url string, headers map[string]string) string {
+		req := httptest.NewRequest("GET", url, nil)
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+
+		return table.Match(req).Pool
+	}
+
+	// the same key always lands in the same pool, the weights hold overall
+	counts := map[string]int{}
+	sessions := map[string]string{}
+	for i := 0; i < 1000; i++ {
+		session := map[string]string{"X-Session": fmt.Sprint("session-", i)}
+		pool := poolOf("/v1/", session)
+		assert.Equal(t, pool, poolOf("/v1/", session))
+		counts[pool]++
+		sessions[session["X-Session"]] = pool
+	}
+	assert.InDelta(t, 200, counts["canary"], 60)
+	assert.InDelta(t, 800, counts[routing.DEFAULT_POOL], 60)
+
+	cookie := map[string]string{"Cookie": "session=abc"}
+	assert.Equal(t, poolOf("/v1/", cookie), poolOf("/v1/friends/1", cookie))
+	assert.Equal(t, poolOf("/v1/users/42", nil), poolOf("/v1/friends/42", nil))
+
+	// raising the weight of the canary only moves clients into it
+	status, err := table.SetWeights("rollout", map[string]int{"canary": 50, routing.DEFAULT_POOL: 50})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{"canary": 50, routing.DEFAULT_POOL: 50}, status.Weights)
+	for session, pool := range sessions {
+		if pool == "canary" {
+			assert.Equal(t, "canary", poolOf("/v1/", map[string]string{"X-Session": session}))
+		}
+	}
+
+	_, err = table.SetWeights("rollout", map[string]int{"missing": 10})
+	assert.Error(t, err)
+	_, err = table.SetWeights("rollout", map[string]int{"canary": 0, routing.DEFAULT_POOL: 0})
+	assert.Error(t, err)
+	_, err = table.SetWeights("missing", map[string]int{"canary": 10})
+	assert.ErrorIs(t, err, routing.ErrUnknownSplit)
+
+	// failing canary answers take its weight away
+	var canary *routing.Route
+	for i := 0; canary == nil; i++ {
+		req := httptest.NewRequest("GET", fmt.Sprint("/v1/users/", i), nil)
+		if route := table.Match(req); route.Pool == "canary" {
+			canary = route
+		}
+	}
+
+	for i := 0; i < 9; i++ {
+		canary.Done(http.StatusInternalServerError)
+	}
+	assert.False(t, table.Splits()[0].RolledBack, "too few answers to judge")
+
+	canary.Done(http.StatusOK)
+	status = table.Splits()[0]
+	assert.True(t, status.RolledBack)
+	assert.Equal(t, 0, status.Weights["canary"])
+	for i := 0; i < 100; i++ {
+		assert.Equal(t, routing.DEFAULT_POOL, poolOf(fmt.Sprint("/v1/users/", i), nil))
+	}
+
+	// new weights put the canary back
+	status, err = table.SetWeights("rollout", map[string]int{"canary": 10})
+	assert.NoError(t, err)
+	assert.False(t, status.RolledBack)
+	assert.Equal(t, 0, status.Requests)
+
+	// broken splits are refused
+	config.Splits["rollout"] = routing.Split{Weights: map[string]int{"missing": 1}}
+	_, err = routing.New(config, []string{"http://default:8000"})
+	assert.Error(t, err)
+
+	config.Splits["rollout"] = routing.Split{
+		Weights:  map[string]int{"canary": 1},
+		Rollback: &routing.Rollback{Pool: "canary", ErrorRate: 2},
+	}
+	_, err = routing.New(config, []string{"http://default:8000"})
+	assert.Error(t, err)
+}
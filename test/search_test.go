@@ -0,0 +1,92 @@
+package server_test
+
+import (
+	"encoding/json"
+	"gin-server/internal/api"
+	"gin-server/internal/memstore"
+	"gin-server/internal/structs"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+type AnswerSearch struct {
+	Ok       bool                   `json:"ok"`
+	Response structs.SearchResponse `json:"response"`
+}
+
+const searchUsers = `{"id": 1, "name": "Ärger", "age": 30}
+{"id": 2, "name": "ärmel", "age": 25}
+{"id": 3, "name": "Arne", "age": 41}
+{"id": 4, "name": "Юлия", "age": 19}
+{"id": 5, "name": "юрий", "age": 35}
+{"id": 6, "name": "Zoë", "age": 28}
+{"id": 7, "name": "Émile", "age": 50}
+`
+
+func search(t *testing.T, router *gin.Engine, query string) ([]string, int) {
+	w := serve(router, "GET", "/v1/users/search?"+query, "")
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var answer AnswerSearch
+
+	err := json.Unmarshal(w.Body.Bytes(), &answer)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+	}
+
+	names := []string{}
+	for _, user := range answer.Response.Users {
+		names = append(names, user.Name)
+	}
+
+	return names, answer.Response.Total
+}
+
+func TestSearchUsers(t *testing.T) {
+	router := gin.New()
+	api.RegisterRoutes(router, api.Deps{Store: memstore.New()})
+
+	w := serve(router, "POST", "/v1/users/bulk?remap=false", searchUsers)
+	assert.Contains(t, w.Body.String(), `"inserted":7,"failed":0`)
+
+	names, total := search(t, router, "q="+url.QueryEscape("äR"))
+	assert.Equal(t, []string{"Ärger", "ärmel"}, names)
+	assert.Equal(t, 2, total)
+
+	names, _ = search(t, router, "q="+url.QueryEscape("ю"))
+	assert.Equal(t, []string{"Юлия", "юрий"}, names)
+
+	names, _ = search(t, router, "q="+url.QueryEscape("ÉMI"))
+	assert.Equal(t, []string{"Émile"}, names)
+
+	names, _ = search(t, router, "q=ar")
+	assert.Equal(t, []string{"Arne"}, names)
+
+	names, total = search(t, router, "min_age=25&max_age=35&sort=-age")
+	assert.Equal(t, []string{"юрий", "Ärger", "Zoë", "ärmel"}, names)
+	assert.Equal(t, 4, total)
+
+	names, _ = search(t, router, "sort=name&per_page=3")
+	assert.Equal(t, []string{"Ärger", "ärmel", "Arne"}, names)
+
+	names, total = search(t, router, "sort=name&per_page=3&page=2")
+	assert.Equal(t, []string{"Émile", "Zoë", "Юлия"}, names)
+	assert.Equal(t, 7, total)
+
+	names, _ = search(t, router, "per_page=3&page=4")
+	assert.Equal(t, []string{}, names)
+
+	for _, query := range []string{"sort=email", "per_page=500", "page=0", "min_age=-1"} {
+		w := serve(router, "GET", "/v1/users/search?"+query, "")
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+	}
+
+	assert.True(t, strings.HasPrefix(serve(router, "GET", "/v1/users/7", "").Body.String(), `{"ok":true`))
+}
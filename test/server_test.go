@@ -29,28 +29,71 @@ var UserList []mongogo.User
 var Router *gin.Engine
 
 func init() {
+	mgg, err := mongogo.Init(api.MONGODB)
+	if err != nil {
+		fmt.Println(err)
+	}
+
 	Router = gin.Default()
 
-	Router.GET("/", api.MethodsList)              // pass
-	Router.POST("/create", api.CreateUser)        // pass
-	Router.POST("/make_friends", api.MakeFriends) // pass
-	Router.DELETE("/user", api.DeleteUser)
-	Router.GET("/friends/:user_id", api.GetFriends) // pass
-	Router.PUT("/:user_id", api.EditAge)            // pass
+	api.RegisterRoutes(Router, api.Deps{Store: &mgg, AdminToken: ADMIN_TOKEN})
 
 	unitTest.SetRouter(Router)
 }
 
 func methodListAnswer() string {
-	answer := "GET    /                  - methods list\n"
-	answer += "POST   /create            - create new user           # {name: <username> string, age: <age> int}\n"
-	answer += "POST   /make_friends      - add friend to target user # {source_id: <user_id> int, target_id: <user_id> int}\n"
-	answer += "GET    /friends/{user_id} - get friend list\n"
-	answer += "DELETE /{user_id}         - delete user               # {target_id: <user_id> int}\n"
+	answer := "GET    /v1/                                     - methods list\n"
+	answer += "POST   /v1/create                               - create new user            # {name: string, age: int, email: string, display_name: string, bio: string, avatar_url: string}\n"
+	answer += "POST   /v1/make_friends                         - add friend to target user  # {source_id: int, target_id: int}\n"
+	answer += "GET    /v1/friends/:user_id                     - get friend list\n"
+	answer += "DELETE /v1/user                                 - delete user                # {target_id: int}\n"
+	answer += "PUT    /v1/:user_id                             - edit user age              # {new_age: int}\n"
+	answer += "GET    /v1/openapi.json                         - OpenAPI document\n"
+	answer += "GET    /v1/docs                                 - Swagger UI\n"
+	answer += "GET    /v1/users/search                         - search users by name and age\n"
+	answer += "GET    /v1/users/:id                            - get user profile\n"
+	answer += "PATCH  /v1/users/:id                            - update user profile        # {name: *string, age: *int, email: *string, display_name: *string, bio: *string, avatar_url: *string}\n"
+	answer += "POST   /v1/users/bulk                           - import users               # [{id: int, name: string, age: int, friends: []int, email: string, display_name: string, bio: string, avatar_url: string}]\n"
+	answer += "GET    /v1/users/export                         - export users\n"
+	answer += "GET    /v1/users/:id/suggestions                - friend-of-friend suggestions\n"
+	answer += "GET    /v1/users/:id/mutual/:other_id           - mutual friends of two users\n"
+	answer += "GET    /v1/users/:id/path/:other_id             - shortest friendship chain\n"
+	answer += "GET    /v1/users/:id/events                     - stream of user changes (SSE)\n"
+	answer += "GET    /v1/users/:id/events/ws                  - stream of user changes (WebSocket)\n"
+	answer += "POST   /v1/graphql                              - GraphQL queries and mutations # {query: string, operationName: string, variables: map[string]interface {}}\n"
+	answer += "POST   /v1/friend_requests                      - send friend request        # {source_id: int, target_id: int}\n"
+	answer += "POST   /v1/friend_requests/:request_id/accept   - accept friend request      # {user_id: int}\n"
+	answer += "POST   /v1/friend_requests/:request_id/reject   - reject friend request      # {user_id: int}\n"
+	answer += "POST   /v1/friend_requests/:request_id/cancel   - cancel friend request      # {user_id: int}\n"
+	answer += "GET    /v1/users/:id/friend_requests/incoming   - incoming friend requests\n"
+	answer += "GET    /v1/users/:id/friend_requests/outgoing   - outgoing friend requests\n"
+	answer += "GET    /v1/users/:id/blocks                     - blocked users\n"
+	answer += "POST   /v1/users/:id/blocks                     - block user                 # {target_id: int}\n"
+	answer += "DELETE /v1/users/:id/blocks/:other_id           - unblock user\n"
+	answer += "POST   /v1/admin/users/:id/restore              - restore deleted user\n"
+	answer += "GET    /v1/admin/events                         - user events outbox\n"
+	answer += "POST   /v1/admin/webhooks                       - register webhook           # {url: string, secret: string, events: []string}\n"
+	answer += "GET    /v1/admin/webhooks                       - list webhooks\n"
+	answer += "DELETE /v1/admin/webhooks/:id                   - delete webhook\n"
+	answer += "GET    /v1/admin/webhooks/:id/deliveries        - webhook delivery log\n"
+	answer += "GET    /v1/admin/audit                          - audit log of changes\n"
+	answer += "GET    /v1/admin/cache                          - user cache counters\n"
 
 	return answer
 }
+
 func TestMethodsList(t *testing.T) {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/v1/", nil)
+
+	Router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, methodListAnswer(), w.Body.String())
+	assert.Empty(t, w.Header().Get("Deprecation"))
+}
+
+func TestDeprecatedAliases(t *testing.T) {
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest("GET", "/", nil)
 
@@ -58,6 +101,8 @@ func TestMethodsList(t *testing.T) {
 
 	assert.Equal(t, http.StatusOK, w.Code)
 	assert.Equal(t, methodListAnswer(), w.Body.String())
+	assert.Equal(t, "true", w.Header().Get("Deprecation"))
+	assert.Equal(t, "</v1/>; rel=\"successor-version\"", w.Header().Get("Link"))
 }
 
 func TestCreateUser(t *testing.T) {
@@ -72,7 +117,7 @@ func TestCreateUser(t *testing.T) {
 		bytesResp, _ := json.Marshal(resp)
 
 		w := httptest.NewRecorder()
-		req, _ := http.NewRequest("POST", "/create", strings.NewReader(string(bytesResp)))
+		req, _ := http.NewRequest("POST", "/v1/create", strings.NewReader(string(bytesResp)))
 
 		Router.ServeHTTP(w, req)
 
@@ -131,7 +176,7 @@ func TestMakeFriends(t *testing.T) {
 			bytesResp, _ := json.Marshal(resp)
 
 			w := httptest.NewRecorder()
-			req, _ := http.NewRequest("POST", "/make_friends", strings.NewReader(string(bytesResp)))
+			req, _ := http.NewRequest("POST", "/v1/make_friends", strings.NewReader(string(bytesResp)))
 
 			Router.ServeHTTP(w, req)
 
@@ -155,7 +200,7 @@ func TestMakeFriends(t *testing.T) {
 func TestGetFriends(t *testing.T) {
 	for _, user := range UserList {
 		w := httptest.NewRecorder()
-		req, _ := http.NewRequest("GET", fmt.Sprintf("/friends/%d", user.Id), nil)
+		req, _ := http.NewRequest("GET", fmt.Sprintf("/v1/friends/%d", user.Id), nil)
 
 		Router.ServeHTTP(w, req)
 
@@ -205,7 +250,7 @@ func TestEditAge(t *testing.T) {
 		bytesResp, _ := json.Marshal(resp)
 
 		w := httptest.NewRecorder()
-		req, _ := http.NewRequest("PUT", fmt.Sprintf("/%d", user.Id), strings.NewReader(string(bytesResp)))
+		req, _ := http.NewRequest("PUT", fmt.Sprintf("/v1/%d", user.Id), strings.NewReader(string(bytesResp)))
 
 		Router.ServeHTTP(w, req)
 
@@ -243,7 +288,7 @@ func TestDeleteUser(t *testing.T) {
 		bytesResp, _ := json.Marshal(resp)
 
 		w := httptest.NewRecorder()
-		req, _ := http.NewRequest("DELETE", "/user", strings.NewReader(string(bytesResp)))
+		req, _ := http.NewRequest("DELETE", "/v1/user", strings.NewReader(string(bytesResp)))
 
 		Router.ServeHTTP(w, req)
 
@@ -0,0 +1,79 @@
+package server_test
+
+import (
+	"gin-server/internal/api"
+	"gin-server/internal/memstore"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSoftDelete(t *testing.T) {
+	store := memstore.New()
+	router := newGraphRouterWith(t, store)
+
+	w := serve(router, "DELETE", "/v1/user", `{"target_id": 3}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	assert.Equal(t, []int{2}, userIds(friendsAnswer(t, router, "/v1/friends/1", http.StatusOK)))
+	friendsAnswer(t, router, "/v1/friends/3", http.StatusBadRequest)
+	friendsAnswer(t, router, "/v1/users/1/path/5", http.StatusNotFound)
+	assert.NotNil(t, store.CheckIds([]int{3}))
+	assert.Nil(t, store.FriendExists(3, 1))
+
+	w = serveAdmin(router, "POST", "/v1/admin/users/3/restore", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	assert.Equal(t, []int{2, 3}, userIds(friendsAnswer(t, router, "/v1/friends/1", http.StatusOK)))
+	assert.Equal(t, []int{1, 3, 5}, userIds(friendsAnswer(t, router, "/v1/users/1/path/5", http.StatusOK)))
+
+	w = serveAdmin(router, "POST", "/v1/admin/users/3/restore", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestPurgeUsers(t *testing.T) {
+	store := memstore.New()
+	router := newGraphRouterWith(t, store)
+
+	w := serve(router, "DELETE", "/v1/user", `{"target_id": 3}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	purged, err := store.PurgeUsers(time.Now().Add(-time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, purged)
+
+	purged, err = store.PurgeUsers(time.Now().Add(time.Second))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, purged)
+
+	w = serveAdmin(router, "POST", "/v1/admin/users/3/restore", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	user, err := store.GetUser(4)
+	assert.Nil(t, err)
+	assert.Equal(t, []int{2, 6}, user.Friends)
+}
+
+func TestAdminToken(t *testing.T) {
+	router := newGraphRouter(t)
+
+	for _, header := range []map[string]string{nil, {"Authorization": "Bearer wrong"}, {"Authorization": ADMIN_TOKEN}} {
+		for _, route := range [][2]string{{"GET", "/v1/admin/events"}, {"POST", "/v1/admin/users/3/restore"}, {"GET", "/v1/admin/webhooks"}} {
+			w := serveWith(router, route[0], route[1], "", header)
+			assert.Equal(t, http.StatusUnauthorized, w.Code, route[1])
+			assert.Equal(t, "Bearer", w.Header().Get("WWW-Authenticate"))
+		}
+	}
+
+	assert.Equal(t, http.StatusOK, serveAdmin(router, "GET", "/v1/admin/events", "").Code)
+
+	// a server without a token refuses every admin request
+	router = gin.New()
+	api.RegisterRoutes(router, api.Deps{Store: memstore.New()})
+
+	w := serveAdmin(router, "GET", "/v1/admin/events", "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
@@ -0,0 +1,175 @@
+package server_test
+
+import (
+	"bufio"
+	"gin-server/internal/api"
+	"gin-server/internal/events"
+	"gin-server/internal/memstore"
+	"gin-server/internal/mongogo"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func newStreamServer(t *testing.T) (*httptest.Server, *gin.Engine, *events.Hub) {
+	store := memstore.New()
+	hub := events.NewHub(store)
+
+	router := gin.New()
+	api.RegisterRoutes(router, api.Deps{Store: store, Hub: hub, Origins: []string{"https://app.example"}})
+
+	serve(router, "POST", "/v1/create", `{"name": "Anna", "age": 20}`)
+	serve(router, "POST", "/v1/create", `{"name": "Boris", "age": 25}`)
+
+	// the first poll starts the hub after the users are created
+	assert.NoError(t, hub.Poll())
+
+	return httptest.NewServer(router), router, hub
+}
+
+// readSSE reads the next event of a stream as "id event" and skips comments.
+func readSSE(t *testing.T, reader *bufio.Reader) string {
+	result := make(chan string, 1)
+
+	go func() {
+		var fields []string
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				result <- err.Error()
+				return
+			}
+
+			line = strings.TrimRight(line, "\n")
+			if line == "" && len(fields) > 0 {
+				result <- strings.Join(fields, " ")
+				return
+			}
+			if strings.HasPrefix(line, "id: ") || strings.HasPrefix(line, "event: ") {
+				fields = append(fields, line[strings.Index(line, " ")+1:])
+			}
+		}
+	}()
+
+	select {
+	case event := <-result:
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event in time")
+		return ""
+	}
+}
+
+func TestUserEventsSSE(t *testing.T) {
+	server, router, hub := newStreamServer(t)
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL+"/v1/users/1/events", nil)
+	req.Header.Set("Last-Event-ID", "2")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	serve(router, "PUT", "/v1/1", `{"new_age": 21}`)
+	serve(router, "PUT", "/v1/2", `{"new_age": 26}`)
+	serve(router, "POST", "/v1/make_friends", `{"source_id": 2, "target_id": 1}`)
+	assert.NoError(t, hub.Poll())
+
+	reader := bufio.NewReader(resp.Body)
+	assert.Equal(t, "3 UserUpdated", readSSE(t, reader))
+	assert.Equal(t, "5 FriendAdded", readSSE(t, reader))
+
+	// a reconnecting client gets what it has missed
+	req.Header.Set("Last-Event-ID", "3")
+
+	replay, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replay.Body.Close()
+
+	assert.Equal(t, "5 FriendAdded", readSSE(t, bufio.NewReader(replay.Body)))
+}
+
+func TestUserEventsWebSocket(t *testing.T) {
+	server, router, hub := newStreamServer(t)
+	defer server.Close()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/v1/users/1/events/ws"
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	serve(router, "PATCH", "/v1/users/1", `{"bio": "hi"}`)
+	assert.NoError(t, hub.Poll())
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	var event mongogo.Event
+	assert.NoError(t, conn.ReadJSON(&event))
+	assert.Equal(t, mongogo.USER_UPDATED, event.Type)
+	assert.Equal(t, "hi", event.User.Bio)
+}
+
+func TestUserEventsWebSocketOrigin(t *testing.T) {
+	server, _, _ := newStreamServer(t)
+	defer server.Close()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/v1/users/1/events/ws"
+
+	for origin, allowed := range map[string]bool{
+		server.URL:                true,
+		"https://app.example":     true,
+		"https://evil.example":    false,
+		"http://app.example:8080": false,
+		"null":                    false,
+	} {
+		conn, response, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {origin}})
+		if allowed {
+			assert.NoError(t, err, origin)
+			if err == nil {
+				conn.Close()
+			}
+			continue
+		}
+
+		assert.Error(t, err, origin)
+		if assert.NotNil(t, response, origin) {
+			assert.Equal(t, http.StatusForbidden, response.StatusCode, origin)
+		}
+	}
+
+	header := http.Header{"Origin": {"https://api.example"}, "X-Forwarded-Host": {"api.example"}}
+	conn, _, err := websocket.DefaultDialer.Dial(url, header)
+	if assert.NoError(t, err) {
+		conn.Close()
+	}
+}
+
+func TestUserEventsErrors(t *testing.T) {
+	_, router, _ := newStreamServer(t)
+
+	w := serve(router, "GET", "/v1/users/100/events", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	router = gin.New()
+	api.RegisterRoutes(router, api.Deps{Store: memstore.New()})
+
+	w = serve(router, "GET", "/v1/users/1/events", "")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
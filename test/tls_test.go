@@ -0,0 +1,199 @@
+package server_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"gin-server/internal/api"
+	"gin-server/internal/memstore"
+	"gin-server/internal/mongogo"
+	"gin-server/internal/tlsconfig"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	file string
+}
+
+// newTestCA writes a self-signed CA to dir.
+func newTestCA(t *testing.T, dir string) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+
+	file := filepath.Join(dir, "ca.pem")
+	writePEM(t, file, "CERTIFICATE", der)
+
+	return &testCA{cert: cert, key: key, file: file}
+}
+
+// issue writes a certificate signed by the CA and its key to dir, for the
+// server on 127.0.0.1 or for a client.
+func (ca *testCA) issue(t *testing.T, dir, name string, serial int64, usage x509.ExtKeyUsage) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	assert.NoError(t, err)
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+
+	certFile, keyFile := filepath.Join(dir, name+".pem"), filepath.Join(dir, name+"-key.pem")
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDer)
+
+	return certFile, keyFile
+}
+
+func writePEM(t *testing.T, file, kind string, der []byte) {
+	err := ioutil.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: kind, Bytes: der}), 0600)
+	assert.NoError(t, err)
+}
+
+func TestTLSClientCertificates(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, dir)
+	serverCert, serverKey := ca.issue(t, dir, "server", 2, x509.ExtKeyUsageServerAuth)
+	clientCert, clientKey := ca.issue(t, dir, "client", 3, x509.ExtKeyUsageClientAuth)
+
+	reloader, err := tlsconfig.NewReloader(serverCert, serverKey)
+	assert.NoError(t, err)
+	serverConfig, err := tlsconfig.Server(reloader, ca.file)
+	assert.NoError(t, err)
+
+	store := memstore.New()
+	router := gin.New()
+	api.RegisterRoutes(router, api.Deps{Store: store})
+
+	// served like cmd/server does, httptest would put its own certificate first
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	server := &http.Server{Handler: router, TLSConfig: serverConfig}
+	go server.ServeTLS(listener, "", "")
+	defer server.Close()
+	url := "https://" + listener.Addr().String() + "/v1/"
+
+	clientConfig, err := tlsconfig.Client(ca.file, clientCert, clientKey)
+	assert.NoError(t, err)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientConfig}}
+
+	resp, err := client.Get(url)
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	// changes are audited as the subject of the certificate
+	req, _ := http.NewRequest("POST", url+"create", strings.NewReader(`{"name": "Anna", "age": 20}`))
+	req.Header.Set("X-Actor", "mallory")
+	resp, err = client.Do(req)
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+		assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	}
+
+	records, err := store.AuditLog(mongogo.AuditQuery{Limit: 1})
+	if assert.NoError(t, err) && assert.Len(t, records, 1) {
+		assert.Equal(t, "cert:client", records[0].Actor)
+		assert.Equal(t, "mallory", records[0].ClaimedActor)
+	}
+
+	// without a client certificate the handshake fails
+	anonymousConfig, err := tlsconfig.Client(ca.file, "", "")
+	assert.NoError(t, err)
+	client = &http.Client{Transport: &http.Transport{TLSClientConfig: anonymousConfig}}
+
+	_, err = client.Get(url)
+	assert.Error(t, err)
+}
+
+func TestTLSReload(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, dir)
+	certFile, keyFile := ca.issue(t, dir, "server", 2, x509.ExtKeyUsageServerAuth)
+
+	reloader, err := tlsconfig.NewReloader(certFile, keyFile)
+	assert.NoError(t, err)
+
+	serial := func() int64 {
+		cert, err := reloader.GetCertificate(nil)
+		assert.NoError(t, err)
+
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		assert.NoError(t, err)
+
+		return leaf.SerialNumber.Int64()
+	}
+	assert.Equal(t, int64(2), serial())
+
+	reloaded, err := reloader.Reload()
+	assert.NoError(t, err)
+	assert.False(t, reloaded, "files did not change")
+
+	// a new certificate replaces the files
+	newDir := t.TempDir()
+	newCert, newKey := ca.issue(t, newDir, "server", 4, x509.ExtKeyUsageServerAuth)
+	for from, to := range map[string]string{newCert: certFile, newKey: keyFile} {
+		data, err := ioutil.ReadFile(from)
+		assert.NoError(t, err)
+		assert.NoError(t, ioutil.WriteFile(to, data, 0600))
+
+		later := time.Now().Add(time.Minute)
+		assert.NoError(t, os.Chtimes(to, later, later))
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go reloader.Run(10*time.Millisecond, stop)
+
+	assert.Eventually(t, func() bool { return serial() == 4 }, time.Second, 10*time.Millisecond)
+
+	// a broken key keeps the certificate that works
+	assert.NoError(t, ioutil.WriteFile(keyFile, []byte("broken"), 0600))
+	later := time.Now().Add(2 * time.Minute)
+	assert.NoError(t, os.Chtimes(keyFile, later, later))
+
+	_, err = reloader.Reload()
+	assert.Error(t, err)
+	assert.Equal(t, int64(4), serial())
+}
@@ -0,0 +1,198 @@
+package server_test
+
+import (
+	"encoding/json"
+	"gin-server/internal/api"
+	"gin-server/internal/events"
+	"gin-server/internal/memstore"
+	"gin-server/internal/mongogo"
+	"gin-server/internal/webhooks"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+type AnswerDeliveries struct {
+	Ok       bool               `json:"ok"`
+	Response []mongogo.Delivery `json:"response"`
+}
+
+// receiver records the events of valid signed requests and answers status.
+type receiver struct {
+	mu     sync.Mutex
+	status int
+	events []string
+	forged int
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	body, _ := ioutil.ReadAll(req.Body)
+	if !webhooks.Verify("s3cret", body, req.Header.Get(webhooks.SIGNATURE_HEADER)) {
+		r.forged++
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	var event mongogo.Event
+	json.Unmarshal(body, &event)
+	r.events = append(r.events, req.Header.Get(webhooks.EVENT_HEADER)+" "+event.Type)
+
+	w.WriteHeader(r.status)
+}
+
+func deliveries(t *testing.T, router *gin.Engine, url string) []mongogo.Delivery {
+	w := serveAdmin(router, "GET", url, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var answer AnswerDeliveries
+	err := json.Unmarshal(w.Body.Bytes(), &answer)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+	}
+
+	return answer.Response
+}
+
+func TestWebhooks(t *testing.T) {
+	hook := &receiver{status: http.StatusOK}
+	server := httptest.NewServer(hook)
+	defer server.Close()
+
+	loopback, err := webhooks.ParseNetworks([]string{"127.0.0.1"})
+	assert.NoError(t, err)
+
+	store := memstore.New()
+	router := gin.New()
+	api.RegisterRoutes(router, api.Deps{Store: store, AdminToken: ADMIN_TOKEN, WebhookNetworks: loopback})
+
+	sender := webhooks.NewSender(store)
+	sender.Allowed = loopback
+	dispatcher := events.NewDispatcher(store)
+	dispatcher.Subscribe("webhooks", sender.Enqueue)
+
+	w := serveAdmin(router, "POST", "/v1/admin/webhooks", `{"url": "`+server.URL+`", "secret": "s3cret", "events": ["UserCreated", "FriendAdded"]}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.NotContains(t, w.Body.String(), "s3cret")
+
+	w = serveAdmin(router, "POST", "/v1/admin/webhooks", `{"url": "ftp://example.com", "secret": "s3cret"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = serveAdmin(router, "POST", "/v1/admin/webhooks", `{"url": "`+server.URL+`", "secret": "s3cret", "events": ["UserRenamed"]}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	serve(router, "POST", "/v1/create", `{"name": "Anna", "age": 20}`)
+	serve(router, "POST", "/v1/create", `{"name": "Boris", "age": 25}`)
+	serve(router, "PUT", "/v1/1", `{"new_age": 21}`)
+	serve(router, "POST", "/v1/make_friends", `{"source_id": 2, "target_id": 1}`)
+
+	now := time.Now()
+	assert.NoError(t, dispatcher.Dispatch())
+	assert.NoError(t, sender.Send(now))
+
+	assert.Equal(t, []string{"UserCreated UserCreated", "UserCreated UserCreated", "FriendAdded FriendAdded"}, hook.events)
+	assert.Equal(t, 0, hook.forged)
+
+	log := deliveries(t, router, "/v1/admin/webhooks/1/deliveries?state=delivered")
+	assert.Len(t, log, 3)
+	assert.Equal(t, 1, log[0].Attempts)
+	assert.Equal(t, http.StatusOK, log[0].LastStatus)
+
+	// a failing receiver is retried with backoff until the delivery is dead
+	hook.status = http.StatusInternalServerError
+	serve(router, "POST", "/v1/create", `{"name": "Clara", "age": 30}`)
+	assert.NoError(t, dispatcher.Dispatch())
+
+	now = time.Now()
+
+	backoff := []time.Duration{0, 10 * time.Second, 20 * time.Second, 40 * time.Second, 80 * time.Second,
+		160 * time.Second, 320 * time.Second, 640 * time.Second}
+	for i, wait := range backoff {
+		now = now.Add(wait)
+
+		assert.NoError(t, sender.Send(now.Add(-time.Millisecond)))
+		assert.Len(t, hook.events, 3+i, "attempt %d is not due yet", i+1)
+
+		assert.NoError(t, sender.Send(now))
+		assert.Len(t, hook.events, 4+i)
+	}
+
+	log = deliveries(t, router, "/v1/admin/webhooks/1/deliveries?state=dead")
+	assert.Len(t, log, 1)
+	assert.Equal(t, webhooks.ATTEMPTS, log[0].Attempts)
+	assert.Equal(t, http.StatusInternalServerError, log[0].LastStatus)
+	assert.Equal(t, "Clara", log[0].Event.User.Name)
+
+	assert.NoError(t, sender.Send(now.Add(24*time.Hour)))
+	assert.Len(t, hook.events, 3+webhooks.ATTEMPTS)
+
+	// the deliveries of a deleted hook are given up
+	hook.status = http.StatusOK
+	serve(router, "POST", "/v1/create", `{"name": "Dora", "age": 40}`)
+	assert.NoError(t, dispatcher.Dispatch())
+
+	w = serveAdmin(router, "DELETE", "/v1/admin/webhooks/1", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = serveAdmin(router, "DELETE", "/v1/admin/webhooks/1", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	assert.NoError(t, sender.Send(now.Add(24*time.Hour)))
+	assert.Len(t, hook.events, 3+webhooks.ATTEMPTS)
+
+	due, err := store.DueDeliveries(now.Add(48*time.Hour), 10)
+	assert.NoError(t, err)
+	assert.Empty(t, due)
+}
+
+func TestWebhookAddresses(t *testing.T) {
+	hook := &receiver{status: http.StatusOK}
+	server := httptest.NewServer(hook)
+	defer server.Close()
+
+	store := memstore.New()
+	router := gin.New()
+	api.RegisterRoutes(router, api.Deps{Store: store, AdminToken: ADMIN_TOKEN})
+
+	for _, url := range []string{server.URL, "http://localhost:8080", "http://169.254.169.254/latest", "http://10.0.0.1", "http://[::1]:80"} {
+		w := serveAdmin(router, "POST", "/v1/admin/webhooks", `{"url": "`+url+`", "secret": "s3cret"}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code, url)
+	}
+
+	// a hook that was allowed when it was registered is refused when it is posted
+	_, err := store.CreateWebhook(mongogo.Webhook{URL: server.URL, Secret: "s3cret"})
+	assert.NoError(t, err)
+
+	sender := webhooks.NewSender(store)
+	dispatcher := events.NewDispatcher(store)
+	dispatcher.Subscribe("webhooks", sender.Enqueue)
+
+	serve(router, "POST", "/v1/create", `{"name": "Anna", "age": 20}`)
+	assert.NoError(t, dispatcher.Dispatch())
+	assert.NoError(t, sender.Send(time.Now()))
+
+	assert.Empty(t, hook.events)
+	log := deliveries(t, router, "/v1/admin/webhooks/1/deliveries")
+	if assert.Len(t, log, 1) {
+		assert.Contains(t, log[0].LastError, "webhooks may not post to 127.0.0.1")
+	}
+
+	networks, err := webhooks.ParseNetworks([]string{"10.1.0.0/16", " ::1 ", ""})
+	assert.NoError(t, err)
+	assert.Len(t, networks, 2)
+	assert.True(t, webhooks.Permitted(net.ParseIP("10.1.2.3"), networks))
+	assert.True(t, webhooks.Permitted(net.ParseIP("::1"), networks))
+	assert.False(t, webhooks.Permitted(net.ParseIP("10.2.0.1"), networks))
+	assert.True(t, webhooks.Permitted(net.ParseIP("93.184.216.34"), nil))
+
+	_, err = webhooks.ParseNetworks([]string{"10.1.0.0/33"})
+	assert.Error(t, err)
+}